@@ -117,8 +117,12 @@ const (
 	TypeHashZiplist   ValueType = 13
 	TypeListQuicklist ValueType = 14
 
-	TypeHashListpack ValueType = 16
-	TypeZsetListpack ValueType = 17
+	TypeHashListpack   ValueType = 16
+	TypeZsetListpack   ValueType = 17
+	TypeListQuicklist2 ValueType = 18
+	TypeSetListpack    ValueType = 20
+
+	quicklistNodePlain = 1
 )
 
 const (
@@ -262,6 +266,31 @@ func (d *decode) readObject(key []byte, typ ValueType, expiry int64) error {
 			d.readZiplist(key, 0, false)
 		}
 		d.event.EndList(key)
+	case TypeListQuicklist2:
+		length, _, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		d.event.StartList(key, int64(-1), expiry)
+		for i := uint32(0); i < length; i++ {
+			container, _, err := d.readLength()
+			if err != nil {
+				return err
+			}
+			value, err := d.readString()
+			if err != nil {
+				return err
+			}
+			if container == quicklistNodePlain {
+				d.event.Rpush(key, value)
+				continue
+			}
+			lp := listpack.NewListpack(value)
+			for j := uint16(0); j < lp.NumElements(); j++ {
+				d.event.Rpush(key, []byte(lp.Next()))
+			}
+		}
+		d.event.EndList(key)
 	case TypeSet:
 		cardinality, _, err := d.readLength()
 		if err != nil {
@@ -302,6 +331,18 @@ func (d *decode) readObject(key []byte, typ ValueType, expiry int64) error {
 			d.event.Zadd(key, score, member)
 		}
 		d.event.EndZSet(key)
+	case TypeSetListpack:
+		value, err := d.readString()
+		if err != nil {
+			return err
+		}
+		lp := listpack.NewListpack(value)
+		count := lp.NumElements()
+		d.event.StartSet(key, int64(count), expiry)
+		for i := uint16(0); i < count; i++ {
+			d.event.Sadd(key, []byte(lp.Next()))
+		}
+		d.event.EndSet(key)
 	case TypeZsetListpack:
 		value, err := d.readString()
 		if err != nil {
@@ -12,6 +12,18 @@ import (
 )
 
 func DecodeDump(p []byte) (interface{}, error) {
+	if len(p) != 0 && isStreamType(p[0]) {
+		// the stream isn't supported by the decoder of cupcake
+		return decodeStreamDump(p)
+	}
+	if len(p) != 0 && (p[0] == RdbTypeModule || p[0] == RdbTypeModule2) {
+		// the module type registered by RegisterModuleType
+		return decodeModuleDump(p)
+	}
+	if len(p) != 0 && isHashWithTTLType(p[0]) {
+		// the hash with the field expiration
+		return decodeHashWithTTLDump(p)
+	}
 	d := &decoder{}
 	if err := rdb.DecodeDump(p, 0, nil, 0, d); err != nil {
 		return nil, errors.Trace(err)
@@ -114,6 +126,7 @@ type Set [][]byte
 
 type HashElement struct {
 	Field, Value []byte
+	ExpireAt     uint64 // expire time of the field in milliseconds, 0 if not set
 }
 
 type ZSetElement struct {
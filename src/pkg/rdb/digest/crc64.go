@@ -89,6 +89,11 @@ func New() hash.Hash64 {
 	return d
 }
 
+// NewWithCrc continues the checksum of the data before.
+func NewWithCrc(crc uint64) hash.Hash64 {
+	return &digest{crc: crc}
+}
+
 func (d *digest) Write(p []byte) (int, error) {
 	d.update(p)
 	return len(p), nil
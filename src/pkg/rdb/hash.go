@@ -0,0 +1,77 @@
+package rdb
+
+import (
+	"github.com/alibaba/RedisShake/pkg/libs/errors"
+	"github.com/alibaba/RedisShake/redis-shake/datastruct/listpack"
+)
+
+func isHashWithTTLType(t byte) bool {
+	return t == RdbTypeHashMetadataPreGa || t == RdbTypeHashListpackExPreGa || t == RdbTypeHashMetadata ||
+		t == RdbTypeHashListpackEx
+}
+
+// decode the dump payload of the hash with the field expiration.
+func decodeHashWithTTLDump(p []byte) (Hash, error) {
+	r, err := newDumpReader(p)
+	if err != nil {
+		return nil, err
+	}
+	return readHashWithTTL(p[0], r)
+}
+
+/*
+ * read the hash with type t, ExpireAt of the field is set if the ttl is given:
+ *   metadata: [min expire], n, [ttl, field, value]...
+ *     the ttl is "expire - min expire + 1" since RdbTypeHashMetadata, 0 if not set.
+ *   listpack ex: [min expire], listpack of [field, value, expire]...
+ */
+func readHashWithTTL(t byte, r *rdbReader) (Hash, error) {
+	var minExpire uint64
+	if t == RdbTypeHashMetadata || t == RdbTypeHashListpackEx {
+		var err error
+		if minExpire, err = r.readUint64(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch t {
+	case RdbTypeHashListpackExPreGa, RdbTypeHashListpackEx:
+		value, err := r.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		lp := listpack.NewListpack(value)
+		n := int(lp.NumElements()) / 3
+		hash := make(Hash, 0, n)
+		for i := 0; i < n; i++ {
+			e := &HashElement{Field: []byte(lp.Next()), Value: []byte(lp.Next())}
+			e.ExpireAt = uint64(lp.NextInteger())
+			hash = append(hash, e)
+		}
+		return hash, nil
+	case RdbTypeHashMetadataPreGa, RdbTypeHashMetadata:
+		n, err := r.ReadLength64()
+		if err != nil {
+			return nil, err
+		}
+		hash := make(Hash, 0, n)
+		for i := uint64(0); i < n; i++ {
+			e := &HashElement{}
+			if e.ExpireAt, err = r.ReadLength64(); err != nil {
+				return nil, err
+			}
+			if t == RdbTypeHashMetadata && e.ExpireAt != 0 {
+				e.ExpireAt += minExpire - 1
+			}
+			if e.Field, err = r.ReadString(); err != nil {
+				return nil, err
+			}
+			if e.Value, err = r.ReadString(); err != nil {
+				return nil, err
+			}
+			hash = append(hash, e)
+		}
+		return hash, nil
+	}
+	return nil, errors.Errorf("unknown hash type %02x", t)
+}
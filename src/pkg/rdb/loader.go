@@ -29,6 +29,30 @@ func NewLoader(r io.Reader) *Loader {
 	return l
 }
 
+// Position is where the loader can continue from.
+type Position struct {
+	Offset int64  // bytes read from the beginning of the rdb
+	Crc    uint64 // checksum of the bytes read
+	Db     uint32 // db selected
+}
+
+// NewLoaderAt continues loading from the position, r starts at the offset and the header has been read.
+func NewLoaderAt(r io.Reader, pos Position) *Loader {
+	l := &Loader{db: pos.Db}
+	l.crc = digest.NewWithCrc(pos.Crc)
+	l.rdbReader = NewRdbReader(io.TeeReader(r, l.crc))
+	l.nread = pos.Offset
+	return l
+}
+
+// Position returns the position after the last entry, false if it's in the middle of a split big key.
+func (l *Loader) Position() (Position, bool) {
+	if l.remainMember != 0 {
+		return Position{}, false
+	}
+	return Position{Offset: l.nread, Crc: l.crc.Sum64(), Db: l.db}, true
+}
+
 func (l *Loader) Header() error {
 	header := make([]byte, 9)
 	if err := l.readFull(header); err != nil {
@@ -67,6 +91,48 @@ type BinEntry struct {
 	NeedReadLen     byte
 	IdleTime        uint32
 	Freq            uint8
+	raw             bool // the value isn't encoded into the dump payload yet
+	unchecked       bool // the value is only framed, the lzf strings aren't decompressed
+}
+
+/*
+ * EncodeValue checks the raw value returned by NextRawEntry like NextBinEntry, i.e., the lzf strings are
+ * decompressed, and then encodes it into the dump payload. It does nothing if the value is encoded.
+ */
+func (e *BinEntry) EncodeValue() error {
+	if !e.raw {
+		return nil
+	}
+	if e.unchecked {
+		if err := e.check(); err != nil {
+			return err
+		}
+		e.unchecked = false
+	}
+	e.Value = createValueDump(e.Type, e.Value)
+	e.raw = false
+	return nil
+}
+
+// read the framed value again with the lzf strings decompressed.
+func (e *BinEntry) check() error {
+	l := &Loader{rdbReader: NewRdbReader(bytes.NewReader(e.Value))}
+	if e.Type == RdbTypeHash && e.NeedReadLen != 1 {
+		// the following part of the split hash has only the fields and values
+		for l.offset() < int64(len(e.Value)) {
+			if _, err := l.ReadString(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if _, err := l.readObjectValue(e.Type, l, false); err != nil {
+		return err
+	}
+	if l.offset() != int64(len(e.Value)) {
+		return errors.Errorf("invalid value length %d, read %d", len(e.Value), l.offset())
+	}
+	return nil
 }
 
 func (e *BinEntry) ObjEntry() (*ObjEntry, error) {
@@ -112,6 +178,24 @@ func (e *ObjEntry) BinEntry() (*BinEntry, error) {
 }
 
 func (l *Loader) NextBinEntry() (*BinEntry, error) {
+	entry, err := l.nextEntry(false)
+	if entry != nil {
+		// the value is checked while reading
+		entry.EncodeValue()
+	}
+	return entry, err
+}
+
+/*
+ * NextRawEntry is like NextBinEntry, but the value of the key and function is the raw rdb value which is only
+ * framed, i.e., the lzf strings aren't decompressed. It's checked and encoded into the dump payload by
+ * EncodeValue, so the decompressing and the encoding which calculates the checksum can run in parallel.
+ */
+func (l *Loader) NextRawEntry() (*BinEntry, error) {
+	return l.nextEntry(true)
+}
+
+func (l *Loader) nextEntry(frameOnly bool) (*BinEntry, error) {
 	var entry = &BinEntry{}
 	for {
 		var t byte
@@ -186,12 +270,14 @@ func (l *Loader) NextBinEntry() (*BinEntry, error) {
 			}
 			entry.Freq = freq
 		case RdbTypeFunction2: //function
-			val, err := l.readObjectValue(t, l)
+			val, err := l.readObjectValue(t, l, frameOnly)
 			if err != nil {
 				return nil, err
 			}
 			entry.Type = t
-			entry.Value = createValueDump(t, val)
+			entry.Value = val
+			entry.raw = true
+			entry.unchecked = frameOnly
 			return entry, nil
 		default:
 			var key []byte
@@ -209,14 +295,16 @@ func (l *Loader) NextBinEntry() (*BinEntry, error) {
 			//log.Debugf("l %p r %p", l, l.rdbReader)
 			//log.Debug("remainMember:", l.remainMember, " key:", string(key[:]), " type:", t)
 			//log.Debug("r.remainMember:", l.rdbReader.remainMember)
-			val, err := l.readObjectValue(t, l)
+			val, err := l.readObjectValue(t, l, frameOnly)
 			if err != nil {
 				return nil, err
 			}
 			entry.DB = l.db
 			entry.Key = key
 			entry.Type = t
-			entry.Value = createValueDump(t, val)
+			entry.Value = val
+			entry.raw = true
+			entry.unchecked = frameOnly
 			// entry.RealMemberCount = l.lastReadCount
 			if l.lastReadCount == l.totMemberCount {
 				entry.RealMemberCount = 0
@@ -5,6 +5,7 @@ package rdb
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"math"
@@ -360,3 +361,367 @@ func TestLoadZSetAndZSetZiplist(t *testing.T) {
 		assert.Must(math.Abs(score+float64(i)) < 1e-10)
 	}
 }
+
+func TestLoaderPosition(t *testing.T) {
+	s := `
+		524544495330303036fe00000a737472696e675f323535c1ff00000873747269
+		6e675f31c0010011737472696e675f343239343936373239360a343239343936
+		373239360011737472696e675f343239343936373239350a3432393439363732
+		39350012737472696e675f2d32313437343833363438c200000080000c737472
+		696e675f3635353335c2ffff00000011737472696e675f323134373438333634
+		380a32313437343833363438000c737472696e675f3635353336c20000010000
+		0a737472696e675f323536c100010011737472696e675f323134373438333634
+		37c2ffffff7fffe49d9f131fb5c3b5
+	`
+	p, err := hex.DecodeString(strings.NewReplacer("\t", "", "\r", "", "\n", "", " ", "").Replace(s))
+	assert.MustNoError(err)
+	l := NewLoader(bytes.NewReader(p))
+	assert.MustNoError(l.Header())
+	for i := 0; i < 4; i++ {
+		e, err := l.NextBinEntry()
+		assert.MustNoError(err)
+		assert.Must(e != nil)
+	}
+	pos, ok := l.Position()
+	assert.Must(ok)
+
+	// continue from the position, the checksum is still validated
+	l = NewLoaderAt(bytes.NewReader(p[pos.Offset:]), pos)
+	var n int
+	for {
+		e, err := l.NextBinEntry()
+		assert.MustNoError(err)
+		if e == nil {
+			break
+		}
+		assert.Must(e.DB == 0)
+		n++
+	}
+	assert.Must(n == 6)
+	assert.MustNoError(l.Footer())
+	end, _ := l.Position()
+	assert.Must(end.Offset == int64(len(p)))
+
+	// broken checksum
+	pos.Crc++
+	l = NewLoaderAt(bytes.NewReader(p[pos.Offset:]), pos)
+	for {
+		e, err := l.NextBinEntry()
+		assert.MustNoError(err)
+		if e == nil {
+			break
+		}
+	}
+	assert.Must(l.Footer() != nil)
+}
+
+func TestLoaderRawEntry(t *testing.T) {
+	s := `
+		524544495330303036fe00000a737472696e675f323535c1ff00000873747269
+		6e675f31c0010011737472696e675f343239343936373239360a343239343936
+		373239360011737472696e675f343239343936373239350a3432393439363732
+		39350012737472696e675f2d32313437343833363438c200000080000c737472
+		696e675f3635353335c2ffff00000011737472696e675f323134373438333634
+		380a32313437343833363438000c737472696e675f3635353336c20000010000
+		0a737472696e675f323536c100010011737472696e675f323134373438333634
+		37c2ffffff7fffe49d9f131fb5c3b5
+	`
+	p, err := hex.DecodeString(strings.NewReplacer("\t", "", "\r", "", "\n", "", " ", "").Replace(s))
+	assert.MustNoError(err)
+	l1, l2 := NewLoader(bytes.NewReader(p)), NewLoader(bytes.NewReader(p))
+	assert.MustNoError(l1.Header())
+	assert.MustNoError(l2.Header())
+	for {
+		e1, err := l1.NextBinEntry()
+		assert.MustNoError(err)
+		e2, err := l2.NextRawEntry()
+		assert.MustNoError(err)
+		if e1 == nil {
+			assert.Must(e2 == nil)
+			break
+		}
+		// the raw entry is the same after encoding
+		assert.Must(bytes.Equal(e1.Key, e2.Key))
+		assert.MustNoError(e2.EncodeValue())
+		assert.Must(bytes.Equal(e1.Value, e2.Value))
+		assert.MustNoError(e2.EncodeValue())
+		assert.Must(bytes.Equal(e1.Value, e2.Value))
+	}
+	assert.MustNoError(l1.Footer())
+	assert.MustNoError(l2.Footer())
+}
+
+func TestLoaderRawEntryLZF(t *testing.T) {
+	// the lzf string of 100 "a": a literal and a back reference of 99 bytes, the length is wrong if it's not 100
+	lzf := func(outlen byte) []byte {
+		return []byte{0xc3, 5, 0x40, outlen, 0, 'a', 0xe0, 90, 0}
+	}
+	for _, outlen := range []byte{100, 101} {
+		var b bytes.Buffer
+		w := NewWriter(&b, nil)
+		assert.MustNoError(w.WriteEntry(0, []byte("a"), 0, createValueDump(RdbTypeString, lzf(outlen))))
+		assert.MustNoError(w.Close())
+
+		l1, l2 := NewLoader(bytes.NewReader(b.Bytes())), NewLoader(bytes.NewReader(b.Bytes()))
+		assert.MustNoError(l1.Header())
+		assert.MustNoError(l2.Header())
+		e1, err1 := l1.NextBinEntry()
+		// the raw entry is only framed, it's decompressed by EncodeValue
+		e2, err := l2.NextRawEntry()
+		assert.MustNoError(err)
+		assert.Must(bytes.Equal(e2.Value, lzf(outlen)))
+		if outlen == 100 {
+			assert.MustNoError(err1)
+			assert.MustNoError(e2.EncodeValue())
+			assert.Must(bytes.Equal(e1.Value, e2.Value))
+			o, err := DecodeDump(e2.Value)
+			assert.MustNoError(err)
+			checkString(t, o, strings.Repeat("a", 100))
+		} else {
+			assert.Must(err1 != nil)
+			assert.Must(e2.EncodeValue() != nil)
+		}
+	}
+}
+
+// build the listpack of the small integers and strings.
+func newTestListpack(items ...interface{}) []byte {
+	var b bytes.Buffer
+	for _, item := range items {
+		switch v := item.(type) {
+		case int:
+			if v < 128 {
+				// 7 bit uint
+				b.Write([]byte{byte(v), 1})
+			} else {
+				// 13 bit int
+				b.Write([]byte{0xc0 | byte(v>>8), byte(v), 2})
+			}
+		case string:
+			// 6 bit string
+			b.WriteByte(0x80 | byte(len(v)))
+			b.WriteString(v)
+			b.WriteByte(byte(1 + len(v)))
+		}
+	}
+	b.WriteByte(0xff)
+	p := make([]byte, 6, 6+b.Len())
+	binary.LittleEndian.PutUint32(p, uint32(6+b.Len()))
+	binary.LittleEndian.PutUint16(p[4:], uint16(len(items)))
+	return append(p, b.Bytes()...)
+}
+
+func TestLoadRdb12Types(t *testing.T) {
+	// the lengths are less than 64
+	str := func(s []byte) []byte {
+		return append([]byte{byte(len(s))}, s...)
+	}
+	id := func(ms, seq uint64) []byte {
+		p := make([]byte, 16)
+		binary.BigEndian.PutUint64(p, ms)
+		binary.BigEndian.PutUint64(p[8:], seq)
+		return p
+	}
+	u64 := func(v uint64) []byte {
+		p := make([]byte, 8)
+		binary.LittleEndian.PutUint64(p, v)
+		return p
+	}
+
+	var b bytes.Buffer
+	b.WriteString("REDIS0012")
+	b.Write([]byte{rdbFlagSelectDB, 0})
+
+	// list: a packed node and a plain node
+	b.WriteByte(RdbTypeQuicklist2)
+	b.Write(str([]byte("list")))
+	b.WriteByte(2)
+	b.WriteByte(QuicklistNodePacked)
+	b.Write(str(newTestListpack("a", 1)))
+	b.WriteByte(QuicklistNodePlain)
+	b.Write(str([]byte("plain")))
+
+	// set
+	b.WriteByte(RdbTypeSetListpack)
+	b.Write(str([]byte("set")))
+	b.Write(str(newTestListpack("x", "y", 3)))
+
+	// stream: 2 entries, a deleted entry and a group with a consumer
+	b.WriteByte(RdbTypeStreamListPacks3)
+	b.Write(str([]byte("stream")))
+	b.WriteByte(1)
+	b.Write(str(id(1000, 0)))
+	b.Write(str(newTestListpack(
+		2, 1, 1, "f", 0, // master entry
+		2, 0, 0, "v1", 4, // same fields
+		1, 0, 1, "v2", 4, // deleted
+		0, 1, 0, 1, "g", "v3", 6, // different fields
+	)))
+	b.Write([]byte{2, 0x40 | 1001>>8, 1001 & 0xff, 0}) // length, last id
+	b.Write([]byte{0x40 | 1000>>8, 1000 & 0xff, 0})    // first id
+	b.Write([]byte{0x40 | 1000>>8, 1000 & 0xff, 1})    // max deleted id
+	b.WriteByte(3)                                     // entries added
+	b.WriteByte(1)
+	b.Write(str([]byte("group")))
+	b.Write([]byte{0x40 | 1000>>8, 1000 & 0xff, 0, 1}) // last id, entries read
+	b.WriteByte(1)
+	b.Write(id(1000, 0))
+	b.Write(u64(123))
+	b.WriteByte(2)
+	b.WriteByte(1)
+	b.Write(str([]byte("consumer")))
+	b.Write(u64(456))
+	b.Write(u64(789))
+	b.WriteByte(1)
+	b.Write(id(1000, 0))
+
+	b.WriteByte(rdbFlagEOF)
+	b.Write(u64(0))
+
+	entries := DecodeHexRdb(t, hex.EncodeToString(b.Bytes()), 3)
+
+	_, obj := getobj(t, entries, "list")
+	val := obj.(List)
+	assert.Must(len(val) == 3)
+	assert.Must(string(val[0]) == "a" && string(val[1]) == "1" && string(val[2]) == "plain")
+
+	_, obj = getobj(t, entries, "set")
+	set := obj.(Set)
+	assert.Must(len(set) == 3)
+	assert.Must(string(set[0]) == "x" && string(set[1]) == "y" && string(set[2]) == "3")
+
+	_, obj = getobj(t, entries, "stream")
+	s := obj.(*Stream)
+	assert.Must(len(s.Entries) == 2 && s.Length == 2)
+	assert.Must(s.Entries[0].Id.String() == "1000-0")
+	assert.Must(string(bytes.Join(s.Entries[0].Fields, []byte(" "))) == "f v1")
+	assert.Must(s.Entries[1].Id.String() == "1001-0")
+	assert.Must(string(bytes.Join(s.Entries[1].Fields, []byte(" "))) == "g v3")
+	assert.Must(s.LastId.String() == "1001-0" && s.FirstId.String() == "1000-0")
+	assert.Must(s.MaxDeletedId.String() == "1000-1" && s.EntriesAdded == 3)
+	assert.Must(len(s.Groups) == 1)
+	g := s.Groups[0]
+	assert.Must(string(g.Name) == "group" && g.LastId.String() == "1000-0" && g.EntriesRead == 1)
+	assert.Must(len(g.Pending) == 1 && g.Pending[0].DeliveryTime == 123 && g.Pending[0].DeliveryCount == 2)
+	assert.Must(len(g.Consumers) == 1)
+	c := g.Consumers[0]
+	assert.Must(string(c.Name) == "consumer" && c.SeenTime == 456 && c.ActiveTime == 789)
+	assert.Must(len(c.Pending) == 1 && c.Pending[0].String() == "1000-0")
+}
+
+func TestLoadHashWithTTL(t *testing.T) {
+	// the lengths are less than 64
+	str := func(s []byte) []byte {
+		return append([]byte{byte(len(s))}, s...)
+	}
+	u64 := func(v uint64) []byte {
+		p := make([]byte, 8)
+		binary.LittleEndian.PutUint64(p, v)
+		return p
+	}
+
+	var b bytes.Buffer
+	b.WriteString("REDIS0012")
+	b.Write([]byte{rdbFlagSelectDB, 0})
+
+	// the ttl is relative to the min expire
+	b.WriteByte(RdbTypeHashMetadata)
+	b.Write(str([]byte("metadata")))
+	b.Write(u64(1000))
+	b.WriteByte(2)
+	b.Write([]byte{0, 1, 'a', 1, '1'})
+	b.Write([]byte{6, 1, 'b', 1, '2'})
+
+	b.WriteByte(RdbTypeHashListpackEx)
+	b.Write(str([]byte("listpack")))
+	b.Write(u64(1000))
+	b.Write(str(newTestListpack("a", 1, 0, "b", 2, 2000)))
+
+	// the ttl is absolute
+	b.WriteByte(RdbTypeHashMetadataPreGa)
+	b.Write(str([]byte("pre_ga")))
+	b.WriteByte(1)
+	b.Write([]byte{0x40 | 2000>>8, 2000 & 0xff, 1, 'c', 1, '3'})
+
+	b.WriteByte(rdbFlagEOF)
+	b.Write(u64(0))
+
+	entries := DecodeHexRdb(t, hex.EncodeToString(b.Bytes()), 3)
+	check := func(key string, expect ...interface{}) {
+		_, obj := getobj(t, entries, key)
+		hash := obj.(Hash)
+		assert.Must(len(hash)*3 == len(expect))
+		for i, e := range hash {
+			assert.Must(string(e.Field) == expect[3*i].(string))
+			assert.Must(string(e.Value) == expect[3*i+1].(string))
+			assert.Must(e.ExpireAt == uint64(expect[3*i+2].(int)))
+		}
+	}
+	check("metadata", "a", "1", 0, "b", "2", 1005)
+	check("listpack", "a", "1", 0, "b", "2", 2000)
+	check("pre_ga", "c", "3", 2000)
+}
+
+func TestLoadModule(t *testing.T) {
+	// the lengths are less than 64
+	str := func(s string) []byte {
+		return append([]byte{byte(len(s))}, s...)
+	}
+	moduleId := func(name string, encver uint64) []byte {
+		var id uint64
+		for i := 0; i < len(name); i++ {
+			id = id<<6 | uint64(strings.IndexByte(moduleTypeNameCharSet, name[i]))
+		}
+		p := make([]byte, 9)
+		p[0] = rdb64bitLen
+		binary.BigEndian.PutUint64(p[1:], id<<10|encver)
+		return p
+	}
+
+	var b bytes.Buffer
+	b.WriteString("REDIS0012")
+	b.Write([]byte{rdbFlagSelectDB, 0})
+
+	// registered
+	b.WriteByte(RdbTypeModule2)
+	b.Write(str("tairhash"))
+	b.Write(moduleId("tairhash-", 0))
+	b.Write([]byte{rdbModuleOpcodeUint, 1})
+	b.Write(append([]byte{rdbModuleOpcodeString}, str("tairhash")...))
+	b.Write(append([]byte{rdbModuleOpcodeString}, str("f")...))
+	b.Write([]byte{rdbModuleOpcodeUint, 3, rdbModuleOpcodeUint, 0})
+	b.Write(append([]byte{rdbModuleOpcodeString}, str("v")...))
+	b.WriteByte(rdbModuleOpcodeEof)
+
+	// unknown, skipped by the opcodes
+	b.WriteByte(RdbTypeModule2)
+	b.Write(str("json"))
+	b.Write(moduleId("ReJSON-RL", 3))
+	b.Write([]byte{rdbModuleOpcodeSint, 1, rdbModuleOpcodeFloat, 0, 0, 0x80, 0x3f})
+	b.Write([]byte{rdbModuleOpcodeDouble, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f})
+	b.Write(append([]byte{rdbModuleOpcodeString}, str("{}")...))
+	b.WriteByte(rdbModuleOpcodeEof)
+
+	b.WriteByte(rdbFlagEOF)
+	b.Write(make([]byte, 8))
+
+	entries := DecodeHexRdb(t, hex.EncodeToString(b.Bytes()), 2)
+
+	e, obj := getobj(t, entries, "tairhash")
+	name, err := ModuleTypeName(e.Value)
+	assert.MustNoError(err)
+	assert.Must(name == "tairhash-" && IsModuleTypeRegistered(name))
+	m := obj.(*Module)
+	assert.Must(m.Name == "tairhash-")
+	cmds := m.Value.Rewrite(e.Key)
+	assert.Must(len(cmds) == 1)
+	assert.Must(fmt.Sprintf("%s", cmds[0][:4]) == "[EXHSET tairhash f v]")
+	assert.Must(cmds[0][4] == "ABS" && cmds[0][5] == uint64(3))
+
+	e, obj = getobj(t, entries, "json")
+	name, err = ModuleTypeName(e.Value)
+	assert.MustNoError(err)
+	assert.Must(name == "ReJSON-RL" && !IsModuleTypeRegistered(name))
+	m = obj.(*Module)
+	assert.Must(m.Name == "ReJSON-RL" && m.Value == nil)
+}
@@ -3,100 +3,343 @@ package rdb
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/alibaba/RedisShake/pkg/libs/errors"
 )
 
-// this function is used to parse rdb module
+/*
+ * The module types are registered by the 9 characters name, e.g., "tairhash-". The registered module value is
+ * parsed by the handler, and it can be rebuilt by the commands if the target can't restore it, e.g., the module
+ * isn't loaded in the target. The value of the unknown module saved by RdbTypeModule2 is skipped by the opcodes.
+ */
+
+// ModuleReader reads the value saved by the module, e.g., RedisModule_LoadUnsigned.
+type ModuleReader interface {
+	LoadUnsigned() (uint64, error)
+	LoadSigned() (int64, error)
+	LoadString() ([]byte, error)
+	LoadDouble() (float64, error)
+	LoadFloat() (float32, error)
+}
+
+// ModuleType parses the value of the module type.
+type ModuleType interface {
+	// Load reads the value with the encoding version in the lower 10 bits of the module id.
+	Load(r ModuleReader, encver uint64) (ModuleValue, error)
+}
+
+// ModuleValue is the value parsed by ModuleType.
+type ModuleValue interface {
+	// Rewrite returns the commands to rebuild the value of the key, e.g., [["EXHSET", key, field, value]...], nil
+	// if it can't be rebuilt by the commands.
+	Rewrite(key []byte) [][]interface{}
+}
+
+// Module is the object decoded from the dump of the module type, Value is nil if the module isn't registered.
+type Module struct {
+	Name  string
+	Value ModuleValue
+}
+
+var moduleTypes = make(map[string]ModuleType)
+
+// RegisterModuleType registers the module type by the name, it should be called in init.
+func RegisterModuleType(name string, t ModuleType) {
+	if len(name) != 9 || strings.Trim(name, moduleTypeNameCharSet) != "" {
+		panic(fmt.Sprintf("invalid module type name[%v]", name))
+	}
+	if _, ok := moduleTypes[name]; ok {
+		panic(fmt.Sprintf("module type[%v] is registered twice", name))
+	}
+	moduleTypes[name] = t
+}
+
+// IsModuleTypeRegistered returns whether the handler of the module type is registered.
+func IsModuleTypeRegistered(name string) bool {
+	_, ok := moduleTypes[name]
+	return ok
+}
+
+// ModuleTypeName returns the name of the module type in the dump payload.
+func ModuleTypeName(p []byte) (string, error) {
+	if len(p) == 0 || p[0] != RdbTypeModule && p[0] != RdbTypeModule2 {
+		return "", errors.Errorf("not a module type")
+	}
+	moduleId, err := NewRdbReader(bytes.NewReader(p[1:])).ReadLength64()
+	if err != nil {
+		return "", err
+	}
+	return moduleTypeNameByID(moduleId), nil
+}
+
+// decode the dump payload of the module type.
+func decodeModuleDump(p []byte) (*Module, error) {
+	r, err := newDumpReader(p)
+	if err != nil {
+		return nil, err
+	}
+	moduleId, err := r.ReadLength64()
+	if err != nil {
+		return nil, err
+	}
+	m := &Module{Name: moduleTypeNameByID(moduleId)}
+	if t, ok := moduleTypes[m.Name]; ok {
+		if m.Value, err = t.Load(&moduleReader{r: r, t: p[0]}, moduleId&1023); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
 
+// this function is used to parse rdb module
 func (r *rdbReader) parseModule(moduleName string, moduleId uint64, t byte, b *bytes.Buffer) ([]byte, error) {
-	// the module providing the 10 bit encoding version in the lower 10 bits of the module ID.
-	encodeVersion := moduleId & 1023
-	switch moduleName {
-	case "tairhash-":
-		// length
-		length, err := r.moduleLoadUnsigned(t)
-		if err != nil {
+	if mt, ok := moduleTypes[moduleName]; ok {
+		// the module providing the 10 bit encoding version in the lower 10 bits of the module ID.
+		if _, err := mt.Load(&moduleReader{r: r, t: t}, moduleId&1023); err != nil {
 			return nil, err
 		}
+	} else if t == RdbTypeModule2 {
+		// unknown module, it's restored or skipped by the caller
+		return b.Bytes(), r.skipModuleValue()
+	} else {
+		return nil, fmt.Errorf("unknown module name[%v] with module id[%v]", moduleName, moduleId)
+	}
 
-		// key
-		if _, err := r.moduleLoadString(t); err != nil {
+	if t == RdbTypeModule2 {
+		code, err := r.ReadLength()
+		if err != nil {
 			return nil, err
+		} else if code != rdbModuleOpcodeEof {
+			return nil, fmt.Errorf("illegal end code[%v] in module type", code)
 		}
+	}
 
-		for i := uint64(0); i < length; i++ {
-			// skey
-			if _, err := r.moduleLoadString(t); err != nil {
-				return nil, err
-			}
+	return b.Bytes(), nil
+}
 
-			// version
-			if _, err := r.moduleLoadUnsigned(t); err != nil {
-				return nil, err
-			}
+// skip the value of RdbTypeModule2 till the eof opcode.
+func (r *rdbReader) skipModuleValue() error {
+	for {
+		opcode, err := r.ReadLength()
+		if err != nil {
+			return err
+		}
+		switch opcode {
+		case rdbModuleOpcodeEof:
+			return nil
+		case rdbModuleOpcodeSint, rdbModuleOpcodeUint:
+			_, err = r.ReadLength64()
+		case rdbModuleOpcodeString:
+			_, err = r.ReadString()
+		case rdbModuleOpcodeFloat:
+			// binary float 32 bits
+			_, err = r.ReadBytes(4)
+		case rdbModuleOpcodeDouble:
+			// binary double 64 bits
+			_, err = r.ReadDouble()
+		default:
+			return fmt.Errorf("unknown opcode[%v] in module type", opcode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
 
-			// expire
-			if _, err := r.moduleLoadUnsigned(t); err != nil {
-				return nil, err
-			}
+// moduleReader reads the value of RdbTypeModule or RdbTypeModule2 which saves the opcode before each value.
+type moduleReader struct {
+	r *rdbReader
+	t byte
+}
 
-			// value
-			if _, err := r.moduleLoadString(t); err != nil {
-				return nil, err
-			}
+func (m *moduleReader) LoadUnsigned() (uint64, error) {
+	return m.r.moduleLoadUnsigned(m.t)
+}
+
+func (m *moduleReader) LoadSigned() (int64, error) {
+	if err := m.r.moduleLoadOpcode(m.t, rdbModuleOpcodeSint); err != nil {
+		return 0, err
+	}
+	val, err := m.r.ReadLength64()
+	return int64(val), err
+}
+
+func (m *moduleReader) LoadString() ([]byte, error) {
+	if err := m.r.moduleLoadOpcode(m.t, rdbModuleOpcodeString); err != nil {
+		return nil, err
+	}
+	return m.r.ReadString()
+}
+
+func (m *moduleReader) LoadDouble() (float64, error) {
+	return m.r.moduleLoadDouble(m.t)
+}
+
+func (m *moduleReader) LoadFloat() (float32, error) {
+	if err := m.r.moduleLoadOpcode(m.t, rdbModuleOpcodeFloat); err != nil {
+		return 0, err
+	}
+	u, err := m.r.readUint32()
+	return math.Float32frombits(u), err
+}
+
+/*
+ * the module types of tair: TairHash, TairString and TairZset.
+ */
+
+func init() {
+	RegisterModuleType("tairhash-", tairHashType{})
+	RegisterModuleType("exstrtype", tairStringType{})
+	RegisterModuleType("tairzset_", tairZsetType{})
+}
+
+type tairHashType struct{}
+
+type tairHashField struct {
+	Field, Value []byte
+	Version      uint64
+	ExpireAt     uint64 // milliseconds, 0 if not set
+}
+
+type tairHash []*tairHashField
+
+func (tairHashType) Load(r ModuleReader, encver uint64) (ModuleValue, error) {
+	// length
+	length, err := r.LoadUnsigned()
+	if err != nil {
+		return nil, err
+	}
+
+	// key
+	if _, err := r.LoadString(); err != nil {
+		return nil, err
+	}
+
+	hash := make(tairHash, 0, length)
+	for i := uint64(0); i < length; i++ {
+		f := &tairHashField{}
+		// skey
+		if f.Field, err = r.LoadString(); err != nil {
+			return nil, err
 		}
-	case "exstrtype":
 		// version
-		if _, err := r.moduleLoadUnsigned(t); err != nil {
+		if f.Version, err = r.LoadUnsigned(); err != nil {
 			return nil, err
 		}
-
-		if encodeVersion == 1 {
-			//flag
-			if _, err := r.moduleLoadUnsigned(t); err != nil {
-				return nil, err
-			}
+		// expire
+		if f.ExpireAt, err = r.LoadUnsigned(); err != nil {
+			return nil, err
 		}
-
 		// value
-		if _, err := r.moduleLoadString(t); err != nil {
+		if f.Value, err = r.LoadString(); err != nil {
 			return nil, err
 		}
+		hash = append(hash, f)
+	}
+	return hash, nil
+}
 
-	case "tairzset_":
-		length, err := r.moduleLoadUnsigned(t)
-		if err != nil {
-			return nil, err
+func (hash tairHash) Rewrite(key []byte) [][]interface{} {
+	cmds := make([][]interface{}, 0, len(hash))
+	for _, f := range hash {
+		cmd := []interface{}{"EXHSET", key, f.Field, f.Value}
+		if f.ExpireAt != 0 {
+			cmd = append(cmd, "PXAT", f.ExpireAt)
 		}
+		cmds = append(cmds, append(cmd, "ABS", f.Version))
+	}
+	return cmds
+}
 
-		score_num, err := r.moduleLoadUnsigned(t)
-		if err != nil {
+type tairStringType struct{}
+
+type tairString struct {
+	Value   []byte
+	Version uint64
+	Flags   uint64
+}
+
+func (tairStringType) Load(r ModuleReader, encver uint64) (ModuleValue, error) {
+	s := &tairString{}
+	var err error
+	// version
+	if s.Version, err = r.LoadUnsigned(); err != nil {
+		return nil, err
+	}
+
+	if encver == 1 {
+		//flag
+		if s.Flags, err = r.LoadUnsigned(); err != nil {
 			return nil, err
 		}
+	}
 
-		for i := uint64(0); i < length; i++ {
-			if _, err := r.moduleLoadString(t); err != nil {
-				return nil, err
-			}
+	// value
+	if s.Value, err = r.LoadString(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
 
-			for j := uint64(0); j < score_num; j++ {
-				if _, err := r.moduleLoadDouble(t); err != nil {
-					return nil, err
-				}
-			}
-		}
+func (s *tairString) Rewrite(key []byte) [][]interface{} {
+	cmd := []interface{}{"EXSET", key, s.Value, "ABS", s.Version}
+	if s.Flags != 0 {
+		cmd = append(cmd, "FLAGS", s.Flags)
+	}
+	return [][]interface{}{cmd}
+}
 
-	default:
-		return nil, fmt.Errorf("unknown module name[%v] with module id[%v]", moduleName, moduleId)
+type tairZsetType struct{}
+
+type tairZsetMember struct {
+	Member []byte
+	Scores []float64
+}
+
+type tairZset []*tairZsetMember
+
+func (tairZsetType) Load(r ModuleReader, encver uint64) (ModuleValue, error) {
+	length, err := r.LoadUnsigned()
+	if err != nil {
+		return nil, err
 	}
 
-	if t == RdbTypeModule2 {
-		code, err := r.ReadLength()
-		if err != nil {
+	scoreNum, err := r.LoadUnsigned()
+	if err != nil {
+		return nil, err
+	}
+
+	zset := make(tairZset, 0, length)
+	for i := uint64(0); i < length; i++ {
+		m := &tairZsetMember{Scores: make([]float64, 0, scoreNum)}
+		if m.Member, err = r.LoadString(); err != nil {
 			return nil, err
-		} else if code != rdbModuleOpcodeEof {
-			return nil, fmt.Errorf("illegal end code[%v] in module type", code)
 		}
+
+		for j := uint64(0); j < scoreNum; j++ {
+			score, err := r.LoadDouble()
+			if err != nil {
+				return nil, err
+			}
+			m.Scores = append(m.Scores, score)
+		}
+		zset = append(zset, m)
 	}
+	return zset, nil
+}
 
-	return b.Bytes(), nil
+func (zset tairZset) Rewrite(key []byte) [][]interface{} {
+	cmds := make([][]interface{}, 0, len(zset))
+	for _, m := range zset {
+		// the multiple scores are separated by '#'
+		scores := make([]string, 0, len(m.Scores))
+		for _, score := range m.Scores {
+			scores = append(scores, strconv.FormatFloat(score, 'g', -1, 64))
+		}
+		cmds = append(cmds, []interface{}{"EXZADD", key, strings.Join(scores, "#"), m.Member})
+	}
+	return cmds
 }
@@ -8,6 +8,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 
 	// "runtime/debug"
@@ -17,7 +18,7 @@ import (
 	"github.com/alibaba/RedisShake/pkg/libs/log"
 )
 
-var FromVersion int64 = 10
+var FromVersion int64 = 12
 var ToVersion int64 = 6
 
 const (
@@ -30,15 +31,23 @@ const (
 	RdbTypeModule  = 6
 	RdbTypeModule2 = 7
 
-	RdbTypeHashZipmap      = 9
-	RdbTypeListZiplist     = 10
-	RdbTypeSetIntset       = 11
-	RdbTypeZSetZiplist     = 12
-	RdbTypeHashZiplist     = 13
-	RdbTypeQuicklist       = 14
-	RDBTypeStreamListPacks = 15 // stream
-	RdbTypeHashListpack    = 16
-	RdbTypeZSetListpack    = 17
+	RdbTypeHashZipmap          = 9
+	RdbTypeListZiplist         = 10
+	RdbTypeSetIntset           = 11
+	RdbTypeZSetZiplist         = 12
+	RdbTypeHashZiplist         = 13
+	RdbTypeQuicklist           = 14
+	RDBTypeStreamListPacks     = 15 // stream
+	RdbTypeHashListpack        = 16
+	RdbTypeZSetListpack        = 17
+	RdbTypeQuicklist2          = 18 // redis 7.0
+	RdbTypeStreamListPacks2    = 19 // redis 7.0
+	RdbTypeSetListpack         = 20 // redis 7.2
+	RdbTypeStreamListPacks3    = 21 // redis 7.2
+	RdbTypeHashMetadataPreGa   = 22 // redis 7.4 rc, hash with the field expiration
+	RdbTypeHashListpackExPreGa = 23 // redis 7.4 rc
+	RdbTypeHashMetadata        = 24 // redis 7.4
+	RdbTypeHashListpackEx      = 25 // redis 7.4
 
 	RdbTypeFunction2 = 0xf5
 	RdbTypeFunction  = 0xf6
@@ -61,6 +70,10 @@ const (
 	rdbModuleOpcodeString = 5
 
 	moduleTypeNameCharSet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+	// container of the quicklist node
+	QuicklistNodePlain  = 1
+	QuicklistNodePacked = 2
 )
 
 const (
@@ -94,6 +107,7 @@ type rdbReader struct {
 	remainMember   uint32
 	lastReadCount  uint32
 	totMemberCount uint32
+	skipLZF        bool // the lzf strings are read without decompressing
 }
 
 func NewRdbReader(r io.Reader) *rdbReader {
@@ -110,9 +124,14 @@ func (r *rdbReader) offset() int64 {
 	return r.nread
 }
 
-func (r *rdbReader) readObjectValue(t byte, l *Loader) ([]byte, error) {
+/*
+ * read the raw value of the object. If frameOnly, the lzf strings aren't decompressed, except in the module
+ * which may parse the strings.
+ */
+func (r *rdbReader) readObjectValue(t byte, l *Loader, frameOnly bool) ([]byte, error) {
 	var b bytes.Buffer
 	r = NewRdbReader(io.TeeReader(r, &b)) // the result will be written into b when calls r.Read()
+	r.skipLZF = frameOnly && t != RdbTypeModule && t != RdbTypeModule2
 	lr := l.rdbReader
 	switch t {
 	default:
@@ -131,7 +150,7 @@ func (r *rdbReader) readObjectValue(t byte, l *Loader) ([]byte, error) {
 		fallthrough
 	case RdbTypeHashZiplist:
 		fallthrough
-	case RdbTypeString, RdbTypeHashListpack, RdbTypeZSetListpack, RdbTypeFunction2:
+	case RdbTypeString, RdbTypeHashListpack, RdbTypeZSetListpack, RdbTypeSetListpack, RdbTypeFunction2:
 		lr.lastReadCount, lr.remainMember, lr.totMemberCount = 0, 0, 0
 		_, err := r.ReadString()
 		if err != nil {
@@ -148,6 +167,56 @@ func (r *rdbReader) readObjectValue(t byte, l *Loader) ([]byte, error) {
 				}
 			}
 		}
+	case RdbTypeHashListpackExPreGa, RdbTypeHashListpackEx:
+		lr.lastReadCount, lr.remainMember, lr.totMemberCount = 0, 0, 0
+		if t == RdbTypeHashListpackEx {
+			// min expire
+			if _, err := r.readUint64(); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := r.ReadString(); err != nil {
+			return nil, err
+		}
+	case RdbTypeHashMetadataPreGa, RdbTypeHashMetadata:
+		lr.lastReadCount, lr.remainMember, lr.totMemberCount = 0, 0, 0
+		if t == RdbTypeHashMetadata {
+			// min expire
+			if _, err := r.readUint64(); err != nil {
+				return nil, err
+			}
+		}
+		n, err := r.ReadLength64()
+		if err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			// ttl, field and value
+			if _, err := r.ReadLength64(); err != nil {
+				return nil, err
+			}
+			if _, err := r.ReadString(); err != nil {
+				return nil, err
+			}
+			if _, err := r.ReadString(); err != nil {
+				return nil, err
+			}
+		}
+	case RdbTypeQuicklist2:
+		lr.lastReadCount, lr.remainMember, lr.totMemberCount = 0, 0, 0
+		if n, err := r.ReadLength(); err != nil {
+			return nil, err
+		} else {
+			for i := 0; i < int(n); i++ {
+				// container
+				if _, err := r.ReadLength(); err != nil {
+					return nil, err
+				}
+				if _, err := r.ReadString(); err != nil {
+					return nil, err
+				}
+			}
+		}
 	case RdbTypeZSet, RdbTypeZSet2:
 		lr.lastReadCount, lr.remainMember, lr.totMemberCount = 0, 0, 0
 		if n, err := r.ReadLength(); err != nil {
@@ -202,7 +271,7 @@ func (r *rdbReader) readObjectValue(t byte, l *Loader) ([]byte, error) {
 		if lr.lastReadCount == n {
 			lr.remainMember = 0
 		}
-	case RDBTypeStreamListPacks:
+	case RDBTypeStreamListPacks, RdbTypeStreamListPacks2, RdbTypeStreamListPacks3:
 		// TODO, need to judge big key
 		lr.lastReadCount, lr.remainMember, lr.totMemberCount = 0, 0, 0
 		// list pack length
@@ -232,6 +301,14 @@ func (r *rdbReader) readObjectValue(t byte, l *Loader) ([]byte, error) {
 		if _, err := r.ReadLength(); err != nil {
 			return nil, err
 		}
+		if t != RDBTypeStreamListPacks {
+			// first_entry_id, max_deleted_entry_id and entries_added
+			for i := 0; i < 5; i++ {
+				if _, err := r.ReadLength(); err != nil {
+					return nil, err
+				}
+			}
+		}
 
 		// cgroups length
 		nCgroups, err := r.ReadLength()
@@ -252,6 +329,12 @@ func (r *rdbReader) readObjectValue(t byte, l *Loader) ([]byte, error) {
 			if _, err := r.ReadLength(); err != nil {
 				return nil, err
 			}
+			if t != RDBTypeStreamListPacks {
+				// entries_read
+				if _, err := r.ReadLength(); err != nil {
+					return nil, err
+				}
+			}
 
 			// pending number
 			nPending, err := r.ReadLength()
@@ -293,6 +376,12 @@ func (r *rdbReader) readObjectValue(t byte, l *Loader) ([]byte, error) {
 				if err := r.readFull(b); err != nil {
 					return nil, err
 				}
+				if t == RdbTypeStreamListPacks3 {
+					// active_time
+					if err := r.readFull(b); err != nil {
+						return nil, err
+					}
+				}
 
 				// pending
 				nPending2, err := r.ReadLength()
@@ -308,7 +397,7 @@ func (r *rdbReader) readObjectValue(t byte, l *Loader) ([]byte, error) {
 				}
 			}
 		}
-	case RdbTypeModule2:
+	case RdbTypeModule, RdbTypeModule2:
 		// skip 64 bit
 		if moduleId, err := r.ReadLength64(); err != nil {
 			return nil, err
@@ -350,6 +439,10 @@ func (r *rdbReader) ReadString() ([]byte, error) {
 		if outlen, err = r.ReadLength(); err != nil {
 			return nil, err
 		}
+		if r.skipLZF {
+			_, err := io.CopyN(ioutil.Discard, r, int64(inlen))
+			return nil, errors.Trace(err)
+		}
 		if in, err := r.ReadBytes(int(inlen)); err != nil {
 			return nil, err
 		} else {
@@ -695,43 +788,29 @@ func moduleTypeNameByID(moduleId uint64) string {
 	return string(nameList)
 }
 
-func (r *rdbReader) moduleLoadUnsigned(rdbType byte) (uint64, error) {
-	if rdbType == RdbTypeModule2 {
-		// ver == 2
-		if opCode, err := r.ReadLength(); err != nil {
-			return 0, err
-		} else if opCode != rdbModuleOpcodeUint {
-			return 0, fmt.Errorf("opcode[%v] != rdbModuleOpcodeUint[%v]", opCode, rdbModuleOpcodeUint)
-		}
+// read the opcode saved before the value of RdbTypeModule2.
+func (r *rdbReader) moduleLoadOpcode(rdbType byte, expect uint32) error {
+	if rdbType != RdbTypeModule2 {
+		return nil
 	}
-
-	val, err := r.ReadLength()
-	return uint64(val), err
+	if opCode, err := r.ReadLength(); err != nil {
+		return err
+	} else if opCode != expect {
+		return fmt.Errorf("opcode[%v] != expected opcode[%v]", opCode, expect)
+	}
+	return nil
 }
 
-func (r *rdbReader) moduleLoadDouble(rdbType byte) (float64, error) {
-	if rdbType == RdbTypeModule2 {
-		// ver == 2
-		if opCode, err := r.ReadLength(); err != nil {
-			return 0, err
-		} else if opCode != rdbModuleOpcodeDouble {
-			return 0, fmt.Errorf("opcode[%v] != rdbModuleOpcodeDouble[%v]", opCode,
-				rdbModuleOpcodeDouble)
-		}
+func (r *rdbReader) moduleLoadUnsigned(rdbType byte) (uint64, error) {
+	if err := r.moduleLoadOpcode(rdbType, rdbModuleOpcodeUint); err != nil {
+		return 0, err
 	}
-
-	return r.ReadDouble()
+	return r.ReadLength64()
 }
 
-func (r *rdbReader) moduleLoadString(rdbType byte) (string, error) {
-	if rdbType == RdbTypeModule2 {
-		if opCode, err := r.ReadLength(); err != nil {
-			return "", err
-		} else if opCode != rdbModuleOpcodeString {
-			return "", fmt.Errorf("opcode[%v] != rdbModuleOpcodeString[%v]", opCode,
-				rdbModuleOpcodeString)
-		}
+func (r *rdbReader) moduleLoadDouble(rdbType byte) (float64, error) {
+	if err := r.moduleLoadOpcode(rdbType, rdbModuleOpcodeDouble); err != nil {
+		return 0, err
 	}
-	ret, err := r.ReadString()
-	return string(ret), err
+	return r.ReadDouble()
 }
@@ -0,0 +1,277 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/alibaba/RedisShake/pkg/libs/errors"
+	"github.com/alibaba/RedisShake/redis-shake/datastruct/listpack"
+)
+
+type Stream struct {
+	Entries      []*StreamEntry
+	Length       uint64 // number of the entries
+	LastId       StreamId
+	FirstId      StreamId // the fields below are saved since RdbTypeStreamListPacks2
+	MaxDeletedId StreamId
+	EntriesAdded uint64
+	Groups       []*StreamGroup
+}
+
+type StreamId struct {
+	Ms, Seq uint64
+}
+
+func (id StreamId) String() string {
+	return fmt.Sprintf("%v-%v", id.Ms, id.Seq)
+}
+
+type StreamEntry struct {
+	Id     StreamId
+	Fields [][]byte // field1, value1, field2, value2...
+}
+
+type StreamGroup struct {
+	Name        []byte
+	LastId      StreamId
+	EntriesRead uint64
+	Pending     []*StreamPending
+	Consumers   []*StreamConsumer
+}
+
+type StreamPending struct {
+	Id            StreamId
+	DeliveryTime  uint64
+	DeliveryCount uint64
+}
+
+type StreamConsumer struct {
+	Name       []byte
+	SeenTime   uint64
+	ActiveTime uint64 // saved since RdbTypeStreamListPacks3
+	Pending    []StreamId
+}
+
+func isStreamType(t byte) bool {
+	return t == RDBTypeStreamListPacks || t == RdbTypeStreamListPacks2 || t == RdbTypeStreamListPacks3
+}
+
+// decode the dump payload of the stream.
+func decodeStreamDump(p []byte) (*Stream, error) {
+	r, err := newDumpReader(p)
+	if err != nil {
+		return nil, err
+	}
+	return readStream(p[0], r)
+}
+
+// the reader of the value in the dump payload, the format of the dump is the same as createValueDump.
+func newDumpReader(p []byte) (*rdbReader, error) {
+	if _, err := dumpVersion(p); err != nil {
+		return nil, err
+	}
+	return NewRdbReader(bytes.NewReader(p[1 : len(p)-10])), nil
+}
+
+/*
+ * read the stream with type t:
+ *   listpacks: n, [master id, listpack]...
+ *   length, last id, first id, max deleted id, entries added
+ *   groups: n, [name, last id, entries read, global pending, consumers]...
+ */
+func readStream(t byte, r *rdbReader) (*Stream, error) {
+	s := &Stream{}
+	nListpack, err := r.ReadLength64()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nListpack; i++ {
+		key, err := r.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := r.ReadString()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 {
+			return nil, errors.Errorf("invalid master id length[%v] of stream", len(key))
+		}
+		master := StreamId{binary.BigEndian.Uint64(key[:8]), binary.BigEndian.Uint64(key[8:])}
+		s.Entries = append(s.Entries, readStreamListpack(master, listpack.NewListpack(value))...)
+	}
+
+	if s.Length, err = r.ReadLength64(); err != nil {
+		return nil, err
+	}
+	if s.LastId, err = r.readStreamId(); err != nil {
+		return nil, err
+	}
+	if t != RDBTypeStreamListPacks {
+		if s.FirstId, err = r.readStreamId(); err != nil {
+			return nil, err
+		}
+		if s.MaxDeletedId, err = r.readStreamId(); err != nil {
+			return nil, err
+		}
+		if s.EntriesAdded, err = r.ReadLength64(); err != nil {
+			return nil, err
+		}
+	}
+
+	nGroup, err := r.ReadLength64()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nGroup; i++ {
+		g := &StreamGroup{}
+		if g.Name, err = r.ReadString(); err != nil {
+			return nil, err
+		}
+		if g.LastId, err = r.readStreamId(); err != nil {
+			return nil, err
+		}
+		if t != RDBTypeStreamListPacks {
+			if g.EntriesRead, err = r.ReadLength64(); err != nil {
+				return nil, err
+			}
+		}
+
+		nPending, err := r.ReadLength64()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < nPending; j++ {
+			pending := &StreamPending{}
+			if pending.Id, err = r.readRawStreamId(); err != nil {
+				return nil, err
+			}
+			if pending.DeliveryTime, err = r.readUint64(); err != nil {
+				return nil, err
+			}
+			if pending.DeliveryCount, err = r.ReadLength64(); err != nil {
+				return nil, err
+			}
+			g.Pending = append(g.Pending, pending)
+		}
+
+		nConsumer, err := r.ReadLength64()
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < nConsumer; j++ {
+			consumer := &StreamConsumer{}
+			if consumer.Name, err = r.ReadString(); err != nil {
+				return nil, err
+			}
+			if consumer.SeenTime, err = r.readUint64(); err != nil {
+				return nil, err
+			}
+			if t == RdbTypeStreamListPacks3 {
+				if consumer.ActiveTime, err = r.readUint64(); err != nil {
+					return nil, err
+				}
+			}
+			nPending, err := r.ReadLength64()
+			if err != nil {
+				return nil, err
+			}
+			for k := uint64(0); k < nPending; k++ {
+				id, err := r.readRawStreamId()
+				if err != nil {
+					return nil, err
+				}
+				consumer.Pending = append(consumer.Pending, id)
+			}
+			g.Consumers = append(g.Consumers, consumer)
+		}
+		s.Groups = append(s.Groups, g)
+	}
+	return s, nil
+}
+
+/*
+* The master entry is composed like in the following example:
+*
+*  +-------+---------+------------+---------+--/--+---------+---------+-+
+*	| count | deleted | num-fields | field_1 | field_2 | ... | field_N |0|
+*	+-------+---------+------------+---------+--/--+---------+---------+-+
+
+* Populate the Listpack with the new entry. We use the following
+* encoding:
+*
+* +-----+--------+----------+-------+-------+-/-+-------+-------+--------+
+* |flags|entry-id|num-fields|field-1|value-1|...|field-N|value-N|lp-count|
+* +-----+--------+----------+-------+-------+-/-+-------+-------+--------+
+*
+* However if the SAMEFIELD flag is set, we have just to populate
+* the entry with the values, so it becomes:
+*
+* +-----+--------+-------+-/-+-------+--------+
+* |flags|entry-id|value-1|...|value-N|lp-count|
+* +-----+--------+-------+-/-+-------+--------+
+*
+* The entry-id field is actually two separated fields: the ms
+* and seq difference compared to the master entry.
+*
+* The lp-count field is a number that states the number of Listpack pieces
+* that compose the entry, so that it's possible to travel the entry
+* in reverse order: we can just start from the end of the Listpack, read
+* the entry, and jump back N times to seek the "flags" field to read
+* the stream full entry.
+ */
+func readStreamListpack(master StreamId, lp *listpack.Listpack) []*StreamEntry {
+	count := lp.NextInteger()
+	deleted := lp.NextInteger()
+	numFields := lp.NextInteger()
+	fields := make([]string, 0, numFields)
+	for i := int64(0); i < numFields; i++ {
+		fields = append(fields, lp.Next())
+	}
+	lp.Next() // the end of master entry
+
+	ret := make([]*StreamEntry, 0, count)
+	for count != 0 || deleted != 0 {
+		flags := lp.NextInteger()
+		e := &StreamEntry{Id: StreamId{master.Ms + uint64(lp.NextInteger()), master.Seq + uint64(lp.NextInteger())}}
+		if flags&2 == 2 {
+			// same fields as the master entry
+			for _, field := range fields {
+				e.Fields = append(e.Fields, []byte(field), []byte(lp.Next()))
+			}
+		} else {
+			num := lp.NextInteger()
+			for i := int64(0); i < num; i++ {
+				e.Fields = append(e.Fields, []byte(lp.Next()), []byte(lp.Next()))
+			}
+		}
+		lp.Next() // lp-count
+
+		if flags&1 == 1 {
+			deleted--
+		} else {
+			count--
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func (r *rdbReader) readStreamId() (StreamId, error) {
+	ms, err := r.ReadLength64()
+	if err != nil {
+		return StreamId{}, err
+	}
+	seq, err := r.ReadLength64()
+	return StreamId{ms, seq}, err
+}
+
+// the id saved in 16 bytes big endian
+func (r *rdbReader) readRawStreamId() (StreamId, error) {
+	b, err := r.ReadBytes(16)
+	if err != nil {
+		return StreamId{}, err
+	}
+	return StreamId{binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])}, nil
+}
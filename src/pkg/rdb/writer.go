@@ -0,0 +1,150 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+
+	"github.com/alibaba/RedisShake/pkg/libs/errors"
+	"github.com/alibaba/RedisShake/pkg/rdb/digest"
+	"github.com/cupcake/rdb"
+)
+
+/*
+ * Writer writes the rdb file with the dump payloads, e.g., the values of "DUMP" and "FUNCTION DUMP" from the
+ * source redis which refuses "SYNC". The payload is written as it is without the version and checksum, so the
+ * rdb version in the header is the version of the first payload, and the following ones shouldn't be greater.
+ *   header: "REDIS0011", aux fields
+ *   functions: RdbTypeFunction2, code...
+ *   entries: [select db], [expire time ms], type, key, value...
+ *   footer: eof, crc64
+ */
+type Writer struct {
+	w       io.Writer // writes into both the file and the checksum
+	crc     hash.Hash64
+	enc     *rdb.Encoder // the checksum of the encoder isn't used
+	aux     map[string]string
+	version uint16 // rdb version in the header, 0 if the header isn't written
+	db      int64
+}
+
+// NewWriter returns the writer of the rdb with the aux fields, e.g., "redis-ver".
+func NewWriter(w io.Writer, aux map[string]string) *Writer {
+	crc := digest.New()
+	mw := io.MultiWriter(w, crc)
+	return &Writer{
+		w:   mw,
+		crc: crc,
+		enc: rdb.NewEncoder(mw),
+		aux: aux,
+		db:  -1,
+	}
+}
+
+// WriteFunctions writes the payload of "FUNCTION DUMP", it should be called before writing the entries.
+func (w *Writer) WriteFunctions(p []byte) error {
+	if err := w.writeHeader(p); err != nil {
+		return err
+	}
+	if w.db != -1 {
+		return errors.Errorf("functions should be written before the entries")
+	}
+	_, err := w.w.Write(p[:len(p)-10])
+	return errors.Trace(err)
+}
+
+// WriteEntry writes the key with the dump payload, expireat is the absolute time in milliseconds, 0 if not set.
+func (w *Writer) WriteEntry(db uint32, key []byte, expireat uint64, p []byte) error {
+	if err := w.writeHeader(p); err != nil {
+		return err
+	}
+	if w.db == -1 || uint32(w.db) != db {
+		w.db = int64(db)
+		if err := w.enc.EncodeDatabase(int(db)); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	if expireat != 0 {
+		if err := w.enc.EncodeExpiry(expireat); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	// type
+	if _, err := w.w.Write(p[:1]); err != nil {
+		return errors.Trace(err)
+	}
+	if err := w.enc.EncodeString(key); err != nil {
+		return errors.Trace(err)
+	}
+	// value
+	_, err := w.w.Write(p[1 : len(p)-10])
+	return errors.Trace(err)
+}
+
+// Close writes the eof and checksum, the header is written with ToVersion if there is no payload.
+func (w *Writer) Close() error {
+	if w.version == 0 {
+		if err := w.encodeHeader(uint16(ToVersion)); err != nil {
+			return err
+		}
+	}
+	if _, err := w.w.Write([]byte{rdbFlagEOF}); err != nil {
+		return errors.Trace(err)
+	}
+	// the checksum isn't included in itself
+	return errors.Trace(binary.Write(w.w, binary.LittleEndian, w.crc.Sum64()))
+}
+
+// write the header with the version of the first payload.
+func (w *Writer) writeHeader(p []byte) error {
+	version, err := dumpVersion(p)
+	if err != nil {
+		return err
+	}
+	if w.version == 0 {
+		return w.encodeHeader(version)
+	} else if version > w.version {
+		return errors.Errorf("dump version[%v] is greater than the rdb version[%v]", version, w.version)
+	}
+	return nil
+}
+
+func (w *Writer) encodeHeader(version uint16) error {
+	w.version = version
+	if _, err := fmt.Fprintf(w.w, "REDIS%04d", version); err != nil {
+		return errors.Trace(err)
+	}
+
+	keys := make([]string, 0, len(w.aux))
+	for key := range w.aux {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if _, err := w.w.Write([]byte{RdbFlagAUX}); err != nil {
+			return errors.Trace(err)
+		}
+		if err := w.enc.EncodeString([]byte(key)); err != nil {
+			return errors.Trace(err)
+		}
+		if err := w.enc.EncodeString([]byte(w.aux[key])); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
+
+// the rdb version of the dump payload: type, value, version in 2 bytes, crc64 in 8 bytes.
+func dumpVersion(p []byte) (uint16, error) {
+	if len(p) < 10 {
+		return 0, errors.Errorf("invalid dump length")
+	}
+	c := digest.New()
+	c.Write(p[:len(p)-8])
+	if binary.LittleEndian.Uint64(p[len(p)-8:]) != c.Sum64() {
+		return 0, errors.Errorf("invalid CRC checksum")
+	}
+	return binary.LittleEndian.Uint16(p[len(p)-10:]), nil
+}
@@ -0,0 +1,74 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"testing"
+
+	"github.com/alibaba/RedisShake/pkg/libs/assert"
+)
+
+func TestWriter(t *testing.T) {
+	// write the rdb with the dump payloads, and then load it
+	var b bytes.Buffer
+	w := NewWriter(&b, map[string]string{"redis-ver": "7.0.0", "redis-bits": "64"})
+
+	// the payload of "FUNCTION DUMP": RdbTypeFunction2, code
+	code := "#!lua name=lib\nredis.register_function('f', function() return 1 end)"
+	var function bytes.Buffer
+	function.WriteByte(RdbTypeFunction2)
+	// 14 bit length
+	function.Write([]byte{0x40 | byte(len(code)>>8), byte(len(code))})
+	function.WriteString(code)
+	assert.MustNoError(w.WriteFunctions(createValueDump(RdbTypeFunction2, function.Bytes()[1:])))
+
+	keys := make(map[string]uint32)
+	for i := 0; i < 64; i++ {
+		key := "key" + strconv.Itoa(i)
+		db := uint32(i / 16)
+		keys[key] = db
+		p, err := EncodeDump(toString(strconv.Itoa(i)))
+		assert.MustNoError(err)
+		assert.MustNoError(w.WriteEntry(db, []byte(key), uint64(i), p))
+	}
+	// the payload of the greater version isn't accepted
+	p, err := EncodeDump(toString("v"))
+	assert.MustNoError(err)
+	binary.LittleEndian.PutUint16(p[len(p)-10:], uint16(ToVersion+1))
+	assert.Must(w.WriteEntry(0, []byte("v"), 0, p) != nil)
+	// functions should be written before the entries
+	assert.Must(w.WriteFunctions(createValueDump(RdbTypeFunction2, function.Bytes()[1:])) != nil)
+	assert.MustNoError(w.Close())
+
+	l := NewLoader(bytes.NewReader(b.Bytes()))
+	assert.MustNoError(l.Header())
+	e, err := l.NextBinEntry()
+	assert.MustNoError(err)
+	assert.Must(e.Type == RdbTypeFunction2)
+	for i := 0; i < 64; i++ {
+		e, err := l.NextBinEntry()
+		assert.MustNoError(err)
+		key := string(e.Key)
+		assert.Must(key == "key"+strconv.Itoa(i))
+		assert.Must(e.DB == keys[key])
+		assert.Must(e.ExpireAt == uint64(i))
+		o, err := DecodeDump(e.Value)
+		assert.MustNoError(err)
+		checkString(t, o, strconv.Itoa(i))
+	}
+	e, err = l.NextBinEntry()
+	assert.MustNoError(err)
+	assert.Must(e == nil)
+	assert.MustNoError(l.Footer())
+
+	// empty rdb
+	b.Reset()
+	assert.MustNoError(NewWriter(&b, nil).Close())
+	l = NewLoader(bytes.NewReader(b.Bytes()))
+	assert.MustNoError(l.Header())
+	e, err = l.NextBinEntry()
+	assert.MustNoError(err)
+	assert.Must(e == nil)
+	assert.MustNoError(l.Footer())
+}
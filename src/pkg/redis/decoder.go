@@ -34,12 +34,36 @@ func Decode(r *bufio.Reader) (Resp, error) {
 }
 
 // return the response and current reading offset
-func MustDecodeOpt(d *Decoder) (Resp, int64) {
+func DecodeOpt(d *Decoder) (Resp, int64, error) {
 	resp, err := d.decodeResp(0)
+	return resp, d.offset, err
+}
+
+/*
+ * SkipLines skips the lines starting with the prefix before the next resp, e.g., the annotations "#TS:..." in
+ * the aof of redis 7.0, and returns the current reading offset.
+ */
+func SkipLines(d *Decoder, prefix byte) (int64, error) {
+	for {
+		b, err := d.r.Peek(1)
+		if err != nil || b[0] != prefix {
+			// the error is returned by the following decoding
+			return d.offset, nil
+		}
+		line, err := d.r.ReadBytes('\n')
+		d.offset += int64(len(line))
+		if err != nil {
+			return d.offset, errors.Trace(err)
+		}
+	}
+}
+
+func MustDecodeOpt(d *Decoder) (Resp, int64) {
+	resp, offset, err := DecodeOpt(d)
 	if err != nil {
 		log.PanicError(err, "decode redis resp failed")
 	}
-	return resp, d.offset
+	return resp, offset
 }
 
 func MustDecode(r *bufio.Reader) Resp {
@@ -90,6 +114,43 @@ func (d *Decoder) decodeResp(depth int) (Resp, error) {
 		resp := &Array{}
 		resp.Value, err = d.decodeArray(depth)
 		return resp, err
+	case typeNull:
+		_, err = d.decodeText()
+		return &Null{}, err
+	case typeBoolean:
+		return d.decodeBoolean()
+	case typeDouble:
+		resp := &Double{}
+		resp.Value, err = d.decodeText()
+		return resp, err
+	case typeBigNumber:
+		resp := &BigNumber{}
+		resp.Value, err = d.decodeText()
+		return resp, err
+	case typeBlobError:
+		resp := &Error{}
+		resp.Value, err = d.decodeBulkBytes()
+		return resp, err
+	case typeVerbatim:
+		return d.decodeVerbatim()
+	case typeMap:
+		resp := &Map{}
+		resp.Value, err = d.decodeAggregate(depth, 2)
+		return resp, err
+	case typeSet:
+		resp := &Set{}
+		resp.Value, err = d.decodeAggregate(depth, 1)
+		return resp, err
+	case typePush:
+		resp := &Push{}
+		resp.Value, err = d.decodeAggregate(depth, 1)
+		return resp, err
+	case typeAttribute:
+		// the attribute is auxiliary, it's dropped and the following reply is returned
+		if _, err = d.decodeAggregate(depth, 2); err != nil {
+			return nil, err
+		}
+		return d.decodeResp(depth)
 	default:
 		if depth != 0 {
 			return nil, errors.Errorf("bad resp type %s", t)
@@ -216,3 +277,49 @@ func (d *Decoder) decodeSingleLineBulkBytesArray() (Resp, error) {
 		return resp, nil
 	}
 }
+
+func (d *Decoder) decodeBoolean() (Resp, error) {
+	b, err := d.decodeText()
+	if err != nil {
+		return nil, err
+	}
+	switch string(b) {
+	case "t":
+		return &Boolean{true}, nil
+	case "f":
+		return &Boolean{false}, nil
+	default:
+		return nil, errors.Errorf("bad resp boolean %q", b)
+	}
+}
+
+// the verbatim string is "<format>:<text>", only the text is kept.
+func (d *Decoder) decodeVerbatim() (Resp, error) {
+	b, err := d.decodeBulkBytes()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 4 || b[3] != ':' {
+		return nil, errors.Errorf("bad resp verbatim string %q", b)
+	}
+	return &BulkBytes{b[4:]}, nil
+}
+
+// decode the map, set and push of RESP3, there are n elements in each entry, e.g., 2 of the map.
+func (d *Decoder) decodeAggregate(depth int, n int64) ([]Resp, error) {
+	size, err := d.decodeInt()
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 {
+		return nil, errors.Trace(ErrBadRespArrayLen)
+	}
+
+	a := make([]Resp, size*n)
+	for i := 0; i < len(a); i++ {
+		if a[i], err = d.decodeResp(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
@@ -4,6 +4,7 @@
 package redis
 
 import (
+	"bufio"
 	"bytes"
 	"testing"
 
@@ -110,3 +111,83 @@ func TestDecoder(t *testing.T) {
 		assert.MustNoError(err)
 	}
 }
+
+func TestSkipLines(t *testing.T) {
+	s := "#TS:1628217470\r\n#TS:1628217471\r\n*1\r\n$4\r\nping\r\n#TS:1628217472\r\n"
+	d := NewDecoder(bufio.NewReader(bytes.NewReader([]byte(s))))
+	offset, err := SkipLines(d, '#')
+	assert.MustNoError(err)
+	assert.Must(offset == 32)
+	_, offset, err = DecodeOpt(d)
+	assert.MustNoError(err)
+	assert.Must(offset == 46)
+	// nothing to skip
+	offset, err = SkipLines(d, '*')
+	assert.MustNoError(err)
+	assert.Must(offset == 46)
+	offset, err = SkipLines(d, '#')
+	assert.MustNoError(err)
+	assert.Must(offset == int64(len(s)))
+	// the end of the file
+	offset, err = SkipLines(d, '#')
+	assert.MustNoError(err)
+	assert.Must(offset == int64(len(s)))
+
+	// the truncated line
+	d = NewDecoder(bufio.NewReader(bytes.NewReader([]byte("#TS:16"))))
+	offset, err = SkipLines(d, '#')
+	assert.Must(err != nil)
+	assert.Must(offset == 6)
+}
+
+func TestDecodeResp3(t *testing.T) {
+	resp, err := DecodeFromBytes([]byte("_\r\n"))
+	assert.MustNoError(err)
+	_, ok := resp.(*Null)
+	assert.Must(ok)
+
+	resp, err = DecodeFromBytes([]byte("#f\r\n"))
+	assert.MustNoError(err)
+	assert.Must(resp.(*Boolean).Value == false)
+
+	resp, err = DecodeFromBytes([]byte(",1.23\r\n"))
+	assert.MustNoError(err)
+	assert.Must(bytes.Equal(resp.(*Double).Value, []byte("1.23")))
+
+	resp, err = DecodeFromBytes([]byte("!21\r\nSYNTAX invalid syntax\r\n"))
+	assert.MustNoError(err)
+	assert.Must(bytes.Equal(resp.(*Error).Value, []byte("SYNTAX invalid syntax")))
+
+	resp, err = DecodeFromBytes([]byte("=15\r\ntxt:Some string\r\n"))
+	assert.MustNoError(err)
+	assert.Must(bytes.Equal(resp.(*BulkBytes).Value, []byte("Some string")))
+
+	resp, err = DecodeFromBytes([]byte("%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n"))
+	assert.MustNoError(err)
+	assert.Must(len(resp.(*Map).Value) == 4)
+
+	resp, err = DecodeFromBytes([]byte("~2\r\n+a\r\n#t\r\n"))
+	assert.MustNoError(err)
+	assert.Must(len(resp.(*Set).Value) == 2)
+
+	resp, err = DecodeFromBytes([]byte(">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$5\r\nhello\r\n"))
+	assert.MustNoError(err)
+	assert.Must(len(resp.(*Push).Value) == 3)
+
+	// the attribute is dropped
+	resp, err = DecodeFromBytes([]byte("|1\r\n+key-popularity\r\n%1\r\n$1\r\na\r\n,0.1923\r\n*2\r\n:2039123\r\n:9543892\r\n"))
+	assert.MustNoError(err)
+	assert.Must(len(resp.(*Array).Value) == 2)
+
+	// the offset includes all the bytes
+	p := []byte("|1\r\n+a\r\n:1\r\n*1\r\n$4\r\nping\r\n")
+	d := NewDecoder(bufio.NewReader(bytes.NewReader(p)))
+	_, offset, err := DecodeOpt(d)
+	assert.MustNoError(err)
+	assert.Must(offset == int64(len(p)))
+
+	for _, s := range []string{"#x\r\n", "=3\r\ntxt\r\n", "%-1\r\n", "%1\r\n:1\r\n", "~1\r\n"} {
+		_, err := DecodeFromBytes([]byte(s))
+		assert.Must(err != nil)
+	}
+}
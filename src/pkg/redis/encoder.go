@@ -102,6 +102,47 @@ func (e *encoder) encodeResp(r Resp) error {
 			return err
 		}
 		return e.encodeArray(x.Value)
+	case *Null:
+		if err := e.encodeType(typeNull); err != nil {
+			return err
+		}
+		return e.encodeString("")
+	case *Boolean:
+		if err := e.encodeType(typeBoolean); err != nil {
+			return err
+		}
+		if x.Value {
+			return e.encodeString("t")
+		}
+		return e.encodeString("f")
+	case *Double:
+		if err := e.encodeType(typeDouble); err != nil {
+			return err
+		}
+		return e.encodeText(x.Value)
+	case *BigNumber:
+		if err := e.encodeType(typeBigNumber); err != nil {
+			return err
+		}
+		return e.encodeText(x.Value)
+	case *Map:
+		if err := e.encodeType(typeMap); err != nil {
+			return err
+		}
+		if len(x.Value)%2 != 0 {
+			return errors.Errorf("bad resp map length %d", len(x.Value))
+		}
+		return e.encodeAggregate(x.Value, len(x.Value)/2)
+	case *Set:
+		if err := e.encodeType(typeSet); err != nil {
+			return err
+		}
+		return e.encodeAggregate(x.Value, len(x.Value))
+	case *Push:
+		if err := e.encodeType(typePush); err != nil {
+			return err
+		}
+		return e.encodeAggregate(x.Value, len(x.Value))
 	}
 }
 
@@ -165,3 +206,16 @@ func (e *encoder) encodeArray(a []Resp) error {
 		return nil
 	}
 }
+
+// encode the map, set and push of RESP3 with the given number of entries.
+func (e *encoder) encodeAggregate(a []Resp, n int) error {
+	if err := e.encodeInt(int64(n)); err != nil {
+		return err
+	}
+	for i := 0; i < len(a); i++ {
+		if err := e.encodeResp(a[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
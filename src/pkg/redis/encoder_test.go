@@ -66,3 +66,17 @@ func testEncodeAndCheck(t *testing.T, resp Resp, expect []byte) {
 	assert.MustNoError(err)
 	assert.Must(bytes.Equal(b, expect))
 }
+
+func TestEncodeResp3(t *testing.T) {
+	testEncodeAndCheck(t, &Null{}, []byte("_\r\n"))
+	testEncodeAndCheck(t, &Boolean{true}, []byte("#t\r\n"))
+	testEncodeAndCheck(t, &Double{[]byte("-inf")}, []byte(",-inf\r\n"))
+	testEncodeAndCheck(t, &BigNumber{[]byte("3492890328409238509324850943850943825024385")},
+		[]byte("(3492890328409238509324850943850943825024385\r\n"))
+	testEncodeAndCheck(t, &Map{[]Resp{&BulkBytes{[]byte("a")}, &Int{1}}}, []byte("%1\r\n$1\r\na\r\n:1\r\n"))
+	testEncodeAndCheck(t, &Set{[]Resp{&Int{1}, &Int{2}}}, []byte("~2\r\n:1\r\n:2\r\n"))
+	testEncodeAndCheck(t, &Push{[]Resp{&BulkBytes{[]byte("message")}}}, []byte(">1\r\n$7\r\nmessage\r\n"))
+
+	_, err := EncodeToBytes(&Map{[]Resp{&Int{1}}})
+	assert.Must(err != nil)
+}
@@ -18,6 +18,18 @@ const (
 	typeInt       respType = ':'
 	typeBulkBytes respType = '$'
 	typeArray     respType = '*'
+
+	// RESP3
+	typeNull      respType = '_'
+	typeBoolean   respType = '#'
+	typeDouble    respType = ','
+	typeBigNumber respType = '('
+	typeBlobError respType = '!'
+	typeVerbatim  respType = '='
+	typeMap       respType = '%'
+	typeSet       respType = '~'
+	typeAttribute respType = '|'
+	typePush      respType = '>'
 )
 
 func (t respType) String() string {
@@ -32,6 +44,26 @@ func (t respType) String() string {
 		return "<bulkbytes>"
 	case typeArray:
 		return "<array>"
+	case typeNull:
+		return "<null>"
+	case typeBoolean:
+		return "<boolean>"
+	case typeDouble:
+		return "<double>"
+	case typeBigNumber:
+		return "<bignumber>"
+	case typeBlobError:
+		return "<bloberror>"
+	case typeVerbatim:
+		return "<verbatim>"
+	case typeMap:
+		return "<map>"
+	case typeSet:
+		return "<set>"
+	case typeAttribute:
+		return "<attribute>"
+	case typePush:
+		return "<push>"
 	default:
 		if c := uint8(t); c > 0x20 && c < 0x7F {
 			return fmt.Sprintf("<unknown-%c>", c)
@@ -90,6 +122,37 @@ func (r *Array) Append(a Resp) {
 	r.Value = append(r.Value, a)
 }
 
+// Null is the null of RESP3.
+type Null struct {
+}
+
+type Boolean struct {
+	Value bool
+}
+
+// Double keeps the text of the double of RESP3, e.g., "1.23", "inf", "nan".
+type Double struct {
+	Value []byte
+}
+
+type BigNumber struct {
+	Value []byte
+}
+
+// Map keeps the key and value in turn.
+type Map struct {
+	Value []Resp
+}
+
+type Set struct {
+	Value []Resp
+}
+
+// Push is the out of band data of RESP3, e.g., the message of the subscribed channel.
+type Push struct {
+	Value []Resp
+}
+
 //func (r *Array) AppendString(s string) {
 //	r.Append(NewString(s))
 //}
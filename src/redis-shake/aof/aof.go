@@ -0,0 +1,215 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alibaba/RedisShake/pkg/libs/errors"
+)
+
+/*
+ * The aof given in `restore` can be the following:
+ * 1. a single aof file of redis before 7.0, it may start with the rdb preamble.
+ * 2. the multi part aof of redis 7.0, i.e., the appendonlydir or its manifest. The manifest lists the files
+ *    line by line as "file appendonly.aof.1.base.rdb seq 1 type b":
+ *    type b: the base file, it's an rdb or an aof, at most one.
+ *    type h: the history file which is going to be deleted, skipped.
+ *    type i: the incr aof file, applied after the base in the order of the manifest.
+ */
+
+const (
+	FileTypeBase    = 'b'
+	FileTypeHistory = 'h'
+	FileTypeIncr    = 'i'
+
+	manifestSuffix = ".manifest"
+	rdbMagic       = "REDIS"
+)
+
+// File is one aof file listed in the manifest.
+type File struct {
+	Name string
+	Seq  int64
+	Type byte
+}
+
+// ParseManifest parses the manifest of the multi part aof.
+func ParseManifest(r io.Reader) ([]*File, error) {
+	var files []*File
+	var hasBase bool
+	var lastIncrSeq int64
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		args, err := splitArgs(text)
+		if err != nil {
+			return nil, fmt.Errorf("parse line[%v] of manifest failed[%v]", line, err)
+		}
+		if len(args)%2 != 0 {
+			return nil, fmt.Errorf("invalid line[%v] of manifest: %v", line, text)
+		}
+
+		f := &File{Seq: -1}
+		for i := 0; i < len(args); i += 2 {
+			switch args[i] {
+			case "file":
+				f.Name = args[i+1]
+			case "seq":
+				if f.Seq, err = strconv.ParseInt(args[i+1], 10, 64); err != nil || f.Seq < 0 {
+					return nil, fmt.Errorf("invalid seq[%v] in line[%v] of manifest", args[i+1], line)
+				}
+			case "type":
+				if len(args[i+1]) != 1 {
+					return nil, fmt.Errorf("invalid type[%v] in line[%v] of manifest", args[i+1], line)
+				}
+				f.Type = args[i+1][0]
+			default:
+				// the unknown fields are ignored for the forward compatibility, just like redis
+			}
+		}
+		if f.Name == "" || f.Seq == -1 || f.Type == 0 {
+			return nil, fmt.Errorf("file, seq or type is missing in line[%v] of manifest", line)
+		}
+		if strings.ContainsRune(f.Name, filepath.Separator) {
+			return nil, fmt.Errorf("invalid file name[%v] in line[%v] of manifest", f.Name, line)
+		}
+
+		switch f.Type {
+		case FileTypeBase:
+			if hasBase {
+				return nil, fmt.Errorf("found duplicate base file[%v] in line[%v] of manifest", f.Name, line)
+			}
+			hasBase = true
+		case FileTypeIncr:
+			if f.Seq <= lastIncrSeq {
+				return nil, fmt.Errorf("incr file[%v] seq[%v] in line[%v] of manifest isn't increasing", f.Name,
+					f.Seq, line)
+			}
+			lastIncrSeq = f.Seq
+		case FileTypeHistory:
+		default:
+			return nil, fmt.Errorf("unknown type[%c] in line[%v] of manifest", f.Type, line)
+		}
+		files = append(files, f)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("manifest is empty")
+	}
+	return files, nil
+}
+
+/*
+ * Files returns the path of the files to load in order, the base file is the first one if exists. The input
+ * is the aof file, the manifest, or the directory which contains the manifest.
+ */
+func Files(input string) ([]string, error) {
+	info, err := os.Stat(input)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := input
+	if info.IsDir() {
+		matches, err := filepath.Glob(filepath.Join(input, "*"+manifestSuffix))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) != 1 {
+			return nil, fmt.Errorf("expect one manifest in the aof directory[%v], but found %v", input, matches)
+		}
+		manifest = matches[0]
+	} else if !strings.HasSuffix(input, manifestSuffix) {
+		// the single aof file
+		return []string{input}, nil
+	}
+
+	file, err := os.Open(manifest)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	files, err := ParseManifest(file)
+	if err != nil {
+		return nil, fmt.Errorf("parse manifest[%v] failed[%v]", manifest, err)
+	}
+
+	dir := filepath.Dir(manifest)
+	var base string
+	var paths []string
+	for _, f := range files {
+		switch f.Type {
+		case FileTypeBase:
+			base = filepath.Join(dir, f.Name)
+		case FileTypeIncr:
+			paths = append(paths, filepath.Join(dir, f.Name))
+		}
+	}
+	if base != "" {
+		paths = append([]string{base}, paths...)
+	}
+	return paths, nil
+}
+
+// IsRdbPreamble returns whether the aof starts with the rdb, i.e., aof-use-rdb-preamble or the rdb base file.
+func IsRdbPreamble(r *bufio.Reader) (bool, error) {
+	p, err := r.Peek(len(rdbMagic))
+	if err == io.EOF {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Trace(err)
+	}
+	return bytes.Equal(p, []byte(rdbMagic)), nil
+}
+
+// split the line into arguments like sdssplitargs, the file name is quoted if it has special characters.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	for i := 0; i < len(line); {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+
+		if line[i] != '"' {
+			j := strings.IndexAny(line[i:], " \t")
+			if j == -1 {
+				j = len(line) - i
+			}
+			args = append(args, line[i:i+j])
+			i += j
+			continue
+		}
+
+		// quoted argument, e.g., "append\x20only.aof"
+		j := i + 1
+		for ; j < len(line) && line[j] != '"'; j++ {
+			if line[j] == '\\' {
+				j++
+			}
+		}
+		if j >= len(line) {
+			return nil, fmt.Errorf("unbalanced quotes")
+		}
+		arg, err := strconv.Unquote(line[i : j+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted argument[%v]", line[i:j+1])
+		}
+		args = append(args, arg)
+		i = j + 1
+	}
+	return args, nil
+}
@@ -0,0 +1,125 @@
+package aof
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseManifest(t *testing.T) {
+	// test ParseManifest
+
+	var nr int
+	{
+		fmt.Printf("TestParseManifest case %d.\n", nr)
+		nr++
+
+		files, err := ParseManifest(strings.NewReader("file appendonly.aof.1.base.rdb seq 1 type b\n" +
+			"file appendonly.aof.1.incr.aof seq 1 type h\n" +
+			"file appendonly.aof.2.incr.aof seq 2 type i\n" +
+			"file \"append only.aof.3.incr.aof\" seq 3 type i startoffset 100\n"))
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, []*File{
+			{Name: "appendonly.aof.1.base.rdb", Seq: 1, Type: FileTypeBase},
+			{Name: "appendonly.aof.1.incr.aof", Seq: 1, Type: FileTypeHistory},
+			{Name: "appendonly.aof.2.incr.aof", Seq: 2, Type: FileTypeIncr},
+			{Name: "append only.aof.3.incr.aof", Seq: 3, Type: FileTypeIncr},
+		}, files, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestParseManifest case %d.\n", nr)
+		nr++
+
+		for _, manifest := range []string{
+			"",
+			"file appendonly.aof.1.base.rdb seq 1\n",
+			"file appendonly.aof.1.base.rdb seq 1 type x\n",
+			"file appendonly.aof.1.base.rdb seq 1 type b\nfile appendonly.aof.2.base.rdb seq 2 type b\n",
+			"file appendonly.aof.2.incr.aof seq 2 type i\nfile appendonly.aof.1.incr.aof seq 1 type i\n",
+			"file \"appendonly.aof seq 1 type i\n",
+			"file ../appendonly.aof seq 1 type i\n",
+		} {
+			_, err := ParseManifest(strings.NewReader(manifest))
+			assert.NotEqual(t, nil, err, "should be not equal")
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	// test Files and IsRdbPreamble
+
+	var nr int
+
+	dir, err := ioutil.TempDir("", "aof")
+	assert.Equal(t, nil, err, "should be equal")
+	defer os.RemoveAll(dir)
+
+	{
+		fmt.Printf("TestFiles case %d.\n", nr)
+		nr++
+
+		// single aof file
+		name := filepath.Join(dir, "appendonly.aof")
+		assert.Equal(t, nil, ioutil.WriteFile(name, []byte("REDIS0009"), 0644), "should be equal")
+		files, err := Files(name)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, []string{name}, files, "should be equal")
+
+		file, err := os.Open(name)
+		assert.Equal(t, nil, err, "should be equal")
+		defer file.Close()
+		ok, err := IsRdbPreamble(bufio.NewReader(file))
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, true, ok, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestFiles case %d.\n", nr)
+		nr++
+
+		// the multi part aof directory, the base file is the first one
+		aofDir := filepath.Join(dir, "appendonlydir")
+		assert.Equal(t, nil, os.Mkdir(aofDir, 0755), "should be equal")
+		manifest := "file appendonly.aof.2.incr.aof seq 2 type h\n" +
+			"file appendonly.aof.3.incr.aof seq 3 type i\n" +
+			"file appendonly.aof.2.base.aof seq 2 type b\n"
+		assert.Equal(t, nil, ioutil.WriteFile(filepath.Join(aofDir, "appendonly.aof.manifest"),
+			[]byte(manifest), 0644), "should be equal")
+
+		expect := []string{
+			filepath.Join(aofDir, "appendonly.aof.2.base.aof"),
+			filepath.Join(aofDir, "appendonly.aof.3.incr.aof"),
+		}
+		files, err := Files(aofDir)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, expect, files, "should be equal")
+
+		files, err = Files(filepath.Join(aofDir, "appendonly.aof.manifest"))
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, expect, files, "should be equal")
+
+		ok, err := IsRdbPreamble(bufio.NewReader(strings.NewReader("*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n")))
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, false, ok, "should be equal")
+
+		ok, err = IsRdbPreamble(bufio.NewReader(strings.NewReader("")))
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, false, ok, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestFiles case %d.\n", nr)
+		nr++
+
+		// no manifest in the directory
+		_, err := Files(dir)
+		assert.NotEqual(t, nil, err, "should be not equal")
+	}
+}
@@ -0,0 +1,26 @@
+package base
+
+import (
+	"context"
+)
+
+var (
+	// canceled once the process receives SIGINT or SIGTERM
+	shutdownCtx, shutdownCancel = context.WithCancel(context.Background())
+)
+
+/*
+ * ShutdownContext returns the context canceled on shutdown. The runners stop reading from the source once
+ * it's done, and then flush all the received data into the target before exiting.
+ */
+func ShutdownContext() context.Context {
+	return shutdownCtx
+}
+
+func Shutdown() {
+	shutdownCancel()
+}
+
+func ShuttingDown() bool {
+	return shutdownCtx.Err() != nil
+}
@@ -0,0 +1,56 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/alibaba/RedisShake/pkg/libs/log"
+	"github.com/alibaba/RedisShake/redis-shake/checkpoint"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+)
+
+const (
+	CheckpointActionList  = "list"
+	CheckpointActionClear = "clear"
+)
+
+// list or clear the checkpoints in the checkpoint.storage, the result is printed into stdout in json.
+type CmdCheckpoint struct {
+	Action string // list or clear
+	Name   string // checkpoint name, empty means all
+	Source string // source address or rdb file, empty means all
+}
+
+func (cmd *CmdCheckpoint) GetDetailedInfo() interface{} {
+	return nil
+}
+
+func (cmd *CmdCheckpoint) Main() {
+	store := checkpoint.NewStore(conf.Options.TargetAddressList, conf.Options.TargetAuthType,
+		conf.Options.TargetPasswordRaw, conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify)
+
+	var records []*checkpoint.Record
+	var err error
+	switch cmd.Action {
+	case CheckpointActionList:
+		records, err = store.List(cmd.Name, cmd.Source)
+	case CheckpointActionClear:
+		records, err = store.Clear(cmd.Name, cmd.Source)
+	default:
+		log.Panicf("unknown checkpoint action[%v]", cmd.Action)
+	}
+	if err != nil {
+		log.Panicf("%v checkpoint in storage[%v] failed[%v]", cmd.Action, conf.Options.CheckpointStorage, err)
+	}
+
+	if records == nil {
+		records = []*checkpoint.Record{}
+	}
+	v, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		log.Panicf("marshal checkpoint failed[%v]", err)
+	}
+	fmt.Println(string(v))
+
+	log.Infof("%v %d checkpoints in storage[%v]", cmd.Action, len(records), conf.Options.CheckpointStorage)
+}
@@ -46,10 +46,8 @@ func LoadCheckpoint(dbSyncerId int, sourceAddr string, target []string, authType
 		}
 	}
 
-	if recVersion != -1 && recVersion < utils.FcvCheckpoint.FeatureCompatibleVersion {
-		return "", 0, 0, fmt.Errorf("current required checkpoint version[%v] > input[%v], please upgrade RedisShake to version >= %v",
-			utils.FcvCheckpoint.FeatureCompatibleVersion, recVersion,
-			utils.LowestCheckpointVersion[utils.FcvCheckpoint.FeatureCompatibleVersion])
+	if err := checkVersion(recVersion); err != nil {
+		return "", 0, 0, err
 	}
 
 	// do not set recDb when runId == "?" which means all checkpoint should be clean
@@ -64,6 +62,39 @@ func LoadCheckpoint(dbSyncerId int, sourceAddr string, target []string, authType
 	return recRunId, newestOffset, int(recDb), nil
 }
 
+/*
+ * load the checkpoints of the writers when the commands are partitioned by slot, each writer has its own
+ * checkpoint name.
+ * @return:
+ *     string: runid, "?" if any writer has no checkpoint, e.g., the slot distribution of the target changed
+ *     []int64: offset of each writer, all of them are -1 if the runid is "?"
+ *     error
+ */
+func LoadSlotCheckpoint(dbSyncerId int, store CheckpointStore, sourceAddr string, checkpointNames []string) (string,
+	[]int64, error) {
+	offsets := make([]int64, len(checkpointNames))
+	var recRunId string
+	for i, name := range checkpointNames {
+		cp, err := store.Load(dbSyncerId, sourceAddr, name)
+		if err != nil {
+			return "", nil, err
+		}
+		log.Infof("DbSyncer[%d] load checkpoint[%v]: runId[%v], offset[%v]", dbSyncerId, name, cp.RunId, cp.Offset)
+
+		if cp.Offset == -1 || cp.RunId == "?" || recRunId != "" && cp.RunId != recRunId {
+			log.Warnf("DbSyncer[%d] checkpoint[%v] is missing or different from the others, ignore all the "+
+				"checkpoints", dbSyncerId, name)
+			for j := range offsets {
+				offsets[j] = -1
+			}
+			return "?", offsets, nil
+		}
+		recRunId = cp.RunId
+		offsets[i] = cp.Offset
+	}
+	return recRunId, offsets, nil
+}
+
 /*
  * fetch checkpoint from give address
  * @return:
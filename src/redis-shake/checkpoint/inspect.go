@@ -0,0 +1,356 @@
+package checkpoint
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	utils "github.com/alibaba/RedisShake/redis-shake/common"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+
+	redigo "github.com/garyburd/redigo/redis"
+)
+
+const (
+	// matches the checkpoint names of sync, rump and restore, and the slot suffixed names in cluster
+	checkpointPattern = "redis-shake-*checkpoint*"
+	scanCount         = 1000
+)
+
+// Record is one checkpoint found in the store.
+type Record struct {
+	Name     string `json:"name"`     // checkpoint name, with the slot suffix in cluster
+	Source   string `json:"source"`   // source address, or the rdb file of restore
+	Location string `json:"location"` // target node, file or meta redis which stores the checkpoint
+	Checkpoint
+}
+
+// whether the record matches the name and source, the empty one matches all.
+func (r *Record) match(name, source string) bool {
+	return (name == "" || r.Name == name) && (source == "" || r.Source == source)
+}
+
+// sort the records by name, source and location.
+func sortRecords(records []*Record) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		if records[i].Source != records[j].Source {
+			return records[i].Source < records[j].Source
+		}
+		return records[i].Location < records[j].Location
+	})
+}
+
+/*
+ * parse the fields "<source>-<field>" of the checkpoint hash.
+ * @return:
+ *     map[string]*Checkpoint: source -> checkpoint
+ *     error
+ */
+func parseFields(reply []interface{}) (map[string]*Checkpoint, error) {
+	ret := make(map[string]*Checkpoint)
+	get := func(source string) *Checkpoint {
+		if ret[source] == nil {
+			ret[source] = &Checkpoint{RunId: "?", Offset: -1}
+		}
+		return ret[source]
+	}
+
+	for i := 0; i+1 < len(reply); i += 2 {
+		field, err := redigo.String(reply[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		value, err := redigo.String(reply[i+1], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		idx := strings.LastIndex(field, "-")
+		if idx <= 0 {
+			continue
+		}
+		source, key := field[:idx], field[idx+1:]
+		switch key {
+		case utils.CheckpointRunId:
+			get(source).RunId = value
+		case utils.CheckpointOffset:
+			if get(source).Offset, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return nil, fmt.Errorf("parse field[%v] value[%v] failed[%v]", field, value, err)
+			}
+		case utils.CheckpointVersion:
+			if get(source).Version, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("parse field[%v] value[%v] failed[%v]", field, value, err)
+			}
+		case utils.CheckpointDb:
+			if get(source).Db, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("parse field[%v] value[%v] failed[%v]", field, value, err)
+			}
+		case utils.CheckpointKeysDone:
+			if get(source).KeysDone, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return nil, fmt.Errorf("parse field[%v] value[%v] failed[%v]", field, value, err)
+			}
+		case utils.CheckpointFinished:
+			get(source).Finished = value == "1"
+		case utils.CheckpointCrc:
+			if get(source).Crc, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return nil, fmt.Errorf("parse field[%v] value[%v] failed[%v]", field, value, err)
+			}
+		case utils.CheckpointWindow:
+			if get(source).Window, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return nil, fmt.Errorf("parse field[%v] value[%v] failed[%v]", field, value, err)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// fields of the checkpoint of the source in the hash
+func sourceFields(source string) []interface{} {
+	fields := []string{utils.CheckpointRunId, utils.CheckpointOffset, utils.CheckpointVersion, utils.CheckpointDb,
+		utils.CheckpointKeysDone, utils.CheckpointFinished, utils.CheckpointCrc, utils.CheckpointWindow}
+	ret := make([]interface{}, 0, len(fields))
+	for _, field := range fields {
+		ret = append(ret, fmt.Sprintf("%s-%s", source, field))
+	}
+	return ret
+}
+
+/*
+ * iterate the checkpoint hashes of the db and call fn with the checkpoint of each source.
+ * fn returns whether to delete the checkpoint.
+ */
+func walkHashes(c redigo.Conn, fn func(name, source string, cp *Checkpoint) bool) error {
+	cursor := int64(0)
+	for {
+		values, err := redigo.Values(c.Do("scan", cursor, "match", checkpointPattern, "count", scanCount))
+		if err != nil {
+			return fmt.Errorf("scan checkpoint with cursor[%v] failed[%v]", cursor, err)
+		}
+		var names []string
+		if _, err := redigo.Scan(values, &cursor, &names); err != nil {
+			return fmt.Errorf("parse scan reply failed[%v]", err)
+		}
+
+		for _, name := range names {
+			if tp, err := redigo.String(c.Do("type", name)); err != nil {
+				return fmt.Errorf("get type of [%v] failed[%v]", name, err)
+			} else if tp != "hash" {
+				continue
+			}
+
+			reply, err := redigo.Values(c.Do("hgetall", name))
+			if err != nil {
+				return fmt.Errorf("hgetall checkpoint[%v] failed[%v]", name, err)
+			}
+			cps, err := parseFields(reply)
+			if err != nil {
+				return fmt.Errorf("parse checkpoint[%v] failed[%v]", name, err)
+			}
+			for source, cp := range cps {
+				if !fn(name, source, cp) {
+					continue
+				}
+				if _, err := c.Do("hdel", append([]interface{}{name}, sourceFields(source)...)...); err != nil {
+					return fmt.Errorf("delete checkpoint[%v] of source[%v] failed[%v]", name, source, err)
+				}
+			}
+		}
+
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+/*------------------------------------------------------*/
+
+/*
+ * iterate the checkpoints of all the logical dbs of all the target nodes, only db0 is used in cluster.
+ * fn returns whether to delete the checkpoint.
+ */
+func (ts *targetStore) walk(fn func(*Record) bool) error {
+	nodes := ts.target
+	if ts.isCluster {
+		c, err := utils.TryOpenRedisConnWithTimeout(ts.target[:1], ts.authType, ts.passwd, metaTimeout, metaTimeout,
+			false, ts.tlsEnable, ts.tlsSkipVerify)
+		if err != nil {
+			return err
+		}
+		nodes, err = utils.GetAllClusterNode(c, conf.StandAloneRoleMaster, "address")
+		c.Close()
+		if err != nil {
+			return fmt.Errorf("get target cluster nodes failed[%v]", err)
+		}
+	}
+
+	for _, node := range nodes {
+		if err := ts.walkNode(node, fn); err != nil {
+			return fmt.Errorf("target[%v]: %v", node, err)
+		}
+	}
+	return nil
+}
+
+func (ts *targetStore) walkNode(node string, fn func(*Record) bool) error {
+	c, err := utils.TryOpenRedisConnWithTimeout([]string{node}, ts.authType, ts.passwd, metaTimeout, metaTimeout,
+		false, ts.tlsEnable, ts.tlsSkipVerify)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	dbs := []int32{0}
+	if !ts.isCluster {
+		ret, err := redigo.Bytes(c.Do("info", "keyspace"))
+		if err != nil {
+			return err
+		}
+		mp, err := utils.ParseKeyspace(ret)
+		if err != nil {
+			return err
+		}
+		dbs = dbs[:0]
+		for db := range mp {
+			dbs = append(dbs, db)
+		}
+	}
+
+	for _, db := range dbs {
+		if _, err := c.Do("select", db); err != nil {
+			return fmt.Errorf("select db[%v] failed[%v]", db, err)
+		}
+		// the db of the checkpoint in the target is where it's found
+		if err := walkHashes(c, func(name, source string, cp *Checkpoint) bool {
+			cp.Db = int(db)
+			return fn(&Record{Name: name, Source: source, Location: node, Checkpoint: *cp})
+		}); err != nil {
+			return fmt.Errorf("db[%v]: %v", db, err)
+		}
+	}
+	return nil
+}
+
+func (ts *targetStore) List(name, source string) ([]*Record, error) {
+	var records []*Record
+	err := ts.walk(func(r *Record) bool {
+		if r.match(name, source) {
+			records = append(records, r)
+		}
+		return false
+	})
+	sortRecords(records)
+	return records, err
+}
+
+func (ts *targetStore) Clear(name, source string) ([]*Record, error) {
+	var records []*Record
+	err := ts.walk(func(r *Record) bool {
+		if r.match(name, source) {
+			records = append(records, r)
+			return true
+		}
+		return false
+	})
+	sortRecords(records)
+	return records, err
+}
+
+/*------------------------------------------------------*/
+
+func (fs *fileStore) List(name, source string) ([]*Record, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	data, err := fs.read()
+	if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	for n, cps := range data {
+		for s, cp := range cps {
+			r := &Record{Name: n, Source: s, Location: fs.path, Checkpoint: *cp}
+			if r.match(name, source) {
+				records = append(records, r)
+			}
+		}
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+func (fs *fileStore) Clear(name, source string) ([]*Record, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	data, err := fs.read()
+	if err != nil {
+		return nil, err
+	}
+	var records []*Record
+	for n, cps := range data {
+		for s, cp := range cps {
+			r := &Record{Name: n, Source: s, Location: fs.path, Checkpoint: *cp}
+			if r.match(name, source) {
+				records = append(records, r)
+				delete(cps, s)
+			}
+		}
+		if len(cps) == 0 {
+			delete(data, n)
+		}
+	}
+	sortRecords(records)
+	if len(records) == 0 {
+		return records, nil
+	}
+	return records, fs.write(data)
+}
+
+/*------------------------------------------------------*/
+
+func (rs *redisStore) walk(fn func(*Record) bool) error {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	// connect before walking, the connection is reopened next time after error
+	if _, err := rs.do("ping"); err != nil {
+		return err
+	}
+	if err := walkHashes(rs.c, func(name, source string, cp *Checkpoint) bool {
+		return fn(&Record{Name: name, Source: source, Location: rs.address, Checkpoint: *cp})
+	}); err != nil {
+		rs.c.Close()
+		rs.c = nil
+		return fmt.Errorf("meta redis[%v]: %v", rs.address, err)
+	}
+	return nil
+}
+
+func (rs *redisStore) List(name, source string) ([]*Record, error) {
+	var records []*Record
+	err := rs.walk(func(r *Record) bool {
+		if r.match(name, source) {
+			records = append(records, r)
+		}
+		return false
+	})
+	sortRecords(records)
+	return records, err
+}
+
+func (rs *redisStore) Clear(name, source string) ([]*Record, error) {
+	var records []*Record
+	err := rs.walk(func(r *Record) bool {
+		if r.match(name, source) {
+			records = append(records, r)
+			return true
+		}
+		return false
+	})
+	sortRecords(records)
+	return records, err
+}
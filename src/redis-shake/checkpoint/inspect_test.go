@@ -0,0 +1,325 @@
+package checkpoint
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alibaba/RedisShake/pkg/redis"
+	utils "github.com/alibaba/RedisShake/redis-shake/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the fake meta redis which only supports the commands used by the redis store
+type fakeRedis struct {
+	ln     net.Listener
+	lock   sync.Mutex
+	hashes map[string]map[string]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err, "should be equal")
+	f := &fakeRedis{ln: ln, hashes: make(map[string]map[string]string)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	for {
+		req, err := redis.Decode(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range req.(*redis.Array).Value {
+			args = append(args, string(arg.(*redis.BulkBytes).Value))
+		}
+		if err := redis.Encode(w, f.do(strings.ToLower(args[0]), args[1:]), true); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) do(cmd string, args []string) redis.Resp {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	bulks := func(values []string) redis.Resp {
+		ret := redis.NewArray()
+		for _, v := range values {
+			ret.AppendBulkBytes([]byte(v))
+		}
+		return ret
+	}
+	switch cmd {
+	case "ping":
+		return &redis.String{Value: []byte("PONG")}
+	case "scan":
+		var names []string
+		for name := range f.hashes {
+			if ok, _ := path.Match(args[2], name); ok {
+				names = append(names, name)
+			}
+		}
+		ret := bulks([]string{"0"}).(*redis.Array)
+		ret.Append(bulks(names))
+		return ret
+	case "type":
+		if f.hashes[args[0]] == nil {
+			return &redis.String{Value: []byte("none")}
+		}
+		return &redis.String{Value: []byte("hash")}
+	case "hmset":
+		if f.hashes[args[0]] == nil {
+			f.hashes[args[0]] = make(map[string]string)
+		}
+		for i := 1; i+1 < len(args); i += 2 {
+			f.hashes[args[0]][args[i]] = args[i+1]
+		}
+		return &redis.String{Value: []byte("OK")}
+	case "hmget":
+		ret := redis.NewArray()
+		for _, field := range args[1:] {
+			if v, ok := f.hashes[args[0]][field]; ok {
+				ret.AppendBulkBytes([]byte(v))
+			} else {
+				ret.AppendBulkBytes(nil)
+			}
+		}
+		return ret
+	case "hgetall":
+		var values []string
+		for field, v := range f.hashes[args[0]] {
+			values = append(values, field, v)
+		}
+		return bulks(values)
+	case "hdel":
+		var n int64
+		for _, field := range args[1:] {
+			if _, ok := f.hashes[args[0]][field]; ok {
+				delete(f.hashes[args[0]], field)
+				n++
+			}
+		}
+		if len(f.hashes[args[0]]) == 0 {
+			delete(f.hashes, args[0])
+		}
+		return redis.NewInt(n)
+	default:
+		return &redis.Error{Value: []byte("ERR unknown command " + cmd)}
+	}
+}
+
+// the fields of the hash in the fake redis
+func (f *fakeRedis) fields(name string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var fields []string
+	for field := range f.hashes[name] {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestParseFields(t *testing.T) {
+	// test parseFields
+
+	var nr int
+	{
+		fmt.Printf("TestParseFields case %d.\n", nr)
+		nr++
+
+		reply := []interface{}{
+			[]byte("10.1.1.1:6379-runid"), []byte("abc"),
+			[]byte("10.1.1.1:6379-offset"), []byte("100"),
+			[]byte("10.1.1.1:6379-version"), []byte("1"),
+			[]byte("redis-1.local:6379-0-offset"), []byte("55"),
+			[]byte("redis-1.local:6379-0-keys_done"), []byte("10"),
+			[]byte("redis-1.local:6379-0-finished"), []byte("1"),
+			[]byte("unknown"), []byte("x"),
+		}
+		cps, err := parseFields(reply)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 2, len(cps), "should be equal")
+		assert.Equal(t, Checkpoint{RunId: "abc", Offset: 100, Version: 1}, *cps["10.1.1.1:6379"], "should be equal")
+		assert.Equal(t, Checkpoint{RunId: "?", Offset: 55, KeysDone: 10, Finished: true},
+			*cps["redis-1.local:6379-0"], "should be equal")
+	}
+
+	{
+		fmt.Printf("TestParseFields case %d.\n", nr)
+		nr++
+
+		reply := []interface{}{[]byte("10.1.1.1:6379-offset"), []byte("abc")}
+		_, err := parseFields(reply)
+		assert.NotEqual(t, nil, err, "should be not equal")
+	}
+
+	{
+		fmt.Printf("TestParseFields case %d.\n", nr)
+		nr++
+
+		// restore checkpoint with the replay window
+		reply := []interface{}{
+			[]byte("dump.rdb-runid"), []byte("?"),
+			[]byte("dump.rdb-offset"), []byte("1024"),
+			[]byte("dump.rdb-keys_done"), []byte("100"),
+			[]byte("dump.rdb-crc"), []byte("12345"),
+			[]byte("dump.rdb-window"), []byte("65636"),
+		}
+		cps, err := parseFields(reply)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, Checkpoint{RunId: "?", Offset: 1024, KeysDone: 100, Crc: 12345, Window: 65636},
+			*cps["dump.rdb"], "should be equal")
+	}
+}
+
+func TestFileStoreClear(t *testing.T) {
+	// test fileStore List and Clear
+
+	var nr int
+
+	dir, err := ioutil.TempDir("", "checkpoint")
+	assert.Equal(t, nil, err, "should be equal")
+	defer os.RemoveAll(dir)
+
+	store := &fileStore{path: filepath.Join(dir, "checkpoint.json")}
+	for _, source := range []string{"10.1.1.1:6379", "10.1.1.2:6379"} {
+		for _, name := range []string{"redis-shake-checkpoint-abc", "redis-shake-checkpoint-def"} {
+			err := store.Save(source, name, &Checkpoint{RunId: "abc", Offset: 100, Version: 1})
+			assert.Equal(t, nil, err, "should be equal")
+		}
+	}
+
+	{
+		fmt.Printf("TestFileStoreClear case %d.\n", nr)
+		nr++
+
+		records, err := store.List("", "")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 4, len(records), "should be equal")
+		assert.Equal(t, "redis-shake-checkpoint-abc", records[0].Name, "should be equal")
+		assert.Equal(t, "10.1.1.1:6379", records[0].Source, "should be equal")
+		assert.Equal(t, "10.1.1.2:6379", records[1].Source, "should be equal")
+
+		records, err = store.List("", "10.1.1.2:6379")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 2, len(records), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestFileStoreClear case %d.\n", nr)
+		nr++
+
+		records, err := store.Clear("redis-shake-checkpoint-def", "10.1.1.1:6379")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 1, len(records), "should be equal")
+
+		records, err = store.List("", "")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 3, len(records), "should be equal")
+
+		cp, err := store.Load(0, "10.1.1.1:6379", "redis-shake-checkpoint-def")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, int64(-1), cp.Offset, "should be equal")
+
+		records, err = store.Clear("", "")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 3, len(records), "should be equal")
+
+		records, err = store.List("", "")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 0, len(records), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestFileStoreClear case %d.\n", nr)
+		nr++
+
+		// restore checkpoint with the replay window
+		cp := Checkpoint{RunId: "?", Offset: 1024, Version: 1, KeysDone: 100, Window: 65636}
+		err := store.Save("dump.rdb", utils.RestoreCheckpointKey, &cp)
+		assert.Equal(t, nil, err, "should be equal")
+
+		records, err := store.List(utils.RestoreCheckpointKey, "")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 1, len(records), "should be equal")
+		assert.Equal(t, cp, records[0].Checkpoint, "should be equal")
+
+		records, err = store.Clear(utils.RestoreCheckpointKey, "dump.rdb")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 1, len(records), "should be equal")
+		assert.Equal(t, int64(65636), records[0].Window, "should be equal")
+
+		loaded, err := store.Load(0, "dump.rdb", utils.RestoreCheckpointKey)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, Checkpoint{RunId: "?", Offset: -1}, *loaded, "should be equal")
+	}
+}
+
+func TestRedisStoreClear(t *testing.T) {
+	// test redisStore List and Clear
+
+	var nr int
+
+	server := newFakeRedis(t)
+	defer server.ln.Close()
+
+	store := &redisStore{address: server.ln.Addr().String()}
+	restore := Checkpoint{RunId: "?", Offset: 1024, Version: 1, KeysDone: 100, Crc: 12345, Window: 65636}
+	assert.Equal(t, nil, store.Save("dump.rdb", utils.RestoreCheckpointKey, &restore), "should be equal")
+	assert.Equal(t, nil, store.Save("10.1.1.1:6379", utils.CheckpointKey, &Checkpoint{RunId: "abc", Offset: 100,
+		Version: 1}), "should be equal")
+
+	{
+		fmt.Printf("TestRedisStoreClear case %d.\n", nr)
+		nr++
+
+		records, err := store.List("", "")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 2, len(records), "should be equal")
+		assert.Equal(t, utils.CheckpointKey, records[0].Name, "should be equal")
+		assert.Equal(t, utils.RestoreCheckpointKey, records[1].Name, "should be equal")
+		assert.Equal(t, "dump.rdb", records[1].Source, "should be equal")
+		assert.Equal(t, restore, records[1].Checkpoint, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestRedisStoreClear case %d.\n", nr)
+		nr++
+
+		// all the fields of the restore checkpoint are deleted
+		records, err := store.Clear(utils.RestoreCheckpointKey, "dump.rdb")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 1, len(records), "should be equal")
+		assert.Equal(t, restore, records[0].Checkpoint, "should be equal")
+		assert.Equal(t, 0, len(server.fields(utils.RestoreCheckpointKey)), "should be equal")
+
+		records, err = store.List("", "")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 1, len(records), "should be equal")
+		assert.Equal(t, "10.1.1.1:6379", records[0].Source, "should be equal")
+	}
+}
@@ -0,0 +1,363 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alibaba/RedisShake/pkg/libs/log"
+	utils "github.com/alibaba/RedisShake/redis-shake/common"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+
+	redigo "github.com/garyburd/redigo/redis"
+)
+
+const (
+	metaTimeout = 10 * time.Second
+)
+
+// Checkpoint is the resume point of one sync link, one rump executor or one restored rdb.
+type Checkpoint struct {
+	RunId   string `json:"runid"`   // source run-id, "?" if unknown
+	Offset  int64  `json:"offset"`  // source offset of the last command applied by the target, -1 if not found
+	Db      int    `json:"db"`      // db selected after the last command
+	Version int    `json:"version"` // checkpoint version
+
+	// only used in rump and restore. in rump, the offset is the scan cursor or the line number of the key file
+	// in the db. in restore, the offset is the rdb file offset after the last entry.
+	KeysDone int64  `json:"keys_done,omitempty"` // number of keys scanned or entries restored before the offset
+	Finished bool   `json:"finished,omitempty"`  // the db or rdb is finished
+	Crc      uint64 `json:"crc,omitempty"`       // checksum of the rdb before the offset
+
+	// only used in restore with key_exists = none, the entries after keys_done and up to the window may be
+	// restored before the break point, so they are restored again with REPLACE semantics.
+	Window int64 `json:"window,omitempty"`
+}
+
+/*
+ * CheckpointStore stores the checkpoint of each sync link, the checkpoint is identified by the source address
+ * and the checkpoint name.
+ * target: the checkpoint is written into the target with the commands in the same transaction.
+ * file: a local file replaced by renaming atomically.
+ * redis: a separate meta redis.
+ * Only the target is transactional, the other stores are written after the commands are acknowledged by the
+ * target, so the commands after the checkpoint may be applied again after restarting.
+ */
+type CheckpointStore interface {
+	// load the checkpoint, the offset is -1 if not found
+	Load(dbSyncerId int, source, name string) (*Checkpoint, error)
+	// save the checkpoint
+	Save(source, name string, cp *Checkpoint) error
+	// whether the checkpoint is written into the target with the commands together
+	Transactional() bool
+	// list the checkpoints matching the name and source, the empty one matches all
+	List(name, source string) ([]*Record, error)
+	// delete the checkpoints matching the name and source, and return them
+	Clear(name, source string) ([]*Record, error)
+}
+
+var (
+	sharedStore     CheckpointStore // file and redis store is shared by all the sync links
+	sharedStoreOnce sync.Once
+)
+
+// NewStore returns the store given in checkpoint.storage, the target is only used by the target store.
+func NewStore(target []string, authType, passwd string, tlsEnable, tlsSkipVerify bool) CheckpointStore {
+	switch conf.Options.CheckpointStorage {
+	case conf.CheckpointStorageFile:
+		sharedStoreOnce.Do(func() {
+			sharedStore = &fileStore{path: conf.Options.CheckpointFile}
+		})
+		return sharedStore
+	case conf.CheckpointStorageRedis:
+		sharedStoreOnce.Do(func() {
+			sharedStore = &redisStore{
+				address:  conf.Options.CheckpointAddress,
+				authType: conf.Options.CheckpointAuthType,
+				passwd:   conf.Options.CheckpointPasswordRaw,
+			}
+		})
+		return sharedStore
+	default:
+		return &targetStore{
+			target:        target,
+			authType:      authType,
+			passwd:        passwd,
+			isCluster:     conf.Options.TargetType == conf.RedisTypeCluster,
+			tlsEnable:     tlsEnable,
+			tlsSkipVerify: tlsSkipVerify,
+		}
+	}
+}
+
+func checkVersion(version int) error {
+	if version != -1 && version < utils.FcvCheckpoint.FeatureCompatibleVersion {
+		return fmt.Errorf("current required checkpoint version[%v] > input[%v], please upgrade RedisShake to version >= %v",
+			utils.FcvCheckpoint.FeatureCompatibleVersion, version,
+			utils.LowestCheckpointVersion[utils.FcvCheckpoint.FeatureCompatibleVersion])
+	}
+	return nil
+}
+
+/*------------------------------------------------------*/
+/*
+ * the checkpoint is stored in the hash of the target, the fields are prefixed by the source address.
+ * it's written with the commands in the same transaction by default, or saved after the commands are
+ * acknowledged if checkpoint.consistency isn't transaction, e.g., the proxy doesn't support "multi".
+ */
+type targetStore struct {
+	target        []string
+	authType      string
+	passwd        string
+	isCluster     bool
+	tlsEnable     bool
+	tlsSkipVerify bool
+
+	lock sync.Mutex
+	c    redigo.Conn // used to save the checkpoint, reopened after error
+	db   int         // db selected by c
+}
+
+func (ts *targetStore) Load(dbSyncerId int, source, name string) (*Checkpoint, error) {
+	if !ts.isCluster {
+		runId, offset, db, err := LoadCheckpoint(dbSyncerId, source, ts.target, ts.authType, ts.passwd, name, false,
+			ts.tlsEnable, ts.tlsSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		return &Checkpoint{RunId: runId, Offset: offset, Db: db}, nil
+	}
+
+	// only db0 is available in cluster
+	c := utils.OpenRedisConn(ts.target, ts.authType, ts.passwd, true, ts.tlsEnable, ts.tlsSkipVerify)
+	defer c.Close()
+	runId, offset, version, err := fetchCheckpoint(source, c, 0, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(version); err != nil {
+		return nil, err
+	}
+	return &Checkpoint{RunId: runId, Offset: offset, Version: version}, nil
+}
+
+func (ts *targetStore) Save(source, name string, cp *Checkpoint) error {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+
+	if ts.c == nil {
+		c, err := utils.TryOpenRedisConnWithTimeout(ts.target, ts.authType, ts.passwd, metaTimeout, metaTimeout,
+			ts.isCluster, ts.tlsEnable, ts.tlsSkipVerify)
+		if err != nil {
+			return err
+		}
+		ts.c, ts.db = c, 0
+	}
+
+	err := ts.save(source, name, cp)
+	if err != nil {
+		ts.c.Close()
+		ts.c = nil
+	}
+	return err
+}
+
+// the db is selected only if it's changed, some proxies don't support "select".
+func (ts *targetStore) save(source, name string, cp *Checkpoint) error {
+	if !ts.isCluster && cp.Db != ts.db {
+		if _, err := ts.c.Do("select", cp.Db); err != nil {
+			return fmt.Errorf("select db[%v] failed[%v]", cp.Db, err)
+		}
+		ts.db = cp.Db
+	}
+	_, err := ts.c.Do("hmset", name, fmt.Sprintf("%s-%s", source, utils.CheckpointRunId), cp.RunId,
+		fmt.Sprintf("%s-%s", source, utils.CheckpointVersion), cp.Version,
+		fmt.Sprintf("%s-%s", source, utils.CheckpointOffset), cp.Offset)
+	return err
+}
+
+func (ts *targetStore) Transactional() bool {
+	return conf.Options.CheckpointConsistency == conf.CheckpointConsistencyTransaction
+}
+
+/*------------------------------------------------------*/
+// the checkpoints are stored in a json file: checkpoint name -> source address -> checkpoint.
+type fileStore struct {
+	path string
+	lock sync.Mutex
+}
+
+func (fs *fileStore) read() (map[string]map[string]*Checkpoint, error) {
+	data := make(map[string]map[string]*Checkpoint)
+	content, err := ioutil.ReadFile(fs.path)
+	if os.IsNotExist(err) {
+		return data, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read checkpoint file[%v] failed[%v]", fs.path, err)
+	}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("parse checkpoint file[%v] failed[%v]", fs.path, err)
+	}
+	return data, nil
+}
+
+func (fs *fileStore) Load(dbSyncerId int, source, name string) (*Checkpoint, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	data, err := fs.read()
+	if err != nil {
+		return nil, err
+	}
+	cp, ok := data[name][source]
+	if !ok {
+		return &Checkpoint{RunId: "?", Offset: -1}, nil
+	}
+	log.Infof("DbSyncer[%d] load checkpoint[%v] from file[%v]: %+v", dbSyncerId, name, fs.path, *cp)
+	if err := checkVersion(cp.Version); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (fs *fileStore) Save(source, name string, cp *Checkpoint) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	data, err := fs.read()
+	if err != nil {
+		return err
+	}
+	if data[name] == nil {
+		data[name] = make(map[string]*Checkpoint)
+	}
+	data[name][source] = cp
+	return fs.write(data)
+}
+
+// the file is written into a temporary file and then renamed, so it's always complete.
+func (fs *fileStore) write(data map[string]map[string]*Checkpoint) error {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(fs.path), filepath.Base(fs.path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary checkpoint file failed[%v]", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write temporary checkpoint file failed[%v]", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync temporary checkpoint file failed[%v]", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), fs.path); err != nil {
+		return fmt.Errorf("rename checkpoint file[%v] failed[%v]", fs.path, err)
+	}
+	return nil
+}
+
+func (fs *fileStore) Transactional() bool {
+	return false
+}
+
+/*------------------------------------------------------*/
+// the checkpoint is stored in the hash of the meta redis like the target, and the db is also stored.
+type redisStore struct {
+	address  string
+	authType string
+	passwd   string
+	lock     sync.Mutex
+	c        redigo.Conn // reopened after error
+}
+
+func (rs *redisStore) do(cmd string, args ...interface{}) (interface{}, error) {
+	if rs.c == nil {
+		c, err := utils.TryOpenRedisConnWithTimeout([]string{rs.address}, rs.authType, rs.passwd, metaTimeout,
+			metaTimeout, false, false, false)
+		if err != nil {
+			return nil, fmt.Errorf("connect meta redis[%v] failed[%v]", rs.address, err)
+		}
+		rs.c = c
+	}
+
+	reply, err := rs.c.Do(cmd, args...)
+	if err != nil {
+		rs.c.Close()
+		rs.c = nil
+	}
+	return reply, err
+}
+
+func (rs *redisStore) Load(dbSyncerId int, source, name string) (*Checkpoint, error) {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	reply, err := redigo.Strings(rs.do("hmget", name, fmt.Sprintf("%s-%s", source, utils.CheckpointRunId),
+		fmt.Sprintf("%s-%s", source, utils.CheckpointOffset), fmt.Sprintf("%s-%s", source, utils.CheckpointDb),
+		fmt.Sprintf("%s-%s", source, utils.CheckpointVersion), fmt.Sprintf("%s-%s", source, utils.CheckpointKeysDone),
+		fmt.Sprintf("%s-%s", source, utils.CheckpointFinished), fmt.Sprintf("%s-%s", source, utils.CheckpointCrc),
+		fmt.Sprintf("%s-%s", source, utils.CheckpointWindow)))
+	if err != nil {
+		return nil, fmt.Errorf("load checkpoint from meta redis[%v] failed[%v]", rs.address, err)
+	}
+	if reply[1] == "" {
+		return &Checkpoint{RunId: "?", Offset: -1}, nil
+	}
+
+	cp := &Checkpoint{RunId: reply[0]}
+	if cp.Offset, err = strconv.ParseInt(reply[1], 10, 64); err != nil {
+		return nil, fmt.Errorf("parse offset[%v] failed[%v]", reply[1], err)
+	}
+	if cp.Db, err = strconv.Atoi(reply[2]); err != nil && reply[2] != "" {
+		return nil, fmt.Errorf("parse db[%v] failed[%v]", reply[2], err)
+	}
+	if cp.Version, err = strconv.Atoi(reply[3]); err != nil && reply[3] != "" {
+		return nil, fmt.Errorf("parse version[%v] failed[%v]", reply[3], err)
+	}
+	if cp.KeysDone, err = strconv.ParseInt(reply[4], 10, 64); err != nil && reply[4] != "" {
+		return nil, fmt.Errorf("parse keys done[%v] failed[%v]", reply[4], err)
+	}
+	cp.Finished = reply[5] == "1"
+	if cp.Crc, err = strconv.ParseUint(reply[6], 10, 64); err != nil && reply[6] != "" {
+		return nil, fmt.Errorf("parse crc[%v] failed[%v]", reply[6], err)
+	}
+	if cp.Window, err = strconv.ParseInt(reply[7], 10, 64); err != nil && reply[7] != "" {
+		return nil, fmt.Errorf("parse window[%v] failed[%v]", reply[7], err)
+	}
+	log.Infof("DbSyncer[%d] load checkpoint[%v] from meta redis[%v]: %+v", dbSyncerId, name, rs.address, *cp)
+	if err := checkVersion(cp.Version); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+func (rs *redisStore) Save(source, name string, cp *Checkpoint) error {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	_, err := rs.do("hmset", name, fmt.Sprintf("%s-%s", source, utils.CheckpointRunId), cp.RunId,
+		fmt.Sprintf("%s-%s", source, utils.CheckpointOffset), cp.Offset,
+		fmt.Sprintf("%s-%s", source, utils.CheckpointDb), cp.Db,
+		fmt.Sprintf("%s-%s", source, utils.CheckpointVersion), cp.Version,
+		fmt.Sprintf("%s-%s", source, utils.CheckpointKeysDone), cp.KeysDone,
+		fmt.Sprintf("%s-%s", source, utils.CheckpointFinished), cp.Finished,
+		fmt.Sprintf("%s-%s", source, utils.CheckpointCrc), cp.Crc,
+		fmt.Sprintf("%s-%s", source, utils.CheckpointWindow), cp.Window)
+	return err
+}
+
+func (rs *redisStore) Transactional() bool {
+	return false
+}
@@ -0,0 +1,140 @@
+package checkpoint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	utils "github.com/alibaba/RedisShake/redis-shake/common"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStore(t *testing.T) {
+	// test fileStore
+
+	var nr int
+
+	dir, err := ioutil.TempDir("", "checkpoint")
+	assert.Equal(t, nil, err, "should be equal")
+	defer os.RemoveAll(dir)
+
+	store := &fileStore{path: filepath.Join(dir, "checkpoint.json")}
+
+	{
+		fmt.Printf("TestFileStore case %d.\n", nr)
+		nr++
+
+		// not exist
+		cp, err := store.Load(0, "10.1.1.1:6379", "redis-shake-checkpoint")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, "?", cp.RunId, "should be equal")
+		assert.Equal(t, int64(-1), cp.Offset, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestFileStore case %d.\n", nr)
+		nr++
+
+		err := store.Save("10.1.1.1:6379", "redis-shake-checkpoint", &Checkpoint{RunId: "abc", Offset: 100, Db: 2,
+			Version: 1})
+		assert.Equal(t, nil, err, "should be equal")
+		err = store.Save("10.1.1.2:6379", "redis-shake-checkpoint", &Checkpoint{RunId: "def", Offset: 200, Version: 1})
+		assert.Equal(t, nil, err, "should be equal")
+		err = store.Save("10.1.1.1:6379", "redis-shake-checkpoint", &Checkpoint{RunId: "abc", Offset: 300, Db: 3,
+			Version: 1})
+		assert.Equal(t, nil, err, "should be equal")
+
+		cp, err := store.Load(0, "10.1.1.1:6379", "redis-shake-checkpoint")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, Checkpoint{RunId: "abc", Offset: 300, Db: 3, Version: 1}, *cp, "should be equal")
+
+		cp, err = store.Load(0, "10.1.1.2:6379", "redis-shake-checkpoint")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, int64(200), cp.Offset, "should be equal")
+
+		// no temporary file left
+		files, _ := ioutil.ReadDir(dir)
+		assert.Equal(t, 1, len(files), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestFileStore case %d.\n", nr)
+		nr++
+
+		// incompatible version
+		err := store.Save("10.1.1.3:6379", "redis-shake-checkpoint", &Checkpoint{RunId: "abc", Offset: 100})
+		assert.Equal(t, nil, err, "should be equal")
+		_, err = store.Load(0, "10.1.1.3:6379", "redis-shake-checkpoint")
+		assert.NotEqual(t, nil, err, "should be not equal")
+	}
+
+	{
+		fmt.Printf("TestFileStore case %d.\n", nr)
+		nr++
+
+		// rump checkpoint
+		err := store.Save("10.1.1.1:6379-0", "redis-shake-rump-checkpoint", &Checkpoint{RunId: "?", Offset: 12345,
+			Db: 1, Version: 1, KeysDone: 500})
+		assert.Equal(t, nil, err, "should be equal")
+		err = store.Save("10.1.1.1:6379-1", "redis-shake-rump-checkpoint", &Checkpoint{RunId: "?", Offset: 0,
+			Db: 2, Version: 1, KeysDone: 800, Finished: true})
+		assert.Equal(t, nil, err, "should be equal")
+
+		cp, err := store.Load(0, "10.1.1.1:6379-0", "redis-shake-rump-checkpoint")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, Checkpoint{RunId: "?", Offset: 12345, Db: 1, Version: 1, KeysDone: 500}, *cp,
+			"should be equal")
+
+		cp, err = store.Load(0, "10.1.1.1:6379-1", "redis-shake-rump-checkpoint")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, true, cp.Finished, "should be equal")
+
+		// the sync checkpoint is not affected
+		cp, err = store.Load(0, "10.1.1.1:6379", "redis-shake-checkpoint")
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, int64(300), cp.Offset, "should be equal")
+	}
+}
+
+func TestStoreWindow(t *testing.T) {
+	// test the replay window of the restore checkpoint round-trips, only the file and redis store are used in restore
+
+	var nr int
+
+	dir, err := ioutil.TempDir("", "checkpoint")
+	assert.Equal(t, nil, err, "should be equal")
+	defer os.RemoveAll(dir)
+
+	server := newFakeRedis(t)
+	defer server.ln.Close()
+
+	stores := []CheckpointStore{
+		&fileStore{path: filepath.Join(dir, "checkpoint.json")},
+		&redisStore{address: server.ln.Addr().String()},
+	}
+	for _, store := range stores {
+		fmt.Printf("TestStoreWindow case %d.\n", nr)
+		nr++
+
+		// not exist
+		cp, err := store.Load(0, "dump.rdb", utils.RestoreCheckpointKey)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, int64(0), cp.Window, "should be equal")
+
+		saved := Checkpoint{RunId: "?", Offset: 1024, Version: 1, KeysDone: 100, Crc: 12345, Window: 65636}
+		assert.Equal(t, nil, store.Save("dump.rdb", utils.RestoreCheckpointKey, &saved), "should be equal")
+		cp, err = store.Load(0, "dump.rdb", utils.RestoreCheckpointKey)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, saved, *cp, "should be equal")
+
+		// the window is moved
+		saved.KeysDone, saved.Window = 200, 65736
+		assert.Equal(t, nil, store.Save("dump.rdb", utils.RestoreCheckpointKey, &saved), "should be equal")
+		cp, err = store.Load(0, "dump.rdb", utils.RestoreCheckpointKey)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, saved, *cp, "should be equal")
+	}
+}
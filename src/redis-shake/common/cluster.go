@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"fmt"
+	"io"
+	"sync"
+
 	"github.com/alibaba/RedisShake/pkg/libs/log"
 
 	redigo "github.com/garyburd/redigo/redis"
@@ -20,6 +24,17 @@ type ClusterConn struct {
 	client   *redigoCluster.Cluster
 	recvChan chan reply
 	batcher  *redigoCluster.Batch
+	closer   sync.Once
+}
+
+// BatchError is returned when the whole batch is failed in the cluster, e.g., the node is down. None
+// of the replies in this batch is returned in this case.
+type BatchError struct {
+	Err error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("run batch failed[%v]", e.Err)
 }
 
 type reply struct {
@@ -38,8 +53,12 @@ func NewClusterConn(clusterClient *redigoCluster.Cluster, recvChanSize int) redi
 	}
 }
 
+// close the client and the recvChan so that the blocked "Receive" returns
 func (cc *ClusterConn) Close() error {
-	cc.client.Close()
+	cc.closer.Do(func() {
+		cc.client.Close()
+		close(cc.recvChan)
+	})
 	return nil
 }
 
@@ -72,6 +91,7 @@ func (cc *ClusterConn) Flush() error {
 	}()
 
 	if err != nil {
+		err = &BatchError{Err: err}
 		cc.recvChan <- reply{
 			answer: nil,
 			err:    err,
@@ -103,6 +123,9 @@ func (cc *ClusterConn) Flush() error {
 
 // read recvChan
 func (cc *ClusterConn) Receive() (reply interface{}, err error) {
-	ret := <-cc.recvChan
+	ret, ok := <-cc.recvChan
+	if !ok {
+		return nil, io.EOF
+	}
 	return ret.answer, ret.err
 }
@@ -190,7 +190,10 @@ type SlotOwner struct {
 }
 
 func GetSlotDistribution(target, authType, auth string, tlsEnable bool, tlsSkipVerify bool) ([]SlotOwner, error) {
-	c := OpenRedisConn([]string{target}, authType, auth, false, tlsEnable, tlsSkipVerify)
+	c, err := TryOpenRedisConnWithTimeout([]string{target}, authType, auth, 0, 0, false, tlsEnable, tlsSkipVerify)
+	if err != nil {
+		return nil, err
+	}
 	defer c.Close()
 
 	content, err := c.Do("cluster", "slots")
@@ -235,6 +238,27 @@ func GetSlotDistribution(target, authType, auth string, tlsEnable bool, tlsSkipV
 	return ret, nil
 }
 
+// GetSlotMaster returns the master which owns the given slot, the candidates are asked in order until
+// one of them answers.
+func GetSlotMaster(candidates []string, authType, auth string, tlsEnable bool, tlsSkipVerify bool,
+	slot int) (string, error) {
+	err := fmt.Errorf("no candidate")
+	for _, candidate := range candidates {
+		var shardList []SlotOwner
+		if shardList, err = GetSlotDistribution(candidate, authType, auth, tlsEnable, tlsSkipVerify); err != nil {
+			continue
+		}
+
+		for _, shard := range shardList {
+			if slot >= shard.SlotLeftBoundary && slot <= shard.SlotRightBoundary {
+				return shard.Master, nil
+			}
+		}
+		err = fmt.Errorf("slot isn't covered by candidate[%v]", candidate)
+	}
+	return "", fmt.Errorf("get master of slot[%v] failed[%v]", slot, err)
+}
+
 func CheckSlotDistributionEqual(src, dst []SlotOwner) bool {
 	if len(src) != len(dst) {
 		return false
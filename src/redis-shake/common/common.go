@@ -41,6 +41,15 @@ const (
 	CheckpointOffset  = "offset"
 	CheckpointRunId   = "runid"
 	CheckpointVersion = "version"
+	CheckpointDb      = "db"
+
+	// rump and restore checkpoint
+	RumpCheckpointKey    = "redis-shake-rump-checkpoint"
+	RestoreCheckpointKey = "redis-shake-restore-checkpoint"
+	CheckpointKeysDone   = "keys_done"
+	CheckpointFinished   = "finished"
+	CheckpointCrc        = "crc"
+	CheckpointWindow     = "window"
 )
 
 var (
@@ -100,7 +109,8 @@ func ParseInfo(content []byte) map[string]string {
 func GetTotalLink() int {
 	if conf.Options.Type == conf.TypeSync || conf.Options.Type == conf.TypeRump || conf.Options.Type == conf.TypeDump {
 		return len(conf.Options.SourceAddressList)
-	} else if conf.Options.Type == conf.TypeDecode || conf.Options.Type == conf.TypeRestore {
+	} else if conf.Options.Type == conf.TypeDecode || conf.Options.Type == conf.TypeRestore ||
+		conf.Options.Type == conf.TypeReplay {
 		return len(conf.Options.SourceRdbInput)
 	}
 	return 0
@@ -26,14 +26,18 @@ func ParseAddress(tp string) error {
 		}
 	}
 
-	// check target
-	if tp == conf.TypeRestore || tp == conf.TypeSync || tp == conf.TypeRump {
+	// check target, the checkpoint is stored in the target by default. the keys are written into the local rdb
+	// files instead of the target in rump if target.type is rdb.
+	if tp == conf.TypeRestore || tp == conf.TypeSync || tp == conf.TypeReplay ||
+		tp == conf.TypeRump && conf.Options.TargetType != conf.TargetTypeRdb ||
+		tp == conf.TypeCheckpoint && (conf.Options.CheckpointStorage == "" ||
+			conf.Options.CheckpointStorage == conf.CheckpointStorageTarget) {
 		if err := parseAddress(tp, conf.Options.TargetAddress, conf.Options.TargetType, false); err != nil {
 			return err
 		}
 
 		if len(conf.Options.TargetAddressList) == 0 {
-			return fmt.Errorf("target address shouldn't be empty when type in {restore, sync, rump}")
+			return fmt.Errorf("target address shouldn't be empty when type in {restore, sync, rump, replay, checkpoint}")
 		}
 	}
 
@@ -55,45 +59,14 @@ func parseAddress(tp, address, redisType string, isSource bool) error {
 		}*/
 		setAddressList(isSource, address)
 	case conf.RedisTypeSentinel:
-		arr := strings.Split(address, AddressSplitter)
-		if len(arr) != 2 {
-			return fmt.Errorf("redis type[%v] address[%v] must begin with or has '%v': e.g., \"master@ip1:port1;ip2:port2\", "+
-				"\"@ip1:port1,ip2:port2\"",
-				conf.RedisTypeSentinel, address, AddressSplitter)
-		}
-
-		var masterName string
-		var fromMaster bool
-		if strings.Contains(arr[0], AddressHeaderSplitter) {
-			arrHeader := strings.Split(arr[0], AddressHeaderSplitter)
-			if isSource {
-				masterName = arrHeader[0]
-				fromMaster = arrHeader[1] == conf.StandAloneRoleMaster
-			} else {
-				masterName = arrHeader[0]
-				fromMaster = true
-			}
-		} else {
-			masterName = arr[0]
-			fromMaster = true
+		addr, err := ResolveSentinelAddress(address, isSource)
+		if err != nil {
+			return err
 		}
-
-		clusterList := strings.Split(arr[1], AddressClusterSplitter)
-
 		if isSource {
-			// get real source
-			if source, err := GetReadableRedisAddressThroughSentinel(clusterList, masterName, fromMaster); err != nil {
-				return err
-			} else {
-				conf.Options.SourceAddressList = []string{source}
-			}
+			conf.Options.SourceAddressList = []string{addr}
 		} else {
-			// get real target
-			if target, err := GetWritableRedisAddressThroughSentinel(clusterList, masterName); err != nil {
-				return err
-			} else {
-				conf.Options.TargetAddressList = []string{target}
-			}
+			conf.Options.TargetAddressList = []string{addr}
 		}
 	case conf.RedisTypeCluster:
 		// get auth type and password
@@ -184,6 +157,61 @@ func parseAddress(tp, address, redisType string, isSource bool) error {
 	return nil
 }
 
+/*
+ * resolve the real address of the sentinel address like "master@ip1:port1;ip2:port2", the address may change
+ * after failover.
+ * source: the master address or a random slave address according to the role, default is master.
+ * target: always the master address.
+ */
+func ResolveSentinelAddress(address string, isSource bool) (string, error) {
+	masterName, fromMaster, clusterList, err := ParseSentinelAddress(address, isSource)
+	if err != nil {
+		return "", err
+	}
+
+	if isSource {
+		// get real source
+		return GetReadableRedisAddressThroughSentinel(clusterList, masterName, fromMaster)
+	}
+	// get real target
+	return GetWritableRedisAddressThroughSentinel(clusterList, masterName)
+}
+
+/*
+ * parse the sentinel address like "master:role@ip1:port1;ip2:port2"
+ * @return:
+ *     string: master name
+ *     bool: read from the master or slave, always true for the target
+ *     []string: sentinel address list
+ *     error
+ */
+func ParseSentinelAddress(address string, isSource bool) (string, bool, []string, error) {
+	arr := strings.Split(address, AddressSplitter)
+	if len(arr) != 2 {
+		return "", false, nil, fmt.Errorf("redis type[%v] address[%v] must begin with or has '%v': e.g., "+
+			"\"master@ip1:port1;ip2:port2\", \"@ip1:port1,ip2:port2\"",
+			conf.RedisTypeSentinel, address, AddressSplitter)
+	}
+
+	var masterName string
+	var fromMaster bool
+	if strings.Contains(arr[0], AddressHeaderSplitter) {
+		arrHeader := strings.Split(arr[0], AddressHeaderSplitter)
+		if isSource {
+			masterName = arrHeader[0]
+			fromMaster = arrHeader[1] == conf.StandAloneRoleMaster
+		} else {
+			masterName = arrHeader[0]
+			fromMaster = true
+		}
+	} else {
+		masterName = arr[0]
+		fromMaster = true
+	}
+
+	return masterName, fromMaster, strings.Split(arr[1], AddressClusterSplitter), nil
+}
+
 func splitCluster(input string) []string {
 	return strings.Split(input, AddressClusterSplitter)
 }
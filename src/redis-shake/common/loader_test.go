@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/alibaba/RedisShake/pkg/libs/atomic2"
+	"github.com/alibaba/RedisShake/pkg/rdb"
+	"github.com/alibaba/RedisShake/pkg/rdb/digest"
+)
+
+/*
+ * the rdb of the keys whose value is the lzf string of n "a", so most of the loading time is decompressing.
+ * The lzf string is a literal "a" and the back references of 264 bytes.
+ */
+func newLZFRdb(b *testing.B, keys, refs int) []byte {
+	var value bytes.Buffer
+	value.WriteByte(0xc0 | 3) // lzf
+	lzf := []byte{0, 'a'}
+	for i := 0; i < refs; i++ {
+		lzf = append(lzf, 0xe0, 255, 0)
+	}
+	for _, n := range []int{len(lzf), 1 + 264*refs} {
+		value.WriteByte(0x80) // 32 bit length
+		binary.Write(&value, binary.BigEndian, uint32(n))
+	}
+	value.Write(lzf)
+
+	// the dump payload: type, value, rdb version and checksum
+	var p bytes.Buffer
+	p.WriteByte(rdb.RdbTypeString)
+	p.Write(value.Bytes())
+	binary.Write(&p, binary.LittleEndian, uint16(rdb.ToVersion))
+	c := digest.New()
+	c.Write(p.Bytes())
+	binary.Write(&p, binary.LittleEndian, c.Sum64())
+
+	var out bytes.Buffer
+	w := rdb.NewWriter(&out, nil)
+	for i := 0; i < keys; i++ {
+		if err := w.WriteEntry(0, []byte(fmt.Sprintf("key%d", i)), 0, p.Bytes()); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		b.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func BenchmarkRDBLoader(b *testing.B) {
+	p := newLZFRdb(b, 1000, 256)
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var rbytes atomic2.Int64
+		for range NewRDBLoader(bufio.NewReader(bytes.NewReader(p)), &rbytes, 1024) {
+		}
+	}
+}
+
+// the consumers decompress and encode the values in parallel, it's faster with more cpus.
+func BenchmarkParallelRDBLoader(b *testing.B) {
+	benchmarkParallelRDBLoader(b, true)
+}
+
+// only the sequential framing of the parallel loader, which limits the speed of BenchmarkParallelRDBLoader.
+func BenchmarkParallelRDBLoaderFraming(b *testing.B) {
+	benchmarkParallelRDBLoader(b, false)
+}
+
+func benchmarkParallelRDBLoader(b *testing.B, encode bool) {
+	p := newLZFRdb(b, 1000, 256)
+	n := runtime.GOMAXPROCS(0)
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var rbytes atomic2.Int64
+		pipes, _ := NewParallelRDBLoader(bufio.NewReader(bytes.NewReader(p)), &rbytes, 1024/n, n, nil)
+		var wg sync.WaitGroup
+		wg.Add(n)
+		for _, pipe := range pipes {
+			go func(pipe chan *rdb.BinEntry) {
+				defer wg.Done()
+				for e := range pipe {
+					if !encode {
+						continue
+					}
+					if err := e.EncodeValue(); err != nil {
+						b.Error(err)
+					}
+				}
+			}(pipe)
+		}
+		wg.Wait()
+	}
+}
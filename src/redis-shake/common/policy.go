@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/alibaba/RedisShake/pkg/libs/log"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+	"github.com/alibaba/RedisShake/redis-shake/deadletter"
+)
+
+// ErrorClass returns the first word of the error reply, e.g., "WRONGTYPE", "OOM".
+func ErrorClass(err error) string {
+	msg := strings.TrimSpace(err.Error())
+	if i := strings.IndexByte(msg, ' '); i != -1 {
+		msg = msg[:i]
+	}
+	return strings.ToUpper(msg)
+}
+
+// ErrorPolicy returns the policy of the error reply given in target.error_policy.
+func ErrorPolicy(err error) string {
+	if policy, ok := conf.Options.TargetErrPolicy[ErrorClass(err)]; ok {
+		return policy
+	}
+	if policy, ok := conf.Options.TargetErrPolicy[conf.ErrorClassDefault]; ok {
+		return policy
+	}
+	return conf.ErrorPolicyPanic
+}
+
+// WriteDeadLetter writes the failed command into the dead-letter file, offset is -1 if unknown.
+func WriteDeadLetter(source string, db int, offset int64, cmd string, args []interface{}, err error) error {
+	return deadletter.Write(&deadletter.Entry{
+		Source:  source,
+		Db:      db,
+		Offset:  offset,
+		Error:   err.Error(),
+		Command: cmd,
+		Args:    deadletter.Args(args),
+	})
+}
+
+/*
+ * IgnoreErrorReply handles the error reply of the command which isn't resent, e.g., "restore" in `restore` and
+ * `rump`. true is returned if the command is skipped or written into the dead-letter file, otherwise the
+ * caller should panic as before. The "retry" policy is only supported in `sync`, so it's regarded as "panic".
+ */
+func IgnoreErrorReply(source string, db int, cmd string, args []interface{}, err error) bool {
+	policy := ErrorPolicy(err)
+	if policy != conf.ErrorPolicySkip && policy != conf.ErrorPolicyDeadLetter {
+		return false
+	}
+
+	// the value of "restore" is binary, so only the key is printed
+	var key interface{}
+	if len(args) > 0 {
+		key = args[0]
+		if b, ok := key.([]byte); ok {
+			key = string(b)
+		}
+	}
+	log.Warnf("Event:ErrorReply\tId:%s\tCommand: [%s %v]\tDb: %d\tError: %s\tPolicy: %s",
+		conf.Options.Id, cmd, key, db, err.Error(), policy)
+
+	if policy == conf.ErrorPolicyDeadLetter {
+		if e := WriteDeadLetter(source, db, -1, cmd, args, err); e != nil {
+			log.Panicf("write dead-letter failed[%v]", e)
+		}
+	}
+	return true
+}
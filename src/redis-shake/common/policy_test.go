@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/alibaba/RedisShake/pkg/rdb"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+
+	redigo "github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorPolicy(t *testing.T) {
+	// test ErrorClass and ErrorPolicy
+
+	var nr int
+
+	conf.Options.TargetErrPolicy = map[string]string{
+		conf.ErrorClassDefault: conf.ErrorPolicyPanic,
+		"WRONGTYPE":            conf.ErrorPolicySkip,
+		"OOM":                  conf.ErrorPolicyRetry,
+	}
+
+	{
+		fmt.Printf("TestErrorPolicy case %d.\n", nr)
+		nr++
+
+		assert.Equal(t, "WRONGTYPE", ErrorClass(redigo.Error("WRONGTYPE Operation against a key")), "should be equal")
+		assert.Equal(t, "ERR", ErrorClass(redigo.Error("ERR unknown command")), "should be equal")
+		assert.Equal(t, "NOSCRIPT", ErrorClass(redigo.Error("NOSCRIPT")), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestErrorPolicy case %d.\n", nr)
+		nr++
+
+		assert.Equal(t, conf.ErrorPolicySkip, ErrorPolicy(redigo.Error("WRONGTYPE Operation")), "should be equal")
+		assert.Equal(t, conf.ErrorPolicyRetry, ErrorPolicy(redigo.Error("OOM command not allowed")), "should be equal")
+		assert.Equal(t, conf.ErrorPolicyPanic, ErrorPolicy(redigo.Error("ERR unknown command")), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestErrorPolicy case %d.\n", nr)
+		nr++
+
+		// retry isn't supported without resending
+		assert.Equal(t, true, IgnoreErrorReply("rdb.0", 0, "restore", []interface{}{[]byte("a"), 0, []byte("v")},
+			redigo.Error("WRONGTYPE Operation")), "should be equal")
+		assert.Equal(t, false, IgnoreErrorReply("rdb.0", 0, "restore", []interface{}{[]byte("a"), 0, []byte("v")},
+			redigo.Error("OOM command not allowed")), "should be equal")
+	}
+}
+
+// the target replies "restore" with doReply and the other commands with recvReply.
+type fakeConn struct {
+	doReply   error
+	recvReply error
+	sent      []string
+}
+
+func (c *fakeConn) Close() error { return nil }
+func (c *fakeConn) Err() error   { return nil }
+func (c *fakeConn) Flush() error { return nil }
+
+func (c *fakeConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return nil, c.doReply
+}
+
+func (c *fakeConn) Send(cmd string, args ...interface{}) error {
+	c.sent = append(c.sent, cmd)
+	return nil
+}
+
+func (c *fakeConn) Receive() (interface{}, error) {
+	return nil, c.recvReply
+}
+
+func TestRestoreBadDataFormat(t *testing.T) {
+	// test the error replies of splitting the value after "Bad data format"
+
+	var nr int
+
+	conf.Options.TargetErrPolicy = map[string]string{
+		conf.ErrorClassDefault: conf.ErrorPolicyPanic,
+		"WRONGTYPE":            conf.ErrorPolicySkip,
+	}
+	conf.Options.TargetVersion = "4.0"
+	conf.Options.BigKeyThreshold = 1 << 20
+	defer func() {
+		conf.Options.TargetVersion = ""
+		conf.Options.BigKeyThreshold = 0
+	}()
+
+	{
+		fmt.Printf("TestRestoreBadDataFormat case %d.\n", nr)
+		nr++
+
+		// the listpack of "f", "v"
+		lp := []byte{13, 0, 0, 0, 2, 0, 0x81, 'f', 2, 0x81, 'v', 2, 0xff}
+		value := append([]byte{rdb.RdbTypeHashListpack, byte(len(lp))}, lp...)
+		c := &fakeConn{
+			doReply:   redigo.Error("ERR Bad data format"),
+			recvReply: redigo.Error("WRONGTYPE Operation against a key"),
+		}
+		e := &rdb.BinEntry{Key: []byte("hash"), Type: rdb.RdbTypeHashListpack, Value: value}
+		RestoreRdbEntry(c, e, "rdb.0", 0)
+		assert.Equal(t, []string{"HSET"}, c.sent, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestRestoreBadDataFormat case %d.\n", nr)
+		nr++
+
+		// the first error reply is returned after receiving all the replies
+		c := &fakeConn{recvReply: redigo.Error("OOM command not allowed")}
+		assert.Equal(t, redigo.Error("OOM command not allowed"), flushReplies(c, 3), "should be equal")
+		c.recvReply = nil
+		assert.Equal(t, nil, flushReplies(c, 3), "should be equal")
+	}
+}
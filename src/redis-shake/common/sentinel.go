@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alibaba/RedisShake/pkg/libs/log"
+
+	redigo "github.com/garyburd/redigo/redis"
+)
+
+const (
+	// message: <master name> <old ip> <old port> <new ip> <new port>
+	SentinelSwitchMasterChannel = "+switch-master"
+)
+
+var (
+	// ping the sentinel in this interval to check the subscription alive
+	sentinelPingInterval = 10 * time.Second
+)
+
+/*
+ * watch the "+switch-master" event of the sentinel address like "master@ip1:port1;ip2:port2", and call the
+ * onSwitch with the master address. The onSwitch is also called after each subscription, because the event may
+ * be missed while subscribing, so it should ignore the unchanged address.
+ * The sentinels are subscribed in turn once the subscription is broken, so this function never returns
+ * unless the address is illegal.
+ */
+func WatchSentinelMaster(address string, onSwitch func(string)) {
+	masterName, _, sentinels, err := ParseSentinelAddress(address, false)
+	if err != nil {
+		log.Errorf("watch sentinel address[%v] failed[%v]", address, err)
+		return
+	}
+
+	for i := 0; ; i++ {
+		sentinel := sentinels[i%len(sentinels)]
+		err := watchSentinel(sentinel, sentinels, masterName, onSwitch)
+		log.Warnf("watch master[%v] on sentinel[%v] broken[%v], try the next sentinel", masterName, sentinel, err)
+		time.Sleep(time.Second)
+	}
+}
+
+func watchSentinel(sentinel string, sentinels []string, masterName string, onSwitch func(string)) error {
+	c, err := redigo.DialTimeout("tcp", sentinel, 500*time.Millisecond, 2*sentinelPingInterval,
+		500*time.Millisecond)
+	if err != nil {
+		return err
+	}
+
+	psc := redigo.PubSubConn{Conn: c}
+	defer psc.Close()
+	if err := psc.Subscribe(SentinelSwitchMasterChannel); err != nil {
+		return err
+	}
+
+	// keep the subscription alive, the read times out if no pong returned
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(sentinelPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := psc.Ping(""); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redigo.Message:
+			if addr, ok := parseSwitchMaster(v.Data, masterName); ok {
+				log.Infof("sentinel[%v] master[%v] switched to %v", sentinel, masterName, addr)
+				onSwitch(addr)
+			}
+		case redigo.Subscription:
+			log.Infof("watch master[%v] on sentinel[%v]", masterName, sentinel)
+			// the switch may happen before subscribing
+			if addr, err := GetWritableRedisAddressThroughSentinel(sentinels, masterName); err == nil {
+				onSwitch(addr)
+			}
+		case error:
+			return v
+		}
+	}
+}
+
+// parse the "+switch-master" message and return the new master address if the master name matches.
+func parseSwitchMaster(data []byte, masterName string) (string, bool) {
+	fields := strings.Fields(string(data))
+	if len(fields) != 5 || fields[0] != masterName {
+		return "", false
+	}
+	return fmt.Sprintf("%s:%s", fields[3], fields[4]), true
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSwitchMaster(t *testing.T) {
+	var nr int
+	{
+		fmt.Printf("TestParseSwitchMaster case %d.\n", nr)
+		nr++
+
+		addr, ok := parseSwitchMaster([]byte("mymaster 10.1.1.1 6379 10.1.1.2 6380"), "mymaster")
+		assert.Equal(t, true, ok, "should be equal")
+		assert.Equal(t, "10.1.1.2:6380", addr, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestParseSwitchMaster case %d.\n", nr)
+		nr++
+
+		// other master
+		_, ok := parseSwitchMaster([]byte("other 10.1.1.1 6379 10.1.1.2 6380"), "mymaster")
+		assert.Equal(t, false, ok, "should be equal")
+
+		// illegal message
+		_, ok = parseSwitchMaster([]byte("mymaster 10.1.1.1 6379"), "mymaster")
+		assert.Equal(t, false, ok, "should be equal")
+	}
+}
+
+func TestParseSentinelAddress(t *testing.T) {
+	var nr int
+	{
+		fmt.Printf("TestParseSentinelAddress case %d.\n", nr)
+		nr++
+
+		masterName, fromMaster, sentinels, err := ParseSentinelAddress("mymaster:slave@10.1.1.1:26379;10.1.1.2:26379", true)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, "mymaster", masterName, "should be equal")
+		assert.Equal(t, false, fromMaster, "should be equal")
+		assert.Equal(t, []string{"10.1.1.1:26379", "10.1.1.2:26379"}, sentinels, "should be equal")
+
+		// target always from master
+		_, fromMaster, _, err = ParseSentinelAddress("mymaster:slave@10.1.1.1:26379", false)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, true, fromMaster, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestParseSentinelAddress case %d.\n", nr)
+		nr++
+
+		_, _, _, err := ParseSentinelAddress("10.1.1.1:26379", true)
+		assert.NotEqual(t, nil, err, "should be equal")
+	}
+}
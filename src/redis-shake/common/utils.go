@@ -40,6 +40,18 @@ func OpenRedisConn(target []string, authType, passwd string, isCluster bool, tls
 
 func OpenRedisConnWithTimeout(target []string, authType, passwd string, readTimeout, writeTimeout time.Duration,
 	isCluster bool, tlsEnable bool, tlsSkipVerify bool) redigo.Conn {
+	c, err := TryOpenRedisConnWithTimeout(target, authType, passwd, readTimeout, writeTimeout, isCluster, tlsEnable,
+		tlsSkipVerify)
+	if err != nil {
+		log.PanicError(err, "open redis connection failed")
+	}
+	return c
+}
+
+// TryOpenRedisConnWithTimeout is the same as OpenRedisConnWithTimeout except that the error is returned instead
+// of panic, so the caller is able to retry, e.g., reconnect the target after failover.
+func TryOpenRedisConnWithTimeout(target []string, authType, passwd string, readTimeout, writeTimeout time.Duration,
+	isCluster bool, tlsEnable bool, tlsSkipVerify bool) (redigo.Conn, error) {
 	// return redigo.NewConn(OpenNetConn(target, authType, passwd), readTimeout, writeTimeout)
 	if isCluster {
 		// the alive time isn't the tcp keep_alive parameter
@@ -54,29 +66,23 @@ func OpenRedisConnWithTimeout(target []string, authType, passwd string, readTime
 				Password:     passwd,
 			})
 		if err != nil {
-			log.Panicf("create cluster connection error[%v]", err)
-			return nil
+			return nil, fmt.Errorf("create cluster connection error[%v]", err)
 		}
-		return NewClusterConn(cluster, RecvChanSize)
+		return NewClusterConn(cluster, RecvChanSize), nil
 	} else {
 		// tls only support single connection currently
-		return redigo.NewConn(OpenNetConn(target[0], authType, passwd, tlsEnable, tlsSkipVerify), readTimeout, writeTimeout)
+		c, err := dialNetConn(target[0], authType, passwd, tlsEnable, tlsSkipVerify, 5*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return redigo.NewConn(c, readTimeout, writeTimeout), nil
 	}
 }
 
 func OpenNetConn(target, authType, passwd string, tlsEnable bool, tlsSkipVerify bool) net.Conn {
-	d := &net.Dialer{
-		KeepAlive: time.Duration(conf.Options.KeepAlive) * time.Second,
-	}
-	var c net.Conn
-	var err error
-	if tlsEnable {
-		c, err = tls.DialWithDialer(d, "tcp", target, &tls.Config{InsecureSkipVerify: tlsSkipVerify})
-	} else {
-		c, err = d.Dial("tcp", target)
-	}
+	c, err := dialNetConn(target, authType, "", tlsEnable, tlsSkipVerify, 0)
 	if err != nil {
-		log.PanicErrorf(err, "cannot connect to '%s'", target)
+		log.PanicError(err, "open net connection failed")
 	}
 
 	// log.Infof("try to auth address[%v] with type[%v]", target, authType)
@@ -85,19 +91,45 @@ func OpenNetConn(target, authType, passwd string, tlsEnable bool, tlsSkipVerify
 	return c
 }
 
-func OpenNetConnSoft(target, authType, passwd string, tlsEnable bool) net.Conn {
+// ReopenPSyncConn reopens the replication connection to the source and sends the listening port.
+// Different from OpenNetConn, all the failures are returned instead of panic so the caller can retry.
+func ReopenPSyncConn(target, authType, passwd string, tlsEnable bool, tlsSkipVerify bool, port int) (net.Conn, error) {
+	c, err := dialNetConn(target, authType, passwd, tlsEnable, tlsSkipVerify, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if err := sendPSyncListeningPort(c, port); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// dialNetConn connects to the target and does the authentication if password is given.
+func dialNetConn(target, authType, passwd string, tlsEnable bool, tlsSkipVerify bool,
+	timeout time.Duration) (net.Conn, error) {
+	d := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: time.Duration(conf.Options.KeepAlive) * time.Second,
+	}
 	var c net.Conn
 	var err error
 	if tlsEnable {
-		c, err = tls.Dial("tcp", target, &tls.Config{InsecureSkipVerify: false})
+		c, err = tls.DialWithDialer(d, "tcp", target, &tls.Config{InsecureSkipVerify: tlsSkipVerify})
 	} else {
-		c, err = net.Dial("tcp", target)
+		c, err = d.Dial("tcp", target)
 	}
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("connect to '%s' failed[%v]", target, err)
 	}
-	AuthPassword(c, authType, passwd)
-	return c
+
+	if passwd != "" {
+		if err := authPassword(c, authType, passwd); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
 func OpenReadFile(name string) (*os.File, int64) {
@@ -129,18 +161,25 @@ func OpenReadWriteFile(name string) *os.File {
 }
 
 func SendPSyncListeningPort(c net.Conn, port int) {
+	if err := sendPSyncListeningPort(c, port); err != nil {
+		log.PanicError(errors.Trace(err), "send replconf listening-port failed")
+	}
+}
+
+func sendPSyncListeningPort(c net.Conn, port int) error {
 	_, err := c.Write(redis.MustEncodeToBytes(redis.NewCommand("replconf", "listening-port", port)))
 	if err != nil {
-		log.PanicError(errors.Trace(err), "write replconf listening-port failed")
+		return fmt.Errorf("write replconf listening-port failed[%v]", err)
 	}
 
 	ret, err := ReadRESPEnd(c)
 	if err != nil {
-		log.PanicError(errors.Trace(err), "read auth response failed")
+		return fmt.Errorf("read replconf listening-port response failed[%v]", err)
 	}
 	if strings.ToUpper(ret) != "+OK\r\n" {
-		log.Panicf("repl listening-port failed[%v]", RemoveRESPEnd(ret))
+		return fmt.Errorf("repl listening-port failed[%v]", RemoveRESPEnd(ret))
 	}
+	return nil
 }
 
 func AuthPassword(c net.Conn, authType, passwd string) {
@@ -149,18 +188,25 @@ func AuthPassword(c net.Conn, authType, passwd string) {
 		return
 	}
 
+	if err := authPassword(c, authType, passwd); err != nil {
+		log.PanicError(errors.Trace(err), "auth password failed")
+	}
+}
+
+func authPassword(c net.Conn, authType, passwd string) error {
 	_, err := c.Write(redis.MustEncodeToBytes(redis.NewCommand(authType, passwd)))
 	if err != nil {
-		log.PanicError(errors.Trace(err), "write auth command failed")
+		return fmt.Errorf("write auth command failed[%v]", err)
 	}
 
 	ret, err := ReadRESPEnd(c)
 	if err != nil {
-		log.PanicError(errors.Trace(err), "read auth response failed")
+		return fmt.Errorf("read auth response failed[%v]", err)
 	}
 	if strings.ToUpper(ret) != "+OK\r\n" {
-		log.Panicf("auth failed[%v]", RemoveRESPEnd(ret))
+		return fmt.Errorf("auth failed[%v]", RemoveRESPEnd(ret))
 	}
+	return nil
 }
 
 func OpenSyncConn(target string, authType, passwd string, tlsEnable bool, tlsSkipVerify bool) (net.Conn, <-chan int64) {
@@ -234,55 +280,67 @@ func SendPSyncFullsync(br *bufio.Reader, bw *bufio.Writer) (string, int64, <-cha
 	return runid, offset, waitRdbDump(br)
 }
 
-func SendPSyncContinue(br *bufio.Reader, bw *bufio.Writer, runid string, offset int64, isReconn bool) (string, int64, <-chan int64) {
+/*
+ * send "psync runid offset" and parse the reply. The wait channel is nil if the source answers "+CONTINUE",
+ * otherwise it's a full resync and the rdb size will be sent into the channel. The returned run-id is the
+ * replication id which may be different from the given one on "+CONTINUE <replid>" after the source failover.
+ * isReconn marks the psync is sent after the source connection was broken and reopened.
+ */
+func SendPSyncContinue(br *bufio.Reader, bw *bufio.Writer, runid string, offset int64,
+	isReconn bool) (string, int64, <-chan int64, error) {
 	if offset != -1 {
 		offset += 1
 	}
 
 	cmd := redis.NewCommand("psync", runid, offset)
 	if err := redis.Encode(bw, cmd, true); err != nil {
-		log.PanicError(err, "write psync command failed, continue")
+		return "", -1, nil, fmt.Errorf("write psync command failed[%v]", err)
 	}
 	r, err := redis.Decode(br)
 	if err != nil {
-		log.PanicError(err, "invalid psync response, continue")
+		return "", -1, nil, fmt.Errorf("invalid psync response[%v]", err)
 	}
 
 	// parse return message
 	if e, ok := r.(*redis.Error); ok {
-		log.Panicf("invalid psync response, continue, %s", e.Value)
+		return "", -1, nil, fmt.Errorf("invalid psync response, %s", e.Value)
 	}
 	x, err := redis.AsString(r, nil)
 	if err != nil {
-		log.PanicError(err, "invalid psync response, continue")
+		return "", -1, nil, fmt.Errorf("invalid psync response[%v]", err)
 	}
 	xx := strings.Split(string(x), " ")
 
 	// is full sync?
-	if len(xx) == 1 && strings.ToLower(xx[0]) == "continue" {
-		// continue
-		log.Infof("Event:IncSyncStart\tId:%s\t", conf.Options.Id)
-		return runid, offset - 1, nil
-	} else if len(xx) >= 3 && strings.ToLower(xx[0]) == "fullresync" {
+	if len(xx) <= 2 && strings.ToLower(xx[0]) == "continue" {
+		// continue, psync2 replies the new replication id if it's changed, e.g., the source failover.
+		if len(xx) == 2 && xx[1] != runid {
+			log.Infof("Event:ReplIdChanged\tId:%s\treplid from %s to %s", conf.Options.Id, runid, xx[1])
+			runid = xx[1]
+		}
 		if isReconn {
-			log.Panic("psync with fullresync after reconnect is not supported yet")
+			log.Infof("Event:IncSyncReconnect\tId:%s\toffset = %d", conf.Options.Id, offset-1)
+		} else {
+			log.Infof("Event:IncSyncStart\tId:%s\t", conf.Options.Id)
 		}
-
+		return runid, offset - 1, nil, nil
+	} else if len(xx) >= 3 && strings.ToLower(xx[0]) == "fullresync" {
 		v, err := strconv.ParseInt(xx[2], 10, 64)
 		if err != nil {
-			log.PanicError(err, "parse psync offset failed")
+			return "", -1, nil, fmt.Errorf("parse psync offset[%v] failed[%v]", xx[2], err)
 		}
 
-		log.Infof("Event:FullSyncStart\tId:%s\t", conf.Options.Id)
+		if isReconn {
+			log.Warnf("Event:FullSyncAfterReconnect\tId:%s\t", conf.Options.Id)
+		} else {
+			log.Infof("Event:FullSyncStart\tId:%s\t", conf.Options.Id)
+		}
 		runid, offset := xx[1], v
 
-		return runid, offset, waitRdbDump(br)
-	} else {
-		log.Panicf("invalid psync response = '%s', should be continue", x)
+		return runid, offset, waitRdbDump(br), nil
 	}
 
-	// unreachable
-	return "", -1, nil
+	return "", -1, nil, fmt.Errorf("invalid psync response = '%s', should be continue or fullresync", x)
 }
 
 func SendPSyncAck(bw *bufio.Writer, offset int64) error {
@@ -358,18 +416,145 @@ func set(c redigo.Conn, key []byte, value []byte) {
 }
 
 func flushAndCheckReply(c redigo.Conn, count int) {
+	if err := flushReplies(c, count); err != nil {
+		log.PanicError(err, "flush command to redis failed")
+	}
+}
+
+// flush the commands and receive the replies, the first error is returned after receiving all of them.
+func flushReplies(c redigo.Conn, count int) error {
 	// for redis-go-cluster driver, "Receive" function returns all the replies once flushed.
 	// However, this action is different with redigo driver that "Receive" only returns 1
 	// reply each time.
-	c.Flush()
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	var first error
 	for j := 0; j < count; j++ {
-		_, err := c.Receive()
-		if err != nil {
-			log.PanicError(err, "flush command to redis failed")
+		if _, err := c.Receive(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// the hash field expiration is supported since redis 7.4
+func supportHashFieldTTL() bool {
+	ret := CompareVersion(conf.Options.TargetVersion, "7.4", 2)
+	return ret == 0 || ret == 2
+}
+
+func isHashWithTTL(t byte) bool {
+	return t == rdb.RdbTypeHashMetadataPreGa || t == rdb.RdbTypeHashListpackExPreGa ||
+		t == rdb.RdbTypeHashMetadata || t == rdb.RdbTypeHashListpackEx
+}
+
+/*
+ * restore the hash with the field expiration by "hset" and "hpexpireat". The expiration is dropped or panic
+ * by hash_field_ttl if the target is older than 7.4.
+ */
+func restoreHashWithTTL(c redigo.Conn, e *rdb.BinEntry) {
+	o, err := rdb.DecodeDump(e.Value)
+	if err != nil {
+		log.PanicError(err, "decode hash failed")
+	}
+	hash := o.(rdb.Hash)
+
+	withTTL := supportHashFieldTTL()
+	if !withTTL && conf.Options.HashFieldTTL == "panic" {
+		for _, ele := range hash {
+			if ele.ExpireAt != 0 {
+				log.Panicf("the target version[%v] doesn't support the field expiration of hash key[%v]",
+					conf.Options.TargetVersion, string(e.Key))
+			}
+		}
+	}
+
+	log.Info("restore big hash key ", string(e.Key), " field count ", len(hash))
+	count, dropped := 0, 0
+	for _, ele := range hash {
+		count++
+		if err := c.Send("HSET", e.Key, ele.Field, ele.Value); err != nil {
+			log.PanicError(err, "send hset failed")
+		}
+		if ele.ExpireAt != 0 {
+			if withTTL {
+				// convert into the clock of the target like the key
+				expireAt := int64(ele.ExpireAt) - int64(conf.Options.ShiftTime/time.Millisecond)
+				count++
+				if err := c.Send("HPEXPIREAT", e.Key, expireAt, "FIELDS", 1, ele.Field); err != nil {
+					log.PanicError(err, "send hpexpireat failed")
+				}
+			} else {
+				dropped++
+			}
 		}
+		if count >= 100 {
+			flushAndCheckReply(c, count)
+			count = 0
+		}
+	}
+	flushAndCheckReply(c, count)
+	if dropped != 0 {
+		log.Warnf("drop the expiration of %v fields of hash key[%v]", dropped, string(e.Key))
 	}
 }
 
+/*
+ * check the key of the module type by unknown_module if the module isn't registered.
+ * @return:
+ *     bool: true if the key should be restored
+ */
+func checkModuleEntry(e *rdb.BinEntry, source string) bool {
+	name, err := rdb.ModuleTypeName(e.Value)
+	if err != nil {
+		log.PanicErrorf(err, "parse module type of key[%v] failed", string(e.Key))
+	}
+	if rdb.IsModuleTypeRegistered(name) {
+		return true
+	}
+
+	switch conf.Options.UnknownModule {
+	case "restore":
+		return true
+	case "panic":
+		log.Panicf("unknown module type[%v] of key[%v]", name, string(e.Key))
+	}
+	log.Warnf("Event:SkipUnknownModule\tId:%s\tSource:%s\tDb:%d\tKey:%s\tModule:%s", conf.Options.Id, source,
+		e.DB, string(e.Key), name)
+	return false
+}
+
+// rebuild the key of the module type by the commands of the registered module.
+func restoreModuleEntry(c redigo.Conn, e *rdb.BinEntry) {
+	o, err := rdb.DecodeDump(e.Value)
+	if err != nil {
+		log.PanicError(err, "decode module failed")
+	}
+	m := o.(*rdb.Module)
+	if m.Value == nil {
+		log.Panicf("unknown module type[%v] of key[%v] can't be restored by commands", m.Name, string(e.Key))
+	}
+	cmds := m.Value.Rewrite(e.Key)
+	if cmds == nil {
+		log.Panicf("module type[%v] of key[%v] can't be restored by commands", m.Name, string(e.Key))
+	}
+
+	log.Info("restore module key ", string(e.Key), " module ", m.Name, " command count ", len(cmds))
+	count := 0
+	for _, cmd := range cmds {
+		count++
+		if err := c.Send(cmd[0].(string), cmd[1:]...); err != nil {
+			log.PanicError(err, "send module command failed")
+		}
+		if count == 100 {
+			flushAndCheckReply(c, count)
+			count = 0
+		}
+	}
+	flushAndCheckReply(c, count)
+}
+
 func restoreQuicklistEntry(c redigo.Conn, e *rdb.BinEntry) {
 
 	r := rdb.NewRdbReader(bytes.NewReader(e.Value))
@@ -415,6 +600,7 @@ func restoreQuicklistEntry(c redigo.Conn, e *rdb.BinEntry) {
 	}
 }
 
+// restore the value by the commands of its type, the first error reply of the target is returned.
 func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 	//read type
 	var err error
@@ -452,7 +638,9 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 			count++
 			err = c.Send("HSET", e.Key, field, value)
 			if (count == 100) || (i == (length - 1)) {
-				flushAndCheckReply(c, count)
+				if err := flushReplies(c, count); err != nil {
+					return err
+				}
 				count = 0
 			}
 			//hset(c, e.Key, field, value)
@@ -485,7 +673,9 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 			count++
 			err = c.Send("ZADD", e.Key, scoreBytes, member)
 			if (count == 100) || (i == (cardinality - 1)) {
-				flushAndCheckReply(c, count)
+				if err := flushReplies(c, count); err != nil {
+					return err
+				}
 				count = 0
 			}
 			//zadd(c, e.Key, scoreBytes, member)
@@ -530,7 +720,9 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 			count++
 			err = c.Send("SADD", e.Key, []byte(intString))
 			if (count == 100) || (i == (cardinality - 1)) {
-				flushAndCheckReply(c, count)
+				if err := flushReplies(c, count); err != nil {
+					return err
+				}
 				count = 0
 			}
 			//sadd(c, e.Key, []byte(intString))
@@ -558,7 +750,9 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 				break
 			}
 			if (count == 100) || (i == (length - 1)) {
-				flushAndCheckReply(c, count)
+				if err := flushReplies(c, count); err != nil {
+					return err
+				}
 				count = 0
 			}
 		}
@@ -598,7 +792,9 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 			}
 
 			if (count == 100) || (i == (int(length) - 1)) {
-				flushAndCheckReply(c, count)
+				if err := flushReplies(c, count); err != nil {
+					return err
+				}
 				count = 0
 			}
 			//hset(c, e.Key, field, value)
@@ -626,7 +822,9 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 					break
 				}
 				if (count == 100) || (i == (int(n) - 1)) {
-					flushAndCheckReply(c, count)
+					if err := flushReplies(c, count); err != nil {
+						return err
+					}
 					count = 0
 				}
 			}
@@ -647,7 +845,9 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 					break
 				}
 				if (count == 100) || (i == (int(n) - 1)) {
-					flushAndCheckReply(c, count)
+					if err := flushReplies(c, count); err != nil {
+						return err
+					}
 					count = 0
 				}
 				//sadd(c, e.Key, member)
@@ -680,7 +880,9 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 				}
 
 				if (count == 100) || (i == (int(n) - 1)) {
-					flushAndCheckReply(c, count)
+					if err := flushReplies(c, count); err != nil {
+						return err
+					}
 					count = 0
 				}
 				//zadd(c, e.Key, Float64ToByte(score), member)
@@ -721,7 +923,9 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 			}
 
 			if (count == 100) || (i == (int(n) - 1)) {
-				flushAndCheckReply(c, count)
+				if err := flushReplies(c, count); err != nil {
+					return err
+				}
 				count = 0
 			}
 		}
@@ -754,17 +958,86 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 							return err
 						}
 						if count == 100 {
-							flushAndCheckReply(c, count)
+							if err := flushReplies(c, count); err != nil {
+								return err
+							}
 							count = 0
 						}
 					}
-					flushAndCheckReply(c, count)
+					if err := flushReplies(c, count); err != nil {
+						return err
+					}
+					count = 0
+				}
+			}
+		}
+	case rdb.RdbTypeQuicklist2:
+		n, err := r.ReadLength()
+		if err != nil {
+			log.PanicError(err, "read rdb ")
+		}
+		log.Info("restore big list key ", string(e.Key), " node count ", int(n))
+		for i := 0; i < int(n); i++ {
+			container, err := r.ReadLength()
+			if err != nil {
+				log.PanicError(err, "read rdb ")
+			}
+			value, err := r.ReadString()
+			if err != nil {
+				log.PanicError(err, "read rdb ")
+			}
+			entries := [][]byte{value}
+			if container != rdb.QuicklistNodePlain {
+				lp := listpack.NewListpack(value)
+				entries = make([][]byte, 0, lp.NumElements())
+				for j := 0; j < int(lp.NumElements()); j++ {
+					entries = append(entries, []byte(lp.Next()))
+				}
+			}
+			for _, entry := range entries {
+				count++
+				if err = c.Send("RPUSH", e.Key, entry); err != nil {
+					return err
+				}
+				if count == 100 {
+					if err := flushReplies(c, count); err != nil {
+						return err
+					}
 					count = 0
 				}
 			}
 		}
-	case rdb.RDBTypeStreamListPacks:
+		if err := flushReplies(c, count); err != nil {
+			return err
+		}
+		count = 0
+	case rdb.RdbTypeSetListpack:
+		value, err := r.ReadString()
+		if err != nil {
+			log.PanicError(err, "read rdb")
+		}
+		lp := listpack.NewListpack(value)
+		length := int(lp.NumElements())
+		log.Info("restore big set key ", string(e.Key), " field count ", length)
+		for i := 0; i < length; i++ {
+			count++
+			if err = c.Send("SADD", e.Key, lp.Next()); err != nil {
+				break
+			}
+			if (count == 100) || (i == length-1) {
+				if err := flushReplies(c, count); err != nil {
+					return err
+				}
+				count = 0
+			}
+		}
+	case rdb.RDBTypeStreamListPacks, rdb.RdbTypeStreamListPacks2, rdb.RdbTypeStreamListPacks3:
 		bigkey.RestoreBigStreamEntry(c, e)
+	case rdb.RdbTypeHashMetadataPreGa, rdb.RdbTypeHashListpackExPreGa, rdb.RdbTypeHashMetadata,
+		rdb.RdbTypeHashListpackEx:
+		restoreHashWithTTL(c, e)
+	case rdb.RdbTypeModule, rdb.RdbTypeModule2:
+		restoreModuleEntry(c, e)
 
 	case rdb.RdbTypeHashListpack:
 		value, err := r.ReadString()
@@ -782,7 +1055,9 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 				break
 			}
 			if (count == 100) || (i == int(length/2)-1) {
-				flushAndCheckReply(c, count)
+				if err := flushReplies(c, count); err != nil {
+					return err
+				}
 				count = 0
 			}
 		}
@@ -807,7 +1082,9 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 				break
 			}
 			if (count == 100) || (i == int(length/2)-1) {
-				flushAndCheckReply(c, count)
+				if err := flushReplies(c, count); err != nil {
+					return err
+				}
 				count = 0
 			}
 		}
@@ -836,7 +1113,9 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 			}
 			t, err := r.ReadByte()
 			if (t != rdb.RdbTypeFunction2) || err != nil {
-				flushAndCheckReply(c, count)
+				if err := flushReplies(c, count); err != nil {
+					return err
+				}
 				log.Info("complete restore big function, count = ", count)
 				count = 0
 				break
@@ -849,7 +1128,25 @@ func restoreBigRdbEntry(c redigo.Conn, e *rdb.BinEntry) error {
 	return err
 }
 
-func RestoreRdbEntry(c redigo.Conn, e *rdb.BinEntry) {
+/*
+ * restore the rdb entry into the selected db of the target. The source and db are only used in the
+ * dead-letter file if the "restore" command fails.
+ */
+func RestoreRdbEntry(c redigo.Conn, e *rdb.BinEntry, source string, db uint32) {
+	restoreRdbEntry(c, e, source, db, conf.Options.KeyExists)
+}
+
+// ReplayRdbEntry restores the entry which may be restored before, the existing key is rewritten.
+func ReplayRdbEntry(c redigo.Conn, e *rdb.BinEntry, source string, db uint32) {
+	restoreRdbEntry(c, e, source, db, "rewrite")
+}
+
+func restoreRdbEntry(c redigo.Conn, e *rdb.BinEntry, source string, db uint32, keyExists string) {
+	// the entry from the parallel loader is checked and encoded here
+	if err := e.EncodeValue(); err != nil {
+		log.PanicError(err, "parse rdb entry error, key:", string(e.Key))
+	}
+
 	/*
 	 * for ucloud, special judge.
 	 * 046110.key -> key
@@ -872,13 +1169,16 @@ func RestoreRdbEntry(c redigo.Conn, e *rdb.BinEntry) {
 			ttlms = e.ExpireAt - now
 		}
 	}
+	if (e.Type == rdb.RdbTypeModule || e.Type == rdb.RdbTypeModule2) && !checkModuleEntry(e, source) {
+		return
+	}
 	if e.Type == rdb.RdbTypeQuicklist {
 		exist, err := Bool(c.Do("exists", e.Key))
 		if err != nil {
 			log.Panicf(err.Error())
 		}
 		if exist {
-			switch conf.Options.KeyExists {
+			switch keyExists {
 			case "rewrite":
 				if !conf.Options.Metric {
 					log.Infof("warning, rewrite key: %v", string(e.Key))
@@ -915,11 +1215,12 @@ func RestoreRdbEntry(c redigo.Conn, e *rdb.BinEntry) {
 	}
 
 	// TODO, need to judge big key
-	if e.Type != rdb.RDBTypeStreamListPacks &&
-		(uint64(len(e.Value)) > conf.Options.BigKeyThreshold || e.RealMemberCount != 0) {
+	if e.Type != rdb.RDBTypeStreamListPacks && e.Type != rdb.RdbTypeStreamListPacks2 &&
+		e.Type != rdb.RdbTypeStreamListPacks3 && (uint64(len(e.Value)) > conf.Options.BigKeyThreshold ||
+		e.RealMemberCount != 0 || isHashWithTTL(e.Type) && !supportHashFieldTTL()) {
 		log.Debugf("restore big key[%s] with length[%v] and member count[%v]", e.Key, len(e.Value), e.RealMemberCount)
 		//use command
-		if conf.Options.KeyExists == "rewrite" && e.NeedReadLen == 1 {
+		if keyExists == "rewrite" && e.NeedReadLen == 1 {
 			if !conf.Options.Metric {
 				log.Infof("warning, rewrite big key: %s", string(e.Key))
 			}
@@ -929,8 +1230,9 @@ func RestoreRdbEntry(c redigo.Conn, e *rdb.BinEntry) {
 			}
 		}
 
-		if err := restoreBigRdbEntry(c, e); err != nil {
-			log.Panic(err)
+		if err := restoreBigRdbEntry(c, e); err != nil &&
+			!IgnoreErrorReply(source, int(db), "restore", []interface{}{e.Key, ttlms, e.Value}, err) {
+			log.PanicError(err, "restore big key error key:", string(e.Key), " err:", err.Error())
 		}
 
 		if e.ExpireAt != 0 {
@@ -979,7 +1281,7 @@ RESTORE:
 		  but in 4.0 kernel is "BUSYKEY Target key name already exists"*/
 		if strings.Contains(err.Error(), "Target key name is busy") ||
 			strings.Contains(err.Error(), "BUSYKEY Target key name already exists") {
-			switch conf.Options.KeyExists {
+			switch keyExists {
 			case "rewrite":
 				if !conf.Options.Metric {
 					log.Infof("warning, rewrite key: %v", string(e.Key))
@@ -1003,10 +1305,10 @@ RESTORE:
 		} else if strings.Contains(err.Error(), "Bad data format") {
 			// from big version to small version may has this error. we need to split the data struct
 			log.Warnf("return error[%v], ignore it and try to split the value", err)
-			if err := restoreBigRdbEntry(c, e); err != nil {
-				log.Panic(err)
+			if err := restoreBigRdbEntry(c, e); err != nil && !IgnoreErrorReply(source, int(db), "restore", params, err) {
+				log.PanicError(err, "restore big key error key:", string(e.Key), " err:", err.Error())
 			}
-		} else {
+		} else if !IgnoreErrorReply(source, int(db), "restore", params, err) {
 			log.PanicError(err, "restore command error key:", string(e.Key), " err:", err.Error())
 		}
 	} else if s != "OK" {
@@ -1066,6 +1368,60 @@ func NewRDBLoader(reader *bufio.Reader, rbytes *atomic2.Int64, size int) chan *r
 	return pipe
 }
 
+/*
+ * NewParallelRDBLoader is like NewRDBLoader, but the entries are partitioned into n pipes by the slot of the key,
+ * so the entries of the same key keep the order in the pipe. The loader only frames the entries, the value is
+ * decompressed, checked and encoded into the dump payload by the consumer of each pipe in parallel, see
+ * rdb.BinEntry.EncodeValue.
+ * If the reader fails with abort, e.g., the rdb is transferred again, the loader stops without panic. The
+ * error of the loader, nil or abort, is sent into the returned channel after all the pipes are closed.
+ */
+func NewParallelRDBLoader(reader *bufio.Reader, rbytes *atomic2.Int64, size, n int,
+	abort error) ([]chan *rdb.BinEntry, <-chan error) {
+	pipes := make([]chan *rdb.BinEntry, n)
+	for i := range pipes {
+		pipes[i] = make(chan *rdb.BinEntry, size)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		var aborted error
+		defer func() {
+			for _, pipe := range pipes {
+				close(pipe)
+			}
+			errc <- aborted
+		}()
+		l := rdb.NewLoader(stats.NewCountReader(reader, rbytes))
+		if err := l.Header(); err != nil {
+			if abort != nil && errors.Cause(err) == abort {
+				aborted = abort
+				return
+			}
+			log.PanicError(err, "parse rdb header error")
+		}
+		for {
+			if entry, err := l.NextRawEntry(); err != nil {
+				if abort != nil && errors.Cause(err) == abort {
+					aborted = abort
+					return
+				}
+				log.PanicError(err, "parse rdb entry error, if the err is :EOF, please check that if the src db log has client output buffer oom, if so set output buffer larger.")
+			} else if entry != nil {
+				// the function and lua script have no key
+				pipes[int(KeyToSlot(string(entry.Key)))%n] <- entry
+			} else {
+				if rdb.FromVersion > 2 {
+					if err := l.Footer(); err != nil {
+						log.PanicError(err, "parse rdb checksum error")
+					}
+				}
+				return
+			}
+		}
+	}()
+	return pipes, errc
+}
+
 func GetRedisVersion(target, authType, auth string, tlsEnable bool, tlsSkipVerify bool) (string, error) {
 	c := OpenRedisConn([]string{target}, authType, auth, false, tlsEnable, tlsSkipVerify)
 	defer c.Close()
@@ -1110,6 +1466,8 @@ func CheckHandleNetError(err error) bool {
 		return true
 	} else if _, ok := err.(net.Error); ok {
 		return true
+	} else if _, ok := err.(*BatchError); ok {
+		return true
 	}
 	return false
 }
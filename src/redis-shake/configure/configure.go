@@ -34,9 +34,13 @@ type Configuration struct {
 	TargetTLSSkipVerify    bool     `config:"target.tls_skip_verify"`
 	TargetRdbOutput        string   `config:"target.rdb.output"`
 	TargetVersion          string   `config:"target.version"`
+	TargetErrPolicyString  string   `config:"target.error_policy"`
+	TargetDeadLetterFile   string   `config:"target.dead_letter_file"`
 	FakeTime               string   `config:"fake_time"`
 	KeyExists              string   `config:"key_exists"`
 	FunctionExists         string   `config:"function_exists"`
+	HashFieldTTL           string   `config:"hash_field_ttl"`
+	UnknownModule          string   `config:"unknown_module"`
 	FilterDBWhitelist      []string `config:"filter.db.whitelist"`
 	FilterDBBlacklist      []string `config:"filter.db.blacklist"`
 	FilterKeyWhitelist     []string `config:"filter.key.whitelist"`
@@ -52,6 +56,12 @@ type Configuration struct {
 	SenderCount            uint     `config:"sender.count"`
 	SenderDelayChannelSize uint     `config:"sender.delay_channel_size"`
 	SenderTickerMs         int      `config:"sender.ticker_ms"`
+	SenderSlotParallel     bool     `config:"sender.slot_parallel"`
+	SenderOverflowSize     uint64   `config:"sender.overflow_size"`
+	SenderTransaction      bool     `config:"sender.transaction"`
+	SenderTxnCrossSlot     string   `config:"sender.transaction_cross_slot"`
+	SpoolSize              uint64   `config:"spool.size"`
+	SpoolDir               string   `config:"spool.dir"`
 	KeepAlive              uint     `config:"keep_alive"`
 	PidPath                string   `config:"pid_path"`
 	ScanKeyNumber          uint32   `config:"scan.key_number"`
@@ -59,6 +69,16 @@ type Configuration struct {
 	ScanKeyFile            string   `config:"scan.key_file"`
 	Qps                    int      `config:"qps"`
 	ResumeFromBreakPoint   bool     `config:"resume_from_break_point"`
+	CheckpointStorage      string   `config:"checkpoint.storage"`
+	CheckpointFile         string   `config:"checkpoint.file"`
+	CheckpointAddress      string   `config:"checkpoint.address"`
+	CheckpointAuthType     string   `config:"checkpoint.auth_type"`
+	CheckpointPasswordRaw  string   `config:"checkpoint.password_raw"`
+	CheckpointConsistency  string   `config:"checkpoint.consistency"`
+	StopOffset             int64    `config:"stop.offset"`
+	StopTimeString         string   `config:"stop.time"`
+	StopZeroLagSeconds     uint     `config:"stop.zero_lag_seconds"`
+	ShutdownTimeout        uint     `config:"shutdown_timeout"`
 
 	/*---------------------------------------------------------*/
 	// inner variables
@@ -88,6 +108,9 @@ type Configuration struct {
 	Version           string        // version
 	Type              string        // input mode -type=xxx
 	TargetDBMap       map[int]int   // target db map
+
+	TargetErrPolicy map[string]string // target error class -> policy
+	StopTime        time.Time         // parsed from stop.time
 }
 
 var Options Configuration
@@ -97,16 +120,36 @@ const (
 	RedisTypeSentinel   = "sentinel"
 	RedisTypeCluster    = "cluster"
 	RedisTypeProxy      = "proxy"
+	SourceTypeAof       = "aof" // the aof files given in source.rdb.input, only used in restore
+	TargetTypeRdb       = "rdb" // the rdb files named by target.rdb.output, only used in rump
 
 	StandAloneRoleMaster = "master"
 	StandAloneRoleSlave  = "slave"
 	StandAloneRoleAll    = "all"
 
-	TypeDecode  = "decode"
-	TypeRestore = "restore"
-	TypeDump    = "dump"
-	TypeSync    = "sync"
-	TypeRump    = "rump"
+	TypeDecode     = "decode"
+	TypeRestore    = "restore"
+	TypeDump       = "dump"
+	TypeSync       = "sync"
+	TypeRump       = "rump"
+	TypeReplay     = "replay"
+	TypeCheckpoint = "checkpoint"
+
+	ErrorPolicyPanic      = "panic"
+	ErrorPolicyRetry      = "retry"
+	ErrorPolicySkip       = "skip"
+	ErrorPolicyDeadLetter = "dead_letter"
+	ErrorClassDefault     = "default"
+
+	TxnCrossSlotSplit = "split"
+
+	CheckpointStorageTarget = "target"
+	CheckpointStorageFile   = "file"
+	CheckpointStorageRedis  = "redis"
+
+	CheckpointConsistencyTransaction = "transaction"
+	CheckpointConsistencyAck         = "ack"
+	CheckpointConsistencyReplay      = "replay"
 )
 
 func GetSafeOptions() Configuration {
@@ -115,5 +158,6 @@ func GetSafeOptions() Configuration {
 	polish.SourcePasswordEncoding = "***"
 	polish.TargetPasswordRaw = "***"
 	polish.TargetPasswordEncoding = "***"
+	polish.CheckpointPasswordRaw = "***"
 	return polish
 }
@@ -2,12 +2,14 @@ package dbSync
 
 import (
 	"bufio"
+	"github.com/alibaba/RedisShake/pkg/libs/atomic2"
 	"github.com/alibaba/RedisShake/pkg/libs/log"
 	"github.com/alibaba/RedisShake/redis-shake/base"
 	"github.com/alibaba/RedisShake/redis-shake/common"
 	"github.com/alibaba/RedisShake/redis-shake/heartbeat"
 	"github.com/alibaba/RedisShake/redis-shake/metric"
 	"io"
+	"sync"
 
 	"github.com/alibaba/RedisShake/redis-shake/checkpoint"
 	"github.com/alibaba/RedisShake/redis-shake/configure"
@@ -28,6 +30,12 @@ func NewDbSyncer(id int, source, sourcePassword string, target []string, targetP
 		enableResumeFromBreakPoint: conf.Options.ResumeFromBreakPoint,
 		checkpointName:             utils.CheckpointKey, // default, may be modified
 		WaitFull:                   make(chan struct{}),
+		resyncChan:                 make(chan *resyncNode, 1),
+		secondOffset:               -1,
+		sourceSwitched:             make(chan struct{}, 1),
+		targetSwitched:             make(chan struct{}, 1),
+		WaitStop:                   make(chan struct{}),
+		stopRequest:                make(chan struct{}, 1),
 	}
 
 	// add metric
@@ -39,14 +47,20 @@ func NewDbSyncer(id int, source, sourcePassword string, target []string, targetP
 type DbSyncer struct {
 	id int // current id in all syncer
 
-	source            string   // source address
+	source            string   // source address, may change after the source failover
 	sourcePassword    string   // source password
-	target            []string // target address
+	target            []string // target address, may change after the target failover
 	targetPassword    string   // target password
-	runId             string   // source runId
-	slotLeftBoundary  int      // mark the left slot boundary if enable resuming from break point and is cluster
-	slotRightBoundary int      // mark the right slot boundary if enable resuming from break point and is cluster
-	httpProfilePort   int      // http profile port
+	runId             string   // source runId, it's the replication id since psync2
+	replId2           string   // previous replication id of the source before the failover
+	secondOffset      int64    // offset of the source failover, replId2 is valid until this offset
+	sourceLock        sync.RWMutex
+	targetLock        sync.RWMutex
+	slotLeftBoundary  int // mark the left slot boundary if enable resuming from break point and is cluster
+	slotRightBoundary int // mark the right slot boundary if enable resuming from break point and is cluster
+	httpProfilePort   int // http profile port
+
+	local bool // the commands are read from the local file, e.g., the aof, instead of the source redis
 
 	// stat info
 	stat Status
@@ -54,25 +68,38 @@ type DbSyncer struct {
 	startDbId                  int    // use in break resume from break-point
 	enableResumeFromBreakPoint bool   // enable?
 	checkpointName             string // checkpoint name, if is shard, this name has suffix
+	checkpointStore            checkpoint.CheckpointStore
 
-	/*
-	 * this channel is used to calculate delay between redis-shake and target redis.
-	 * Once oplog sent, the corresponding delayNode push back into this queue. Next time
-	 * receive reply from target redis, the front node popped and then delay calculated.
-	 */
-	delayChannel chan *delayNode
+	// the time when the source master reaches the offset, used to calculate the lag in seconds
+	offsetTracker offsetTracker
 
-	fullSyncOffset int64          // full sync offset value
-	sendBuf        chan cmdDetail // sending queue
-	WaitFull       chan struct{}  // wait full sync done
+	fullSyncOffset   int64            // full sync offset value
+	sendBuf          chan cmdDetail   // sending queue
+	spool            *cmdSpool        // spool the commands once sendBuf is full, nil if disabled
+	writers          []*cmdWriter     // send the commands to the target
+	slotWriter       []int            // slot -> writer id if the commands are partitioned by slot
+	dispatchedOffset atomic2.Int64    // source offset of the last command dispatched to the writers
+	WaitFull         chan struct{}    // wait full sync done
+	resyncChan       chan *resyncNode // full resync after reconnecting the source
+	sourceSwitched   chan struct{}    // notify the source address switched by the sentinel
+	targetSwitched   chan struct{}    // notify the target address switched by the sentinel
+	WaitStop         chan struct{}    // closed once the sync stops at the stop point
+	stopRequest      chan struct{}    // request the sender to stop, e.g., the lag keeps zero
 }
 
 func (ds *DbSyncer) GetExtraInfo() map[string]interface{} {
+	source, runId := ds.sourceInfo()
+	ds.sourceLock.RLock()
+	replId2, secondOffset := ds.replId2, ds.secondOffset
+	ds.sourceLock.RUnlock()
 	return map[string]interface{}{
-		"SourceAddress":        ds.source,
-		"TargetAddress":        ds.target,
+		"SourceAddress":        source,
+		"SourceReplId":         runId,
+		"SourceReplId2":        replId2,
+		"SourceSecondOffset":   secondOffset,
+		"TargetAddress":        ds.targetInfo(),
 		"SenderBufCount":       len(ds.sendBuf),
-		"ProcessingCmdCount":   len(ds.delayChannel),
+		"ProcessingCmdCount":   ds.stat.pendingCmds.Get(),
 		"TargetDBOffset":       ds.stat.targetOffset.Get(),
 		"SourceMasterDBOffset": ds.stat.sourceMasterOffset.Get(),
 		"SourceDBOffset":       ds.stat.sourceOffset.Get(),
@@ -87,24 +114,60 @@ func (ds *DbSyncer) Sync() {
 
 	var err error
 	runId, offset, dbid := "?", int64(-1), 0
+	// assign the checkpoint name with suffix if is cluster
+	if ds.enableResumeFromBreakPoint && ds.slotLeftBoundary != -1 {
+		ds.checkpointName = utils.ChoseSlotInRange(utils.CheckpointKey, ds.slotLeftBoundary, ds.slotRightBoundary)
+	}
+	ds.initWriters()
+
 	if ds.enableResumeFromBreakPoint {
-		// assign the checkpoint name with suffix if is cluster
-		if ds.slotLeftBoundary != -1 {
-			ds.checkpointName = utils.ChoseSlotInRange(utils.CheckpointKey, ds.slotLeftBoundary, ds.slotRightBoundary)
-		}
+		ds.checkpointStore = checkpoint.NewStore(ds.target, conf.Options.TargetAuthType, ds.targetPassword,
+			conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify)
+	}
 
+	if ds.enableResumeFromBreakPoint && conf.Options.SenderSlotParallel {
+		// each writer has its own checkpoint
+		log.Infof("DbSyncer[%d] enable resume from break point, try to load checkpoint of the writers", ds.id)
+		names := make([]string, len(ds.writers))
+		for i, w := range ds.writers {
+			names[i] = w.checkpointName
+		}
+		var offsets []int64
+		runId, offsets, err = checkpoint.LoadSlotCheckpoint(ds.id, ds.checkpointStore, ds.source, names)
+		if err != nil {
+			log.Panicf("DbSyncer[%d] load checkpoint from %v failed[%v]", ds.id, ds.target, err)
+			return
+		}
+		for i, w := range ds.writers {
+			w.skipOffset = offsets[i]
+			if i == 0 || offsets[i] < offset {
+				offset = offsets[i]
+			}
+		}
+		log.Infof("DbSyncer[%d] checkpoint info: runId[%v], offset[%v] writers offset%v", ds.id, runId, offset,
+			offsets)
+	} else if ds.enableResumeFromBreakPoint {
 		// checkpoint reload if has
 		log.Infof("DbSyncer[%d] enable resume from break point, try to load checkpoint", ds.id)
-		runId, offset, dbid, err = checkpoint.LoadCheckpoint(ds.id, ds.source, ds.target, conf.Options.TargetAuthType,
-			ds.targetPassword, ds.checkpointName, conf.Options.TargetType == conf.RedisTypeCluster, conf.Options.SourceTLSEnable,
-			conf.Options.SourceTLSSkipVerify)
+		cp, err := ds.checkpointStore.Load(ds.id, ds.source, ds.checkpointName)
 		if err != nil {
-			log.Panicf("DbSyncer[%d] load checkpoint from %v failed[%v]", ds.id, ds.target, err)
+			log.Panicf("DbSyncer[%d] load checkpoint from %v failed[%v]", ds.id, conf.Options.CheckpointStorage, err)
 			return
 		}
+		runId, offset, dbid = cp.RunId, cp.Offset, cp.Db
 		log.Infof("DbSyncer[%d] checkpoint info: runId[%v], offset[%v] dbid[%v]", ds.id, runId, offset, dbid)
 	}
 
+	// follow the master switch of the sentinel
+	if conf.Options.SourceType == conf.RedisTypeSentinel && conf.Options.Psync {
+		if _, fromMaster, _, err := utils.ParseSentinelAddress(conf.Options.SourceAddress, true); err == nil && fromMaster {
+			go utils.WatchSentinelMaster(conf.Options.SourceAddress, ds.switchSource)
+		}
+	}
+	if conf.Options.TargetType == conf.RedisTypeSentinel {
+		go utils.WatchSentinelMaster(conf.Options.TargetAddress, ds.switchTarget)
+	}
+
 	base.Status = "waitfull"
 	var input io.ReadCloser
 	var nsize int64
@@ -112,7 +175,7 @@ func (ds *DbSyncer) Sync() {
 	if conf.Options.Psync {
 		input, nsize, isFullSync, runId = ds.sendPSyncCmd(ds.source, conf.Options.SourceAuthType, ds.sourcePassword,
 			conf.Options.SourceTLSEnable, conf.Options.SourceTLSSkipVerify, runId, offset)
-		ds.runId = runId
+		ds.setRunId(runId, -1)
 	} else {
 		// sync
 		input, nsize = ds.sendSyncCmd(ds.source, conf.Options.SourceAuthType, ds.sourcePassword,
@@ -136,8 +199,15 @@ func (ds *DbSyncer) Sync() {
 		// sync rdb
 		log.Infof("DbSyncer[%d] rdb file size = %d", ds.id, nsize)
 		base.Status = "full"
-		ds.syncRDBFile(reader, ds.target, conf.Options.TargetAuthType, ds.targetPassword, nsize, conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify)
+		if err := ds.syncRDBFile(reader, ds.targetInfo(), conf.Options.TargetAuthType, ds.targetPassword, nsize, conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify); err != nil {
+			// the source drops before the whole rdb is transferred, the following data is read from the new node
+			reader = ds.loadResyncRdb(<-ds.resyncChan).reader
+		}
 		ds.startDbId = 0
+		// nothing is skipped after full sync
+		for _, w := range ds.writers {
+			w.skipOffset = -1
+		}
 	} else {
 		log.Infof("DbSyncer[%d] run incr-sync directly with db_id[%v]", ds.id, dbid)
 		ds.startDbId = dbid
@@ -148,5 +218,103 @@ func (ds *DbSyncer) Sync() {
 	// sync increment
 	base.Status = "incr"
 	close(ds.WaitFull)
-	ds.syncCommand(reader, ds.target, conf.Options.TargetAuthType, ds.targetPassword, conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify, dbid)
+	ds.syncCommand(reader, ds.targetInfo(), conf.Options.TargetAuthType, ds.targetPassword, conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify, dbid)
+}
+
+func (ds *DbSyncer) initWriters() {
+	if conf.Options.SenderSlotParallel {
+		var err error
+		if ds.writers, ds.slotWriter, err = newSlotWriters(ds.target, conf.Options.TargetAuthType, ds.targetPassword,
+			conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify); err != nil {
+			log.Panicf("DbSyncer[%d] create writers of the target nodes failed[%v]", ds.id, err)
+		}
+		log.Infof("DbSyncer[%d] partition the commands by slot into %d writers", ds.id, len(ds.writers))
+	} else {
+		ds.writers = []*cmdWriter{newCmdWriter(0, "", ds.checkpointName)}
+	}
+}
+
+/*
+ * ReplayCommands sends the commands read from the local file, e.g., the aof, to the target through the same
+ * filter and writers as the incremental sync. It returns once all the commands are applied, the offset is
+ * the position in the file and resuming from the break point isn't supported.
+ */
+func (ds *DbSyncer) ReplayCommands(reader *bufio.Reader) {
+	log.Infof("DbSyncer[%d] starts replaying commands from %v to %v", ds.id, ds.source, ds.target)
+	ds.local = true
+	ds.enableResumeFromBreakPoint = false
+	ds.initWriters()
+	close(ds.WaitFull)
+	ds.syncCommand(reader, ds.targetInfo(), conf.Options.TargetAuthType, ds.targetPassword,
+		conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify, 0)
+}
+
+// whether the checkpoint is written into the target with the commands in the same transaction.
+func (ds *DbSyncer) checkpointInTarget() bool {
+	return ds.enableResumeFromBreakPoint && ds.checkpointStore.Transactional()
+}
+
+// return the current source address and run-id, both of them may change after the source failover.
+func (ds *DbSyncer) sourceInfo() (string, string) {
+	ds.sourceLock.RLock()
+	defer ds.sourceLock.RUnlock()
+	return ds.source, ds.runId
+}
+
+func (ds *DbSyncer) setSourceAddress(source string) {
+	ds.sourceLock.Lock()
+	defer ds.sourceLock.Unlock()
+	ds.source = source
+}
+
+/*
+ * set the run-id of the source. If the replication id changed with psync continue, the previous one is kept
+ * as replId2 with the offset where it changed, just like the psync2 of redis. secondOffset == -1 means the
+ * replication isn't continuous, e.g., full sync.
+ */
+func (ds *DbSyncer) setRunId(runId string, secondOffset int64) {
+	ds.sourceLock.Lock()
+	defer ds.sourceLock.Unlock()
+	if secondOffset == -1 {
+		ds.replId2, ds.secondOffset = "", -1
+	} else if runId != ds.runId {
+		ds.replId2, ds.secondOffset = ds.runId, secondOffset
+	}
+	ds.runId = runId
+}
+
+func (ds *DbSyncer) targetInfo() []string {
+	ds.targetLock.RLock()
+	defer ds.targetLock.RUnlock()
+	return ds.target
+}
+
+// switch the source to the new master, the current source connection is closed and then reopened.
+func (ds *DbSyncer) switchSource(source string) {
+	if old, _ := ds.sourceInfo(); old == source {
+		return
+	}
+	log.Infof("DbSyncer[%d] Event:SourceSwitch\tId:%s\tswitch source to %v", ds.id, conf.Options.Id, source)
+	ds.setSourceAddress(source)
+	select {
+	case ds.sourceSwitched <- struct{}{}:
+	default:
+	}
+}
+
+// switch the target to the new master, the current target connection is closed and then reopened.
+func (ds *DbSyncer) switchTarget(target string) {
+	ds.targetLock.Lock()
+	if len(ds.target) == 1 && ds.target[0] == target {
+		ds.targetLock.Unlock()
+		return
+	}
+	ds.target = []string{target}
+	ds.targetLock.Unlock()
+
+	log.Infof("DbSyncer[%d] Event:TargetSwitch\tId:%s\tswitch target to %v", ds.id, conf.Options.Id, target)
+	select {
+	case ds.targetSwitched <- struct{}{}:
+	default:
+	}
 }
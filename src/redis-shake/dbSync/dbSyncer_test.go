@@ -0,0 +1,45 @@
+package dbSync
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetRunId(t *testing.T) {
+	// test setRunId
+
+	var nr int
+
+	{
+		fmt.Printf("TestSetRunId case %d.\n", nr)
+		nr++
+
+		ds := &DbSyncer{secondOffset: -1}
+		ds.setRunId("aaa", -1)
+		_, runId := ds.sourceInfo()
+		assert.Equal(t, "aaa", runId, "should be equal")
+		assert.Equal(t, "", ds.replId2, "should be equal")
+		assert.Equal(t, int64(-1), ds.secondOffset, "should be equal")
+
+		// psync continue with the new replication id
+		ds.setRunId("bbb", 100)
+		_, runId = ds.sourceInfo()
+		assert.Equal(t, "bbb", runId, "should be equal")
+		assert.Equal(t, "aaa", ds.replId2, "should be equal")
+		assert.Equal(t, int64(100), ds.secondOffset, "should be equal")
+
+		// same replication id, nothing changes
+		ds.setRunId("bbb", 200)
+		assert.Equal(t, "aaa", ds.replId2, "should be equal")
+		assert.Equal(t, int64(100), ds.secondOffset, "should be equal")
+
+		// full sync
+		ds.setRunId("ccc", -1)
+		_, runId = ds.sourceInfo()
+		assert.Equal(t, "ccc", runId, "should be equal")
+		assert.Equal(t, "", ds.replId2, "should be equal")
+		assert.Equal(t, int64(-1), ds.secondOffset, "should be equal")
+	}
+}
@@ -12,6 +12,7 @@ type Status struct {
 	targetOffset       atomic2.Int64 // target offset
 	sourceOffset       atomic2.Int64 // source offset
 	sourceMasterOffset atomic2.Int64 // source master offset
+	pendingCmds        atomic2.Int64 // commands sent to the target without receiving the replies
 }
 
 func (s *Status) Stat() *syncerStat {
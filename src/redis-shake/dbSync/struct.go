@@ -1,22 +1,105 @@
 package dbSync
 
-import "time"
+import (
+	"bufio"
+	"sync"
+	"time"
+
+	"github.com/alibaba/RedisShake/pkg/libs/errors"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+
+	redigo "github.com/garyburd/redigo/redis"
+)
 
 var (
 	incrSyncReadeTimeout = time.Duration(10) * time.Minute
 	incrSyncWriteTimeout = time.Duration(10) * time.Minute
-)
 
-type delayNode struct {
-	t  time.Time // timestamp
-	id int64     // id
-}
+	// fetch the source offset in this interval, it's also the precision of the lag in seconds.
+	fetchOffsetInterval = time.Duration(1) * time.Second
+
+	// the source pings the replica every 10 seconds by default, so the link is regarded as broken once
+	// nothing is read in this duration.
+	sourceReadTimeout = time.Duration(1) * time.Minute
+	// reconnect interval doubles from the min value until reaching the max value.
+	reconnectMinInterval = time.Duration(1) * time.Second
+	reconnectMaxInterval = time.Duration(30) * time.Second
+
+	// save the checkpoint into the store outside the target in this interval.
+	checkpointSaveInterval = time.Duration(1) * time.Second
+	// check whether the batch resent after error replies is acknowledged in this interval.
+	waitAckedInterval = time.Duration(10) * time.Millisecond
+
+	// the incremental pipe is closed with this error when the source answers "+FULLRESYNC" after reconnecting.
+	errFullResync = errors.New("source full resync")
+)
 
 type cmdDetail struct {
 	Cmd    string
 	Args   []interface{}
 	Offset int64
 	Db     int
+	Time   time.Time // when the command is read from the source, used to calculate the delay
+
+	// not nil means this is not a command but a request to flush all the previous commands, the writer
+	// closes it once all of them are applied by the target.
+	drained chan struct{}
+}
+
+// full resync of the source after reconnecting, the rdb and the following commands are read from reader.
+type resyncNode struct {
+	reader  *bufio.Reader
+	rdbSize int64
+	runId   string
+	offset  int64
+}
+
+// one batch of commands flushed to the target together. The batch is kept until all the replies are
+// received, so it can be sent again after reconnecting the target.
+type sendBatch struct {
+	seq        int64       // sequence of the batch, starts from 1
+	cmds       []cmdDetail // commands in the batch
+	source     string      // source address written into the checkpoint
+	runId      string      // source run-id written into the checkpoint
+	offset     int64       // source offset of the last command
+	checkpoint bool        // wrapped by "multi" and "exec" with the checkpoint offset
+	atomic     bool        // the transaction of the source, wrapped by "multi" and "exec" even without checkpoint
+	withRunId  bool        // also write the run-id and version into the checkpoint
+	startDb    int         // db selected before the first command
+	endDb      int         // db selected after the last command, the checkpoint is written into this db
+	replies    int64       // number of replies expected
+	resend     bool        // only the left commands are resent after the error replies
+}
+
+// connection to the target in incremental sync, it's replaced by a new one once broken.
+type targetConn struct {
+	c       redigo.Conn
+	batches chan *sendBatch // batches waiting for the replies in sending order, bounded by sender.delay_channel_size
+	broken  chan struct{}   // closed once the connection is broken
+	exit    chan struct{}   // closed once the receiver exits
+	once    sync.Once
+}
+
+// whether the batch is sent in "multi" and "exec"
+func (b *sendBatch) wrapped() bool {
+	return b.checkpoint || b.atomic
+}
+
+func newTargetConn(c redigo.Conn) *targetConn {
+	return &targetConn{
+		c:       c,
+		batches: make(chan *sendBatch, conf.Options.SenderDelayChannelSize),
+		broken:  make(chan struct{}),
+		exit:    make(chan struct{}),
+	}
+}
+
+// mark the connection broken and close it, the receiver exits after that.
+func (tc *targetConn) close() {
+	tc.once.Do(func() {
+		close(tc.broken)
+		tc.c.Close()
+	})
 }
 
 func (c *cmdDetail) String() string {
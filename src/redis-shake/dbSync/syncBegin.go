@@ -2,8 +2,11 @@ package dbSync
 
 import (
 	"bufio"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"os"
 	"time"
 
 	"github.com/alibaba/RedisShake/pkg/libs/atomic2"
@@ -48,98 +51,286 @@ func (ds *DbSyncer) sendPSyncCmd(master, authType, passwd string, tlsEnable bool
 
 	log.Infof("DbSyncer[%d] try to send 'psync' command: run-id[%v], offset[%v]", ds.id, runId, prevOffset)
 	// send psync command and decode the result
-	runid, offset, wait := utils.SendPSyncContinue(br, bw, runId, prevOffset, false)
+	runid, offset, wait, err := utils.SendPSyncContinue(br, bw, runId, prevOffset, false)
+	if err != nil {
+		log.PanicErrorf(err, "DbSyncer[%d] send psync command failed", ds.id)
+	}
 	ds.stat.targetOffset.Set(offset)
 	ds.fullSyncOffset = offset // store the full sync offset
 
-	piper, pipew := pipe.NewSize(utils.ReaderBufferSize)
+	piper, pipew := ds.newPipe()
 	if wait == nil {
 		// continue
 		log.Infof("DbSyncer[%d] psync runid = %s, offset = %d, psync continue", ds.id, runId, offset)
-		go ds.runIncrementalSync(c, br, bw, 0, runid, offset, master, authType, passwd, tlsEnable, pipew, true)
+		go ds.runIncrementalSync(c, br, bw, 0, runid, offset, master, authType, passwd, tlsEnable, tlsSkipVerify, pipew, false)
 		return piper, 0, false, runid
 	} else {
 		// fullresync
 		log.Infof("DbSyncer[%d] psync runid = %s, offset = %d, fullsync", ds.id, runid, offset)
 
 		// get rdb file size, wait source rdb dump successfully.
-		var nsize int64
-		for nsize == 0 {
-			select {
-			case nsize = <-wait:
-				if nsize == 0 {
-					log.Infof("DbSyncer[%d] +", ds.id)
-				}
-			case <-time.After(time.Second):
-				log.Infof("DbSyncer[%d] -", ds.id)
-			}
-		}
+		nsize := ds.waitRdbSize(wait)
 
-		go ds.runIncrementalSync(c, br, bw, int(nsize), runid, offset, master, authType, passwd, tlsEnable, pipew, true)
+		go ds.runIncrementalSync(c, br, bw, int(nsize), runid, offset, master, authType, passwd, tlsEnable, tlsSkipVerify, pipew, true)
 		return piper, nsize, true, runid
 	}
 }
 
+func (ds *DbSyncer) waitRdbSize(wait <-chan int64) int64 {
+	var nsize int64
+	for nsize == 0 {
+		select {
+		case nsize = <-wait:
+			if nsize == 0 {
+				log.Infof("DbSyncer[%d] +", ds.id)
+			}
+		case <-time.After(time.Second):
+			log.Infof("DbSyncer[%d] -", ds.id)
+		}
+	}
+	return nsize
+}
+
 func (ds *DbSyncer) runIncrementalSync(c net.Conn, br *bufio.Reader, bw *bufio.Writer, rdbSize int, runId string,
-	offset int64, master, authType, passwd string, tlsEnable bool, pipew pipe.Writer,
+	offset int64, master, authType, passwd string, tlsEnable bool, tlsSkipVerify bool, pipew pipe.Writer,
 	isFullSync bool) {
 	// write -> pipew -> piper -> read
-	defer pipew.Close()
+	defer func() {
+		// pipew may be replaced after full resync
+		pipew.Close()
+	}()
+	// the rdb in the pipe is incomplete because the source drops, it should be transferred again
+	var rdbBroken bool
 	if isFullSync {
-		p := make([]byte, 8192)
-		// read rdb in for loop
-		for rdbSize != 0 {
-			// br -> pipew
-			rdbSize -= utils.Iocopy(br, pipew, p, rdbSize)
-		}
+		rdbBroken = !ds.copyRdb(c, br, pipew, rdbSize)
 	}
 
 	for {
+		if !rdbBroken {
+			/*
+			 * read from br(source redis) and write into pipew.
+			 * Generally speaking, this function is forever run.
+			 */
+			n, err := ds.pSyncPipeCopy(c, br, bw, offset, pipew)
+			if err != nil {
+				log.PanicErrorf(err, "DbSyncer[%d] psync runid = %s, offset = %d, pipe is broken",
+					ds.id, runId, offset)
+			}
+			// the 'c' is closed every loop
+
+			offset += n
+			ds.stat.targetOffset.Set(offset)
+		}
+		if base.ShuttingDown() {
+			log.Infof("DbSyncer[%d] stop reading from the source at offset[%v] on shutdown", ds.id, offset)
+			return
+		}
+
+		// reopen 'c' and send psync with the tracked offset until success
+		var newRunId string
+		var newOffset int64
+		var wait <-chan int64
+		var err error
+		base.Status = "reopen"
+		for retry := 0; ; retry++ {
+			time.Sleep(reconnectInterval(retry))
+
+			// the source may be switched by the sentinel watcher
+			master, _ = ds.sourceInfo()
+			// the source may failover, try the new master if the current one can't be reconnected
+			if retry > 0 {
+				if newMaster := ds.resolveSource(master); newMaster != master {
+					log.Warnf("DbSyncer[%d] Event:SourceSwitch\tId: %s\tfrom %s to %s",
+						ds.id, conf.Options.Id, master, newMaster)
+					master = newMaster
+					ds.setSourceAddress(master)
+				}
+			}
+
+			c, err = utils.ReopenPSyncConn(master, authType, passwd, tlsEnable, tlsSkipVerify, conf.Options.HttpProfile)
+			if err != nil {
+				// log.PurePrintf("%s\n", NewLogItem("SourceConnReopenFail", "WARN", NewErrorLogDetail("", "")))
+				log.Errorf("DbSyncer[%d] Event:SourceConnReopenFail\tId: %s\tretry: %d\tError: %v",
+					ds.id, conf.Options.Id, retry, err)
+				continue
+			}
+
+			br = bufio.NewReaderSize(c, utils.ReaderBufferSize)
+			bw = bufio.NewWriterSize(c, utils.WriterBufferSize)
+			// "psync ? -1" asks for the full resync if the rdb is broken
+			psyncRunId, psyncOffset := runId, offset
+			if rdbBroken {
+				psyncRunId, psyncOffset = "?", -1
+			}
+			if newRunId, newOffset, wait, err = utils.SendPSyncContinue(br, bw, psyncRunId, psyncOffset,
+				true); err != nil {
+				log.Errorf("DbSyncer[%d] Event:SourceConnReopenFail\tId: %s\tretry: %d\tError: %v",
+					ds.id, conf.Options.Id, retry, err)
+				c.Close()
+				continue
+			}
+
+			// log.PurePrintf("%s\n", NewLogItem("SourceConnReopenSuccess", "INFO", LogDetail{Info: strconv.FormatInt(offset, 10)}))
+			log.Infof("DbSyncer[%d] Event:SourceConnReopenSuccess\tId: %s\toffset = %d",
+				ds.id, conf.Options.Id, offset)
+			base.Status = "incr"
+			break
+		}
+
+		if wait == nil {
+			// psync continue, the replication id changes if the source failover
+			if newRunId != runId {
+				log.Infof("DbSyncer[%d] psync continue with new replid = %s, the previous replid = %s is valid "+
+					"until offset = %d", ds.id, newRunId, runId, offset)
+				runId = newRunId
+				ds.setRunId(runId, offset)
+			}
+			continue
+		}
+
 		/*
-		 * read from br(source redis) and write into pipew.
-		 * Generally speaking, this function is forever run.
+		 * the source can't continue from the given offset, so the rdb is transferred again. The
+		 * current pipe is closed with errFullResync so that the command parser stops after parsing
+		 * all the previous data, and then loads the rdb and the following commands from the new pipe.
 		 */
-		n, err := ds.pSyncPipeCopy(c, br, bw, offset, pipew)
-		if err != nil {
-			log.PanicErrorf(err, "DbSyncer[%d] psync runid = %s, offset = %d, pipe is broken",
-				ds.id, runId, offset)
+		log.Warnf("DbSyncer[%d] psync runid = %s, offset = %d can't continue, fall back to full sync with "+
+			"runid = %s, offset = %d", ds.id, runId, offset, newRunId, newOffset)
+		nsize := ds.waitRdbSize(wait)
+
+		// wait the previous full sync finish if it's still loading from the pipe, the broken rdb is aborted
+		// once the pipe is closed
+		if !rdbBroken {
+			<-ds.WaitFull
 		}
-		// the 'c' is closed every loop
 
-		offset += n
+		piper, newPipew := ds.newPipe()
+		ds.resyncChan <- &resyncNode{
+			reader:  bufio.NewReaderSize(piper, utils.ReaderBufferSize),
+			rdbSize: nsize,
+			runId:   newRunId,
+			offset:  newOffset,
+		}
+		pipew.CloseWithError(errFullResync)
+		pipew = newPipew
+
+		runId, offset = newRunId, newOffset
 		ds.stat.targetOffset.Set(offset)
+		rdbBroken = !ds.copyRdb(c, br, pipew, int(nsize))
+	}
+}
 
-		// reopen 'c' every time
-		for {
-			// ds.SyncStat.SetStatus("reopen")
-			base.Status = "reopen"
-			time.Sleep(time.Second)
-			c = utils.OpenNetConnSoft(master, authType, passwd, tlsEnable)
-			if c != nil {
-				// log.PurePrintf("%s\n", NewLogItem("SourceConnReopenSuccess", "INFO", LogDetail{Info: strconv.FormatInt(offset, 10)}))
-				log.Infof("DbSyncer[%d] Event:SourceConnReopenSuccess\tId: %s\toffset = %d",
-					ds.id, conf.Options.Id, offset)
-				// ds.SyncStat.SetStatus("incr")
-				base.Status = "incr"
-				break
-			} else {
-				// log.PurePrintf("%s\n", NewLogItem("SourceConnReopenFail", "WARN", NewErrorLogDetail("", "")))
-				log.Errorf("DbSyncer[%d] Event:SourceConnReopenFail\tId: %s", ds.id, conf.Options.Id)
+/*
+ * resolve the current address of the source which may change after the sentinel or cluster failover. The
+ * given address is returned if the source isn't sentinel or cluster, or the resolving fails.
+ */
+func (ds *DbSyncer) resolveSource(master string) string {
+	var newMaster string
+	var err error
+	switch conf.Options.SourceType {
+	case conf.RedisTypeSentinel:
+		newMaster, err = utils.ResolveSentinelAddress(conf.Options.SourceAddress, true)
+	case conf.RedisTypeCluster:
+		if ds.slotLeftBoundary == -1 {
+			// the source is slave, or the slot isn't known
+			return master
+		}
+
+		// ask the other nodes at first
+		candidates := make([]string, 0, len(conf.Options.SourceAddressList)+1)
+		for _, addr := range conf.Options.SourceAddressList {
+			if addr != master {
+				candidates = append(candidates, addr)
 			}
 		}
-		utils.AuthPassword(c, authType, passwd)
-		utils.SendPSyncListeningPort(c, conf.Options.HttpProfile)
-		br = bufio.NewReaderSize(c, utils.ReaderBufferSize)
-		bw = bufio.NewWriterSize(c, utils.WriterBufferSize)
-		utils.SendPSyncContinue(br, bw, runId, offset, true)
+		candidates = append(candidates, master)
+		newMaster, err = utils.GetSlotMaster(candidates, conf.Options.SourceAuthType, ds.sourcePassword,
+			conf.Options.SourceTLSEnable, conf.Options.SourceTLSSkipVerify, ds.slotLeftBoundary)
+	default:
+		return master
 	}
+
+	if err != nil {
+		log.Warnf("DbSyncer[%d] resolve source address failed[%v]", ds.id, err)
+		return master
+	}
+	return newMaster
+}
+
+/*
+ * create the pipe between the source and the syncer. If spool.size is set, the pipe is a file in spool.dir
+ * so that the rdb and the following commands are read from the source at network speed while the target
+ * is slow, and the source doesn't close the connection because of the client-output-buffer-limit. Reading
+ * from the source is blocked only after the spool is full.
+ */
+func (ds *DbSyncer) newPipe() (pipe.Reader, pipe.Writer) {
+	if conf.Options.SpoolSize == 0 {
+		return pipe.NewSize(utils.ReaderBufferSize)
+	}
+
+	f, err := ioutil.TempFile(conf.Options.SpoolDir, fmt.Sprintf("%s-%d-*.spool", conf.Options.Id, ds.id))
+	if err != nil {
+		log.PanicErrorf(err, "DbSyncer[%d] create spool file in [%v] failed", ds.id, conf.Options.SpoolDir)
+	}
+	// the file is only accessed by the opened handle, so it's removed even if the process is killed
+	if err := os.Remove(f.Name()); err != nil {
+		log.Warnf("DbSyncer[%d] remove spool file[%v] failed[%v]", ds.id, f.Name(), err)
+	}
+	log.Infof("DbSyncer[%d] spool the source into file[%v] with size[%v]", ds.id, f.Name(),
+		utils.GetMetric(int64(conf.Options.SpoolSize)))
+	return pipe.NewFilePipe(int(conf.Options.SpoolSize), f)
+}
+
+/*
+ * copy rdb from the source into the pipe, false is returned if the source drops before the whole rdb is
+ * copied, and then 'c' is closed so that the rdb is transferred again after reconnecting.
+ */
+func (ds *DbSyncer) copyRdb(c net.Conn, br *bufio.Reader, pipew pipe.Writer, rdbSize int) bool {
+	p := make([]byte, 8192)
+	// read rdb in for loop
+	for left := rdbSize; left != 0; {
+		if len(p) > left {
+			p = p[:left]
+		}
+		c.SetReadDeadline(time.Now().Add(sourceReadTimeout))
+		n, err := br.Read(p)
+		if err != nil {
+			log.Warnf("DbSyncer[%d] read rdb from source failed[%v], %d of %d bytes are read", ds.id, err,
+				rdbSize-left, rdbSize)
+			c.Close()
+			return false
+		}
+		// br -> pipew
+		if _, err := pipew.Write(p[:n]); err != nil {
+			log.PanicErrorf(err, "DbSyncer[%d] write rdb into pipe failed", ds.id)
+		}
+		left -= n
+	}
+	return true
 }
 
 func (ds *DbSyncer) pSyncPipeCopy(c net.Conn, br *bufio.Reader, bw *bufio.Writer, offset int64, copyto io.Writer) (int64, error) {
 	var nread atomic2.Int64
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
 		defer c.Close()
-		for range time.NewTicker(1 * time.Second).C {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ds.sourceSwitched:
+				// close the connection so the source is reconnected with the new address
+				log.Infof("DbSyncer[%d] source switched, close the current connection", ds.id)
+				return
+			case <-base.ShutdownContext().Done():
+				// stop reading from the source, the received data is still flushed
+				log.Infof("DbSyncer[%d] shutdown, close the source connection", ds.id)
+				return
+			case <-ticker.C:
+			}
+
 			select {
 			case <-ds.WaitFull:
 				if err := utils.SendPSyncAck(bw, offset+nread.Get()); err != nil {
@@ -157,8 +348,10 @@ func (ds *DbSyncer) pSyncPipeCopy(c net.Conn, br *bufio.Reader, bw *bufio.Writer
 
 	var p = make([]byte, 8192)
 	for {
+		c.SetReadDeadline(time.Now().Add(sourceReadTimeout))
 		n, err := br.Read(p)
 		if err != nil {
+			log.Warnf("DbSyncer[%d] read from source failed[%v], offset = %d", ds.id, err, offset+nread.Get())
 			return nread.Get(), nil
 		}
 		if _, err := copyto.Write(p[:n]); err != nil {
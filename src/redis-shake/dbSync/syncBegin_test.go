@@ -0,0 +1,137 @@
+package dbSync
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/alibaba/RedisShake/pkg/libs/atomic2"
+	"github.com/alibaba/RedisShake/pkg/libs/io/pipe"
+	utils "github.com/alibaba/RedisShake/redis-shake/common"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPipe(t *testing.T) {
+	// test newPipe in memory and spooled on disk
+
+	var nr int
+
+	dir, err := ioutil.TempDir("", "spool")
+	assert.Equal(t, nil, err, "should be equal")
+	defer os.RemoveAll(dir)
+	defer func() {
+		conf.Options.SpoolSize = 0
+		conf.Options.SpoolDir = ""
+	}()
+
+	ds := &DbSyncer{id: 1}
+	{
+		fmt.Printf("TestNewPipe case %d.\n", nr)
+		nr++
+
+		r, w := ds.newPipe()
+		n, err := w.Available()
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, utils.ReaderBufferSize, n, "should be equal")
+		r.Close()
+		w.Close()
+	}
+
+	{
+		fmt.Printf("TestNewPipe case %d.\n", nr)
+		nr++
+
+		conf.Options.SpoolSize = pipe.FileSizeAlign * 2
+		conf.Options.SpoolDir = dir
+		r, w := ds.newPipe()
+		n, err := w.Available()
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, pipe.FileSizeAlign*2, n, "should be equal")
+
+		// the spool file is removed after opening
+		files, err := ioutil.ReadDir(dir)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 0, len(files), "should be equal")
+
+		// the data more than the memory buffer is kept in the spool before reading
+		data := make([]byte, pipe.FileSizeAlign+100)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		_, err = w.Write(data)
+		assert.Equal(t, nil, err, "should be equal")
+		n, err = r.Buffered()
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, len(data), n, "should be equal")
+
+		p := make([]byte, len(data))
+		_, err = io.ReadFull(r, p)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, data, p, "should be equal")
+		r.Close()
+		w.Close()
+	}
+}
+
+func TestCopyRdb(t *testing.T) {
+	// test copyRdb and the loader aborted by errFullResync
+
+	var nr int
+
+	ds := &DbSyncer{id: 1}
+	rdb := []byte("REDIS0009\xff")
+	{
+		fmt.Printf("TestCopyRdb case %d.\n", nr)
+		nr++
+
+		// only the rdb is copied, the following commands are kept in the reader
+		c, s := net.Pipe()
+		go func() {
+			s.Write(append(rdb, "*1\r\n$4\r\nPING\r\n"...))
+			s.Close()
+		}()
+		r, w := pipe.NewSize(1024)
+		br := bufio.NewReader(c)
+		assert.Equal(t, true, ds.copyRdb(c, br, w, len(rdb)), "should be equal")
+		p := make([]byte, len(rdb))
+		_, err := io.ReadFull(r, p)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, rdb, p, "should be equal")
+		n, err := r.Buffered()
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, 0, n, "should be equal")
+		p, err = ioutil.ReadAll(br)
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, "*1\r\n$4\r\nPING\r\n", string(p), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestCopyRdb case %d.\n", nr)
+		nr++
+
+		// the source drops during transferring the rdb
+		c, s := net.Pipe()
+		go func() {
+			s.Write(rdb[:5])
+			s.Close()
+		}()
+		r, w := pipe.NewSize(1024)
+		assert.Equal(t, false, ds.copyRdb(c, bufio.NewReader(c), w, len(rdb)), "should be equal")
+
+		// the broken rdb is aborted once the pipe is closed with errFullResync
+		var rbytes atomic2.Int64
+		pipes, errc := utils.NewParallelRDBLoader(bufio.NewReader(r), &rbytes, 16, 2, errFullResync)
+		w.CloseWithError(errFullResync)
+		for _, pipe := range pipes {
+			for range pipe {
+			}
+		}
+		assert.Equal(t, errFullResync, <-errc, "should be equal")
+	}
+}
@@ -0,0 +1,149 @@
+package dbSync
+
+import (
+	"fmt"
+
+	"github.com/alibaba/RedisShake/pkg/libs/log"
+	utils "github.com/alibaba/RedisShake/redis-shake/common"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+)
+
+// max length of the command printed in the log
+const maxCommandLogLength = 1024
+
+// the replies of one batch, only the error replies and the reply of "exec" are kept.
+type batchReplies struct {
+	cmdErrs   map[int]error // index of the command -> error reply
+	otherErrs []error       // error replies of "multi" and the checkpoint
+	execReply interface{}
+	execErr   error
+}
+
+func (br *batchReplies) reset() {
+	br.cmdErrs = make(map[int]error)
+	br.otherErrs = br.otherErrs[:0]
+	br.execReply = nil
+	br.execErr = nil
+}
+
+/*
+ * add the reply with the given index in the batch.
+ * without checkpoint: command_1, ..., command_n
+ * with checkpoint: multi, command_1, ..., command_n, [hset run-id, hset version], hset offset, exec
+ * the transaction of the source without checkpoint: multi, command_1, ..., command_n, exec
+ */
+func (br *batchReplies) add(batch *sendBatch, index int64, reply interface{}, err error) {
+	if !batch.wrapped() {
+		if err != nil {
+			br.cmdErrs[int(index)] = err
+		}
+		return
+	}
+
+	if index == batch.replies-1 {
+		br.execReply, br.execErr = reply, err
+	} else if err == nil {
+		return
+	} else if index >= 1 && index <= int64(len(batch.cmds)) {
+		// error before "exec", e.g., OOM, unknown command. the transaction will be discarded.
+		br.cmdErrs[int(index-1)] = err
+	} else {
+		br.otherErrs = append(br.otherErrs, err)
+	}
+}
+
+/*
+ * handle the error replies of the batch according to the policy of the error class. The commands need to be
+ * resent are returned with true, e.g., the policy is retry, or the transaction is discarded. The "select"s of
+ * the batch are resent too, so the commands resent run in the same db as before and the batch ends in the
+ * same db for the following batches.
+ */
+func (ds *DbSyncer) handleErrorReplies(batch *sendBatch, br *batchReplies) ([]cmdDetail, bool) {
+	discarded := false
+	if batch.wrapped() {
+		if br.execErr != nil {
+			// "EXECABORT", nothing is applied
+			discarded = true
+		} else if arr, ok := br.execReply.([]interface{}); ok {
+			// the error in execution, the other commands are applied
+			for i, v := range arr {
+				e, ok := v.(error)
+				if !ok {
+					continue
+				}
+				if i < len(batch.cmds) {
+					br.cmdErrs[i] = e
+				} else {
+					br.otherErrs = append(br.otherErrs, e)
+				}
+			}
+		}
+
+		for _, e := range br.otherErrs {
+			log.Warnf("DbSyncer[%d] Event:ErrorReply\tId:%s\tCommand: [checkpoint]\tError: %s",
+				ds.id, conf.Options.Id, e.Error())
+		}
+	}
+
+	if len(br.cmdErrs) == 0 && !discarded {
+		return nil, false
+	}
+
+	var resend []cmdDetail
+	retry := discarded
+	for i := range batch.cmds {
+		err, ok := br.cmdErrs[i]
+		if !ok {
+			if _, isSelect := selectedDb(batch.cmds[i]); discarded || isSelect {
+				resend = append(resend, batch.cmds[i])
+			}
+			continue
+		}
+
+		cmd := &batch.cmds[i]
+		policy := utils.ErrorPolicy(err)
+		switch policy {
+		case conf.ErrorPolicyRetry:
+			log.Warnf("DbSyncer[%d] Event:ErrorReply\tId:%s\tCommand: [%s]\tError: %s\tPolicy: %s",
+				ds.id, conf.Options.Id, commandString(cmd), err.Error(), policy)
+			resend = append(resend, *cmd)
+			retry = true
+		case conf.ErrorPolicySkip:
+			log.Warnf("DbSyncer[%d] Event:ErrorReply\tId:%s\tCommand: [%s]\tError: %s\tPolicy: %s",
+				ds.id, conf.Options.Id, commandString(cmd), err.Error(), policy)
+		case conf.ErrorPolicyDeadLetter:
+			log.Warnf("DbSyncer[%d] Event:ErrorReply\tId:%s\tCommand: [%s]\tError: %s\tPolicy: %s",
+				ds.id, conf.Options.Id, commandString(cmd), err.Error(), policy)
+			if e := utils.WriteDeadLetter(batch.source, batch.dbOf(i), cmd.Offset, cmd.Cmd, cmd.Args, err); e != nil {
+				log.Panicf("DbSyncer[%d] write dead-letter failed[%v]", ds.id, e)
+			}
+		default:
+			log.Panicf("DbSyncer[%d] Event:ErrorReply\tId:%s\tCommand: [%s]\tError: %s",
+				ds.id, conf.Options.Id, commandString(cmd), err.Error())
+		}
+	}
+
+	if !retry {
+		return nil, false
+	}
+	return resend, len(resend) > 0
+}
+
+// the db selected when running the command with the given index
+func (b *sendBatch) dbOf(index int) int {
+	db := b.startDb
+	for i := 0; i < index && i < len(b.cmds); i++ {
+		if n, ok := selectedDb(b.cmds[i]); ok {
+			db = n
+		}
+	}
+	return db
+}
+
+func commandString(cmd *cmdDetail) string {
+	str := cmd.String()
+	if len(str) > maxCommandLogLength {
+		return fmt.Sprintf("%s...(%d bytes)", str[:maxCommandLogLength], len(str))
+	}
+	return str
+}
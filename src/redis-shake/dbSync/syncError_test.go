@@ -0,0 +1,172 @@
+package dbSync
+
+import (
+	"fmt"
+	"testing"
+
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+
+	redigo "github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCmd(cmd string, args ...string) cmdDetail {
+	data := make([]interface{}, len(args))
+	for i, arg := range args {
+		data[i] = []byte(arg)
+	}
+	return cmdDetail{Cmd: cmd, Args: data}
+}
+
+func TestHandleErrorReplies(t *testing.T) {
+	// test batchReplies and handleErrorReplies
+
+	var nr int
+
+	conf.Options.TargetErrPolicy = map[string]string{
+		conf.ErrorClassDefault: conf.ErrorPolicyPanic,
+		"WRONGTYPE":            conf.ErrorPolicySkip,
+		"OOM":                  conf.ErrorPolicyRetry,
+	}
+	ds := new(DbSyncer)
+
+	// without checkpoint
+	{
+		fmt.Printf("TestHandleErrorReplies case %d.\n", nr)
+		nr++
+
+		batch := &sendBatch{
+			cmds: []cmdDetail{newTestCmd("set", "a", "1"), newTestCmd("lpush", "a", "1"),
+				newTestCmd("set", "b", "1")},
+			replies: 3,
+		}
+		var br batchReplies
+		br.reset()
+		br.add(batch, 0, "OK", nil)
+		br.add(batch, 1, nil, redigo.Error("WRONGTYPE Operation"))
+		br.add(batch, 2, "OK", nil)
+		_, ok := ds.handleErrorReplies(batch, &br)
+		assert.Equal(t, false, ok, "should be equal")
+
+		br.reset()
+		br.add(batch, 0, "OK", nil)
+		br.add(batch, 1, nil, redigo.Error("OOM command not allowed"))
+		br.add(batch, 2, nil, redigo.Error("OOM command not allowed"))
+		resend, ok := ds.handleErrorReplies(batch, &br)
+		assert.Equal(t, true, ok, "should be equal")
+		assert.Equal(t, []cmdDetail{batch.cmds[1], batch.cmds[2]}, resend, "should be equal")
+	}
+
+	// the "select"s are resent with the commands retried
+	{
+		fmt.Printf("TestHandleErrorReplies case %d.\n", nr)
+		nr++
+
+		batch := &sendBatch{
+			cmds: []cmdDetail{newTestCmd("select", "1"), newTestCmd("set", "a", "1"), newTestCmd("select", "2"),
+				newTestCmd("set", "b", "1"), newTestCmd("select", "3"), newTestCmd("lpush", "c", "1")},
+			replies: 6,
+		}
+		var br batchReplies
+		br.reset()
+		br.add(batch, 0, "OK", nil)
+		br.add(batch, 1, "OK", nil)
+		br.add(batch, 2, "OK", nil)
+		br.add(batch, 3, nil, redigo.Error("OOM command not allowed"))
+		br.add(batch, 4, "OK", nil)
+		br.add(batch, 5, nil, redigo.Error("WRONGTYPE Operation"))
+		resend, ok := ds.handleErrorReplies(batch, &br)
+		assert.Equal(t, true, ok, "should be equal")
+		assert.Equal(t, []cmdDetail{batch.cmds[0], batch.cmds[2], batch.cmds[3], batch.cmds[4]}, resend,
+			"should be equal")
+
+		// nothing is resent if no command is retried
+		br.reset()
+		br.add(batch, 3, nil, redigo.Error("WRONGTYPE Operation"))
+		_, ok = ds.handleErrorReplies(batch, &br)
+		assert.Equal(t, false, ok, "should be equal")
+	}
+
+	// with checkpoint, error in execution
+	{
+		fmt.Printf("TestHandleErrorReplies case %d.\n", nr)
+		nr++
+
+		batch := &sendBatch{
+			cmds:       []cmdDetail{newTestCmd("set", "a", "1"), newTestCmd("lpush", "a", "1")},
+			checkpoint: true,
+			replies:    5,
+		}
+		var br batchReplies
+		br.reset()
+		br.add(batch, 0, "OK", nil)
+		br.add(batch, 1, "QUEUED", nil)
+		br.add(batch, 2, "QUEUED", nil)
+		br.add(batch, 3, "QUEUED", nil)
+		br.add(batch, 4, []interface{}{"OK", redigo.Error("WRONGTYPE Operation"), int64(1)}, nil)
+		_, ok := ds.handleErrorReplies(batch, &br)
+		assert.Equal(t, false, ok, "should be equal")
+	}
+
+	// with checkpoint, the transaction is discarded
+	{
+		fmt.Printf("TestHandleErrorReplies case %d.\n", nr)
+		nr++
+
+		batch := &sendBatch{
+			cmds:       []cmdDetail{newTestCmd("set", "a", "1"), newTestCmd("lpush", "a", "1")},
+			checkpoint: true,
+			replies:    5,
+		}
+		var br batchReplies
+		br.reset()
+		br.add(batch, 0, "OK", nil)
+		br.add(batch, 1, "QUEUED", nil)
+		br.add(batch, 2, nil, redigo.Error("WRONGTYPE Operation"))
+		br.add(batch, 3, "QUEUED", nil)
+		br.add(batch, 4, nil, redigo.Error("EXECABORT Transaction discarded"))
+		resend, ok := ds.handleErrorReplies(batch, &br)
+		assert.Equal(t, true, ok, "should be equal")
+		assert.Equal(t, []cmdDetail{batch.cmds[0]}, resend, "should be equal")
+	}
+
+	// the transaction of the source without checkpoint
+	{
+		fmt.Printf("TestHandleErrorReplies case %d.\n", nr)
+		nr++
+
+		batch := &sendBatch{
+			cmds:    []cmdDetail{newTestCmd("set", "a", "1"), newTestCmd("lpush", "a", "1")},
+			atomic:  true,
+			replies: 4,
+		}
+		var br batchReplies
+		br.reset()
+		br.add(batch, 0, "OK", nil)
+		br.add(batch, 1, "QUEUED", nil)
+		br.add(batch, 2, "QUEUED", nil)
+		br.add(batch, 3, []interface{}{"OK", redigo.Error("OOM command not allowed")}, nil)
+		resend, ok := ds.handleErrorReplies(batch, &br)
+		assert.Equal(t, true, ok, "should be equal")
+		assert.Equal(t, []cmdDetail{batch.cmds[1]}, resend, "should be equal")
+	}
+}
+
+func TestDbOf(t *testing.T) {
+	// test dbOf
+
+	var nr int
+
+	{
+		fmt.Printf("TestDbOf case %d.\n", nr)
+		nr++
+
+		batch := &sendBatch{
+			cmds:    []cmdDetail{newTestCmd("set", "a", "1"), newTestCmd("select", "3"), newTestCmd("set", "b", "1")},
+			startDb: 1,
+		}
+		assert.Equal(t, 1, batch.dbOf(0), "should be equal")
+		assert.Equal(t, 1, batch.dbOf(1), "should be equal")
+		assert.Equal(t, 3, batch.dbOf(2), "should be equal")
+	}
+}
@@ -5,14 +5,15 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"net"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/alibaba/RedisShake/pkg/libs/atomic2"
+	"github.com/alibaba/RedisShake/pkg/libs/errors"
 	"github.com/alibaba/RedisShake/pkg/libs/log"
 	"github.com/alibaba/RedisShake/pkg/redis"
+	"github.com/alibaba/RedisShake/redis-shake/base"
 	utils "github.com/alibaba/RedisShake/redis-shake/common"
 	conf "github.com/alibaba/RedisShake/redis-shake/configure"
 	"github.com/alibaba/RedisShake/redis-shake/filter"
@@ -26,33 +27,59 @@ import (
 
 func (ds *DbSyncer) syncCommand(reader *bufio.Reader, target []string, authType, passwd string, tlsEnable bool, tlsSkipVerify bool, dbid int) {
 	isCluster := conf.Options.TargetType == conf.RedisTypeCluster
-	c := utils.OpenRedisConnWithTimeout(target, authType, passwd, incrSyncReadeTimeout, incrSyncReadeTimeout, isCluster, tlsEnable, tlsSkipVerify)
-	defer c.Close()
-
 	ds.sendBuf = make(chan cmdDetail, conf.Options.SenderCount)
-	ds.delayChannel = make(chan *delayNode, conf.Options.SenderDelayChannelSize)
+	ds.spool = newCmdSpool(ds.id, ds.sendBuf)
 
 	// fetch source redis offset
-	go ds.fetchOffset()
+	if !ds.local {
+		go ds.fetchOffset()
+	}
 
-	// receiver target reply
-	go ds.receiveTargetReply(c)
+	for _, w := range ds.writers {
+		var c redigo.Conn
+		if w.node != "" {
+			c = utils.OpenRedisConnWithTimeout([]string{w.node}, authType, passwd, incrSyncReadeTimeout,
+				incrSyncReadeTimeout, false, tlsEnable, tlsSkipVerify)
+		} else {
+			c = utils.OpenRedisConnWithTimeout(target, authType, passwd, incrSyncReadeTimeout, incrSyncReadeTimeout,
+				isCluster, tlsEnable, tlsSkipVerify)
+		}
+		// the connection is replaced once broken, so the receiver and sender share the targetConn
+		tc := newTargetConn(c)
+
+		// receiver target reply
+		go ds.receiveTargetReply(w, tc)
+
+		// do send to target
+		go ds.sendTargetCommand(w, tc)
+	}
 
 	// parse command from source redis
 	go ds.parseSourceCommand(reader)
 
-	// do send to target
-	go ds.sendTargetCommand(c)
+	// dispatch the commands to the writers
+	go ds.dispatchCommand()
 
-	// print stat
+	// print stat until the sync stops
 	for lStat := ds.stat.Stat(); ; {
-		time.Sleep(time.Second)
+		select {
+		case <-ds.WaitStop:
+			return
+		case <-time.After(time.Second):
+		}
 		nStat := ds.stat.Stat()
 		var b bytes.Buffer
 		fmt.Fprintf(&b, "DbSyncer[%d] sync: ", ds.id)
 		fmt.Fprintf(&b, " +forwardCommands=%-6d", nStat.wCommands-lStat.wCommands)
 		fmt.Fprintf(&b, " +filterCommands=%-6d", nStat.incrSyncFilter-lStat.incrSyncFilter)
 		fmt.Fprintf(&b, " +writeBytes=%d", nStat.wBytes-lStat.wBytes)
+		if ds.spool != nil {
+			size, count, age := ds.spool.stat()
+			metric.GetMetric(ds.id).SetSpool(ds.id, size, count, age)
+			if count != 0 {
+				fmt.Fprintf(&b, " overflow=%s/%d/%v", utils.GetMetric(size), count, age.Truncate(time.Second))
+			}
+		}
 		log.Info(b.String())
 		lStat = nStat
 	}
@@ -64,27 +91,53 @@ func (ds *DbSyncer) fetchOffset() {
 		return
 	}
 
-	srcConn := utils.OpenRedisConnWithTimeout([]string{ds.source}, conf.Options.SourceAuthType, ds.sourcePassword,
+	source, _ := ds.sourceInfo()
+	srcConn := utils.OpenRedisConnWithTimeout([]string{source}, conf.Options.SourceAuthType, ds.sourcePassword,
 		incrSyncReadeTimeout, incrSyncReadeTimeout, false, conf.Options.SourceTLSEnable, conf.Options.SourceTLSSkipVerify)
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(fetchOffsetInterval)
+	var zeroLag zeroLagChecker
 	for range ticker.C {
+		// lag of the target in seconds
+		lag := ds.offsetTracker.lag(ds.appliedOffset(), time.Now())
+		metric.GetMetric(ds.id).SetLag(ds.id, lag)
+
+		// no lag before fetching the source offset, or in the full resync
+		if conf.Options.StopZeroLagSeconds > 0 && zeroLag.check(lag == 0 && ds.stat.sourceMasterOffset.Get() > 0 &&
+			base.Status == "incr", time.Now(), time.Duration(conf.Options.StopZeroLagSeconds)*time.Second) {
+			ds.requestStop(fmt.Sprintf("lag keeps zero for %d seconds", conf.Options.StopZeroLagSeconds))
+		}
+
+		// the source address may change after failover
+		if newSource, _ := ds.sourceInfo(); newSource != source || srcConn == nil {
+			if srcConn != nil {
+				srcConn.Close()
+				srcConn = nil
+			}
+			source = newSource
+
+			var err error
+			if srcConn, err = utils.TryOpenRedisConnWithTimeout([]string{source}, conf.Options.SourceAuthType,
+				ds.sourcePassword, incrSyncReadeTimeout, incrSyncReadeTimeout, false, conf.Options.SourceTLSEnable,
+				conf.Options.SourceTLSSkipVerify); err != nil {
+				log.Warnf("DbSyncer[%d] Event:GetFakeSlaveOffsetReconnFail\tId:%s\tWarn:%s",
+					ds.id, conf.Options.Id, err.Error())
+				srcConn = nil
+				continue
+			}
+			log.Warnf("DbSyncer[%d] Event:GetFakeSlaveOffsetReconn\tId:%s\t",
+				ds.id, conf.Options.Id)
+		}
+
 		slaveOffset, masterOffset, err := utils.GetFakeSlaveOffset(srcConn)
 		if err != nil {
 			// log.PurePrintf("%s\n", NewLogItem("GetFakeSlaveOffsetFail", "WARN", NewErrorLogDetail("", err.Error())))
 			log.Warnf("DbSyncer[%d] Event:GetFakeSlaveOffsetFail\tId:%s\tWarn:%s",
 				ds.id, conf.Options.Id, err.Error())
 
-			// Reconnect while network error happen
-			if err == io.EOF {
-				srcConn = utils.OpenRedisConnWithTimeout([]string{ds.source}, conf.Options.SourceAuthType,
-					ds.sourcePassword, incrSyncReadeTimeout, incrSyncReadeTimeout, false, conf.Options.SourceTLSEnable, conf.Options.SourceTLSSkipVerify)
-				log.Warnf("DbSyncer[%d] Event:GetFakeSlaveOffsetReconn\tId:%s\t",
-					ds.id, conf.Options.Id)
-			} else if _, ok := err.(net.Error); ok {
-				srcConn = utils.OpenRedisConnWithTimeout([]string{ds.source}, conf.Options.SourceAuthType,
-					ds.sourcePassword, incrSyncReadeTimeout, incrSyncReadeTimeout, false, conf.Options.SourceTLSEnable, conf.Options.SourceTLSSkipVerify)
-				log.Warnf("DbSyncer[%d] Event:GetFakeSlaveOffsetReconn\tId:%s\t",
-					ds.id, conf.Options.Id)
+			// Reconnect in the next round while network error happen
+			if utils.CheckHandleNetError(err) {
+				srcConn.Close()
+				srcConn = nil
 			}
 		} else {
 			// ds.SyncStat.SetOffset(offset)
@@ -104,6 +157,7 @@ func (ds *DbSyncer) fetchOffset() {
 					ds.id, conf.Options.Id, err.Error())
 			} else {
 				ds.stat.sourceMasterOffset.Set(sourceMasterOffset)
+				ds.offsetTracker.add(sourceMasterOffset, time.Now())
 			}
 
 			metric.GetMetric(ds.id).SetFakeSlaveDelayOffset(ds.id, uint64(sourceMasterOffset)-uint64(sourceOffset))
@@ -113,58 +167,110 @@ func (ds *DbSyncer) fetchOffset() {
 	log.Panicf("DbSyncer[%d] something wrong if you see me", ds.id)
 }
 
-func (ds *DbSyncer) receiveTargetReply(c redigo.Conn) {
-	var node *delayNode
+/*
+ * receive the replies of the given target connection. The receiver exits once the connection is broken, and
+ * then the writer reconnects the target and starts a new receiver.
+ */
+func (ds *DbSyncer) receiveTargetReply(w *cmdWriter, tc *targetConn) {
 	var recvId atomic2.Int64
+	var batch *sendBatch // the batch which the current reply belongs to
+	var received int64   // number of replies received in the current batch
+	var replies batchReplies
+
+	defer close(tc.exit)
 
 	for {
-		reply, err := c.Receive()
+		reply, err := tc.c.Receive()
 
-		recvId.Incr()
-		id := recvId.Get() // receive id
+		id := recvId.Incr() // receive id
 
 		// print debug log of receive reply
 		log.Debugf("DbSyncer[%d] receive reply-id[%v]: [%v], error:[%v]", ds.id, id, reply, err)
 
-		if conf.Options.Metric == false {
-			continue
+		// the error reply is returned as reply in cluster connection
+		if e, ok := reply.(error); ok && err == nil {
+			err = e
 		}
 
-		if err == nil {
-			metric.GetMetric(ds.id).AddSuccessCmdCount(ds.id, 1)
-		} else {
+		// "READONLY" is replied once the target master is switched to slave by the sentinel
+		if err != nil && (utils.CheckHandleNetError(err) || strings.HasPrefix(err.Error(), "READONLY")) {
 			metric.GetMetric(ds.id).AddFailCmdCount(ds.id, 1)
-			if utils.CheckHandleNetError(err) {
-				log.Panicf("DbSyncer[%d] Event:NetErrorWhileReceive\tId:%s\tError:%s",
-					ds.id, conf.Options.Id, err.Error())
-			} else {
-				log.Panicf("DbSyncer[%d] Event:ErrorReply\tId:%s\tCommand: [unknown]\tError: %s",
-					ds.id, conf.Options.Id, err.Error())
-			}
+			// the sender will reconnect and send the unacknowledged batches again
+			log.Warnf("DbSyncer[%d] Event:NetErrorWhileReceive\tId:%s\tError:%s",
+				ds.id, conf.Options.Id, err.Error())
+			tc.close()
+			return
 		}
 
-		if node == nil {
-			// non-blocking read from delay channel
-			select {
-			case node = <-ds.delayChannel:
-			default:
-				// it's ok, channel is empty
+		// the writer of the node can't follow the slot migration, restart to resume from the checkpoint
+		if err != nil && w.node != "" {
+			if class := utils.ErrorClass(err); class == "MOVED" || class == "ASK" {
+				log.Panicf("DbSyncer[%d] writer[%d] Event:SlotMoved\tId:%s\tthe slot distribution of the target "+
+					"changed[%v]", ds.id, w.id, conf.Options.Id, err)
 			}
 		}
 
-		// TODO, how to calculate the delay in transaction mode?
-		/*if node != nil {
-			if node.id == id {
-				metric.GetMetric(ds.id).AddDelay(uint64(time.Now().Sub(node.t).Nanoseconds()) / 1000000) // ms
-				node = nil
-			} else if node.id < id {
-				log.Panicf("DbSyncer[%d] receive id invalid: node-id[%v] < receive-id[%v]",
-					ds.id, node.id, id)
+		if conf.Options.Metric {
+			if err == nil {
+				metric.GetMetric(ds.id).AddSuccessCmdCount(ds.id, 1)
+			} else {
+				metric.GetMetric(ds.id).AddFailCmdCount(ds.id, 1)
 			}
-		}*/
+		}
+
+		if batch == nil {
+			batch = <-tc.batches
+			replies.reset()
+		}
+		replies.add(batch, received, reply, err)
+		if received++; received < batch.replies {
+			continue
+		}
+
+		// all the replies of the batch are received, handle the error replies by the policy
+		if resend, ok := ds.handleErrorReplies(batch, &replies); ok {
+			ds.stat.pendingCmds.Add(int64(len(resend) - len(batch.cmds)))
+			batch.cmds = resend
+			batch.resend = true
+			w.errorRetry.Incr()
+			log.Warnf("DbSyncer[%d] Event:ResendAfterErrorReply\tId:%s\tresend %d commands after reconnecting",
+				ds.id, conf.Options.Id, len(resend))
+			tc.close()
+			return
+		}
+
+		ds.ackBatch(w, batch, time.Now())
+		batch = nil
+		received = 0
 	}
+}
 
-	log.Panicf("DbSyncer[%d] something wrong if you see me", ds.id)
+// all the replies of the batch are received.
+func (ds *DbSyncer) ackBatch(w *cmdWriter, batch *sendBatch, now time.Time) {
+	w.errorRetry.Set(0)
+	w.ackedSeq.Set(batch.seq)
+	w.ackedOffset.Set(batch.offset)
+	if ds.enableResumeFromBreakPoint && !ds.checkpointStore.Transactional() {
+		w.setAckedCheckpoint(batch)
+	}
+	ds.stat.pendingCmds.Add(-int64(len(batch.cmds)))
+
+	if conf.Options.Metric {
+		// the delay of each command from reading from the source to applied by the target
+		m := metric.GetMetric(ds.id)
+		for i := range batch.cmds {
+			m.AddDelay(ds.id, now.Sub(batch.cmds[i].Time))
+		}
+	}
+}
+
+// push the command parsed to the dispatcher, it's spooled if the dispatcher falls behind.
+func (ds *DbSyncer) pushCommand(item cmdDetail) {
+	if ds.spool != nil {
+		ds.spool.push(item)
+		return
+	}
+	ds.sendBuf <- item
 }
 
 func (ds *DbSyncer) parseSourceCommand(reader *bufio.Reader) {
@@ -184,15 +290,17 @@ func (ds *DbSyncer) parseSourceCommand(reader *bufio.Reader) {
 	if ds.startDbId != 0 {
 		log.Infof("last dbid[%v] != 0, send 'select' first", ds.startDbId)
 		dbS := fmt.Sprintf("%d", ds.startDbId)
-		ds.sendBuf <- cmdDetail{
+		ds.pushCommand(cmdDetail{
 			Cmd:    "select",
 			Args:   []interface{}{utils.String2Bytes(dbS)},
 			Offset: ds.fullSyncOffset,
 			Db:     ds.startDbId,
-		}
+			Time:   time.Now(),
+		})
 	}
 
 	decoder := redis.NewDecoder(reader)
+	var parsedOffset int64 // offset after the last command parsed
 
 	log.Infof("DbSyncer[%d] FlushEvent:IncrSyncStart\tId:%s\t", ds.id, conf.Options.Id)
 
@@ -200,8 +308,64 @@ func (ds *DbSyncer) parseSourceCommand(reader *bufio.Reader) {
 		ignoresentinel := false
 		ignoreCmd := false
 		isSelect = false
+		// the error of the last command, e.g., errFullResync, is handled already
+		err = nil
 		// incrOffset is used to do resume from break-point job
-		resp, incrOffset := redis.MustDecodeOpt(decoder)
+		var resp redis.Resp
+		var incrOffset int64
+		if ds.local {
+			// skip the annotations like "#TS:..." of aof-timestamp-enabled in redis 7.0 just like loading the aof
+			if incrOffset, err = redis.SkipLines(decoder, '#'); err == nil {
+				parsedOffset = incrOffset
+			}
+		}
+		if err == nil {
+			resp, incrOffset, err = redis.DecodeOpt(decoder)
+		}
+		readTime := time.Now()
+		if err != nil {
+			if cause := errors.Cause(err); ds.local && (cause == io.EOF || cause == io.ErrUnexpectedEOF) {
+				// the decoder reads one byte of the next command at least, the last command is truncated if
+				// more bytes are read, it's ignored just like aof-load-truncated of redis
+				if incrOffset > parsedOffset+1 {
+					log.Warnf("DbSyncer[%d] the last command after offset[%v] is truncated, ignore it", ds.id,
+						parsedOffset)
+				}
+				log.Infof("DbSyncer[%d] all the commands until offset[%v] are parsed", ds.id, parsedOffset)
+				if ds.spool != nil {
+					ds.spool.wait()
+				}
+				close(ds.sendBuf)
+				return
+			}
+			if base.ShuttingDown() {
+				// the source connection is closed on shutdown, the sender stops after flushing all the commands
+				log.Infof("DbSyncer[%d] stop parsing on shutdown[%v]", ds.id, err)
+				if ds.spool != nil {
+					ds.spool.wait()
+				}
+				close(ds.sendBuf)
+				return
+			}
+			if errors.Cause(err) != errFullResync {
+				log.PanicErrorf(err, "DbSyncer[%d] decode redis resp failed", ds.id)
+			}
+
+			// source full resync, all the following data is read from the new reader
+			node := ds.fullResync(<-ds.resyncChan)
+			decoder = redis.NewDecoder(node.reader)
+			lastDb = -1
+			bypass = false
+			log.Infof("DbSyncer[%d] FlushEvent:IncrSyncStart\tId:%s\t", ds.id, conf.Options.Id)
+			continue
+		}
+		parsedOffset = incrOffset
+
+		if _, ok := resp.(*redis.Array); !ok {
+			// e.g., the push of RESP3, it isn't a command
+			log.Debugf("DbSyncer[%d] ignore the resp[%T] which isn't a command", ds.id, resp)
+			continue
+		}
 
 		if sCmd, argv, err = redis.ParseArgs(resp); err != nil {
 			log.PanicErrorf(err, "DbSyncer[%d] parse command arguments failed[%v]", ds.id, err)
@@ -223,6 +387,9 @@ func (ds *DbSyncer) parseSourceCommand(reader *bufio.Reader) {
 					selectDB = n
 				} else if filter.FilterCommands(sCmd) {
 					ignoreCmd = true
+				} else if sCmd == "replconf" {
+					// e.g., "REPLCONF GETACK *", the ack is sent to the source periodically
+					ignoreCmd = true
 				} else if strings.EqualFold(sCmd, "publish") && strings.EqualFold(string(argv[0]), "__sentinel__:hello") {
 					ignoresentinel = true
 				}
@@ -250,12 +417,13 @@ func (ds *DbSyncer) parseSourceCommand(reader *bufio.Reader) {
 				if conf.Options.TargetDB != lastDb {
 					lastDb = conf.Options.TargetDB
 					/* send select command. */
-					ds.sendBuf <- cmdDetail{
+					ds.pushCommand(cmdDetail{
 						Cmd:    "SELECT",
 						Args:   []interface{}{[]byte(strconv.FormatInt(int64(lastDb), 10))},
 						Offset: ds.fullSyncOffset + incrOffset,
 						Db:     lastDb,
-					}
+						Time:   readTime,
+					})
 				} else {
 					ds.stat.incrSyncFilter.Incr()
 					metric.GetMetric(ds.id).AddBypassCmdCount(ds.id, 1)
@@ -265,12 +433,13 @@ func (ds *DbSyncer) parseSourceCommand(reader *bufio.Reader) {
 				if tdb != lastDb {
 					lastDb = tdb
 					/* send select command. */
-					ds.sendBuf <- cmdDetail{
+					ds.pushCommand(cmdDetail{
 						Cmd:    "SELECT",
 						Args:   []interface{}{[]byte(strconv.FormatInt(int64(lastDb), 10))},
 						Offset: ds.fullSyncOffset + incrOffset,
 						Db:     lastDb,
-					}
+						Time:   readTime,
+					})
 				} else {
 					ds.stat.incrSyncFilter.Incr()
 					metric.GetMetric(ds.id).AddBypassCmdCount(ds.id, 1)
@@ -279,127 +448,226 @@ func (ds *DbSyncer) parseSourceCommand(reader *bufio.Reader) {
 			}
 		}
 
+		newArgv = rewriteCommand(sCmd, newArgv)
 		data := make([]interface{}, 0, len(newArgv))
 		for _, item := range newArgv {
 			data = append(data, item)
 		}
-		ds.sendBuf <- cmdDetail{
+		ds.pushCommand(cmdDetail{
 			Cmd:    sCmd,
 			Args:   data,
 			Offset: ds.fullSyncOffset + incrOffset,
 			Db:     lastDb,
-		}
+			Time:   readTime,
+		})
 	}
 
 	log.Panicf("DbSyncer[%d] something wrong if you see me", ds.id)
 }
 
-func (ds *DbSyncer) sendTargetCommand(c redigo.Conn) {
+/*
+ * full resync after the source answers "+FULLRESYNC" on reconnecting. All the commands parsed before
+ * should be applied on the target before loading the new rdb, otherwise the old command may overwrite the
+ * newer value. The node whose rdb is loaded is returned.
+ */
+func (ds *DbSyncer) fullResync(node *resyncNode) *resyncNode {
+	base.Status = "full"
+	if conf.Options.KeyExists == "none" {
+		log.Warnf("DbSyncer[%d] key_exists is none, full resync will panic once the key already exists in the target",
+			ds.id)
+	}
+
+	drained := make(chan struct{})
+	ds.pushCommand(cmdDetail{drained: drained})
+	<-drained
+	log.Infof("DbSyncer[%d] all the previous commands are applied, start full resync with runid[%v] offset[%v]",
+		ds.id, node.runId, node.offset)
+
+	node = ds.loadResyncRdb(node)
+	base.Status = "incr"
+	return node
+}
+
+/*
+ * load the rdb of the resync node, the rdb is loaded again from the next resync node if the source drops
+ * before the whole rdb is transferred. The node whose rdb is loaded is returned, the following commands
+ * are read from its reader.
+ */
+func (ds *DbSyncer) loadResyncRdb(node *resyncNode) *resyncNode {
+	for {
+		ds.offsetTracker.reset()
+		ds.stat.rBytes.Set(0)
+		ds.stat.keys.Set(0)
+		ds.stat.fullSyncFilter.Set(0)
+		if err := ds.syncRDBFile(node.reader, ds.targetInfo(), conf.Options.TargetAuthType, ds.targetPassword,
+			node.rdbSize, conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify); err == nil {
+			break
+		}
+		node = <-ds.resyncChan
+		log.Infof("DbSyncer[%d] load the rdb transferred again with runid[%v] offset[%v]", ds.id, node.runId,
+			node.offset)
+	}
+
+	ds.setRunId(node.runId, -1)
+	ds.fullSyncOffset = node.offset
+	ds.startDbId = 0
+	return node
+}
+
+func (ds *DbSyncer) sendTargetCommand(w *cmdWriter, tc *targetConn) {
 	var cachedCount uint
 	var cachedSize uint64
 	var sendId atomic2.Int64
 	var bs string       // barrier status
 	var flushStatus int // need a barrier?
+	var batchSeq int64  // sequence of the last batch
+	var inflight []*sendBatch
+	var curDb, flushedDb int  // db selected by the cached commands and the flushed commands
+	var lastOffset int64      // source offset of the last flushed command
+	var lastCheckpoint = true // whether the checkpoint of the last flushed command is written
+	var unsafeCached bool     // whether a non-idempotent command is cached
+	var txnCached bool        // whether the cached commands are a transaction of the source not finished
+	inTarget := ds.checkpointInTarget()
+	// keep the non-idempotent commands out of the commands replayed after restarting
+	isolate := ds.enableResumeFromBreakPoint && conf.Options.CheckpointConsistency == conf.CheckpointConsistencyReplay
+
+	// only the writer of the whole target follows the target switch of the sentinel
+	var targetSwitched chan struct{}
+	if w.node == "" {
+		targetSwitched = ds.targetSwitched
+	}
 
 	// cache the batch oplog
 	cachedTunnel := make([]cmdDetail, 0, conf.Options.SenderCount+1)
-	checkpointRunId := fmt.Sprintf("%s-%s", ds.source, utils.CheckpointRunId)
-	checkpointVersion := fmt.Sprintf("%s-%s", ds.source, utils.CheckpointVersion)
-	checkpointOffset := fmt.Sprintf("%s-%s", ds.source, utils.CheckpointOffset)
 	ticker := time.NewTicker(time.Duration(conf.Options.SenderTickerMs) * time.Millisecond)
+	// the checkpoint outside the target is saved periodically after the commands are acknowledged
+	var saveTicker <-chan time.Time
+	if ds.enableResumeFromBreakPoint && !inTarget {
+		t := time.NewTicker(checkpointSaveInterval)
+		defer t.Stop()
+		saveTicker = t.C
+	}
 	// mark whether the given db has already send runId, no need to send run-id each time.
 	runIdMap := make(map[int]struct{})
+	var lastSource, lastRunId string
+
+	/*
+	 * wait until all the flushed commands are acknowledged and then save the checkpoint, so the commands
+	 * flushed before won't be replayed after restarting.
+	 */
+	var waitFunc func()
+	isolateFunc := func() {
+		waitFunc()
+		for retry := 0; ds.saveCheckpoint(w) != nil; retry++ {
+			time.Sleep(reconnectInterval(retry))
+		}
+	}
 
-	// do send
-	sendFunc := func() {
+	// do send, the final batch before stopping is always with the checkpoint
+	sendFunc := func(final bool) {
 		length := len(cachedTunnel)
-		if length == 0 {
+		if length == 0 && (!final || !inTarget || lastCheckpoint) {
 			// do nothing
 			return
 		}
 
-		lastOplog := cachedTunnel[len(cachedTunnel)-1]
-		needBatch := true
-		if !ds.enableResumeFromBreakPoint || (cachedCount == 1 && lastOplog.Cmd == "ping") {
-			needBatch = false
+		// the source and run-id may change after the source failover, the checkpoint follows them
+		source, runId := ds.sourceInfo()
+		if source != lastSource || runId != lastRunId {
+			runIdMap = make(map[int]struct{})
+			lastSource, lastRunId = source, runId
 		}
 
-		var offset int64
-		// enable resume from break point
-		if needBatch {
-			ds.addSendId(&sendId, 1)
-
-			// the last offset
-			offset = lastOplog.Offset
-			if err := c.Send("multi"); err != nil {
-				log.Panicf("DbSyncer[%d] Event:SendToTargetFail\tId:%s\tError:%s\t",
-					ds.id, conf.Options.Id, err.Error())
-			}
+		batchSeq++
+		batch := &sendBatch{
+			seq:        batchSeq,
+			source:     source,
+			runId:      runId,
+			cmds:       make([]cmdDetail, length),
+			offset:     lastOffset,
+			checkpoint: inTarget,
+			atomic:     txnCached && length > 0,
+			startDb:    flushedDb,
+			endDb:      curDb,
 		}
-
-		ds.addSendId(&sendId, len(cachedTunnel))
-		for _, cacheItem := range cachedTunnel {
-			if err := c.Send(cacheItem.Cmd, cacheItem.Args...); err != nil {
-				log.Panicf("DbSyncer[%d] Event:SendToTargetFail\tId:%s\tError:%s\t",
-					ds.id, conf.Options.Id, err.Error())
-			}
-
-			// print debug log of send command
-			if conf.Options.LogLevel == utils.LogLevelDebug {
-				strArgv := make([]string, len(cacheItem.Args))
-				for i, ele := range cacheItem.Args {
-					eleB := ele.([]byte)
-					strArgv[i] = *(*string)(unsafe.Pointer(&eleB))
-					// strArgv[i] = string(ele.([]byte))
-				}
-				log.Debugf("DbSyncer[%d] send command[%v]: [%s %v]", ds.id, sendId.Get(), cacheItem.Cmd,
-					strArgv)
+		txnCached = false
+		copy(batch.cmds, cachedTunnel)
+		if length > 0 {
+			lastOplog := cachedTunnel[length-1]
+			batch.offset = lastOplog.Offset
+			// the writer of the node advances its checkpoint by the ping even if it's idle
+			if cachedCount == 1 && lastOplog.Cmd == "ping" && !final && w.node == "" {
+				batch.checkpoint = false
 			}
 		}
-
-		if needBatch {
-			// need send run-id?
-			if _, ok := runIdMap[lastOplog.Db]; !ok {
-				runIdMap[lastOplog.Db] = struct{}{}
-				ds.addSendId(&sendId, 2)
-				// run id
-				if err := c.Send("hset", ds.checkpointName, checkpointRunId, ds.runId); err != nil {
-					log.Panicf("DbSyncer[%d] Event:SendToTargetFail\tId:%s\tError:%s\t",
-						ds.id, conf.Options.Id, err.Error())
-				}
-				// version
-				if err := c.Send("hset", ds.checkpointName, checkpointVersion, utils.FcvCheckpoint.CurrentVersion); err != nil {
-					log.Panicf("DbSyncer[%d] Event:SendToTargetFail\tId:%s\tError:%s\t",
-						ds.id, conf.Options.Id, err.Error())
-				}
-			}
-
-			// add checkpoint
-			ds.addSendId(&sendId, 2)
-			if err := c.Send("hset", ds.checkpointName, checkpointOffset, offset); err != nil {
-				log.Panicf("DbSyncer[%d] Event:SendToTargetFail\tId:%s\tError:%s\t",
-					ds.id, conf.Options.Id, err.Error())
-			}
-			if err := c.Send("exec"); err != nil {
-				log.Panicf("DbSyncer[%d] Event:SendToTargetFail\tId:%s\tError:%s\t",
-					ds.id, conf.Options.Id, err.Error())
-			}
+		// need send run-id? the checkpoint is written into the end db
+		if _, ok := runIdMap[batch.endDb]; !ok && batch.checkpoint {
+			runIdMap[batch.endDb] = struct{}{}
+			batch.withRunId = true
 		}
-
-		if err := c.Flush(); err != nil {
-			log.Panicf("DbSyncer[%d] Event:FlushFail\tId:%s\tError:%s\t",
-				ds.id, conf.Options.Id, err.Error())
+		flushedDb = curDb
+		lastOffset, lastCheckpoint = batch.offset, batch.checkpoint
+		ds.stat.pendingCmds.Add(int64(length))
+
+		inflight = append(w.pruneBatches(inflight), batch)
+		if err := ds.sendBatch(w, tc, batch, &sendId); err != nil {
+			log.Warnf("DbSyncer[%d] writer[%d] Event:SendToTargetFail\tId:%s\tError:%s\t",
+				ds.id, w.id, conf.Options.Id, err.Error())
+			tc, inflight = ds.reconnectTarget(w, tc, inflight, flushedDb, &sendId)
 		}
 
 		// clear
 		cachedTunnel = cachedTunnel[:0]
 		cachedCount = 0
 		cachedSize = 0
+
+		// the non-idempotent commands are only replayed if it's restarted before saving the checkpoint
+		if unsafeCached {
+			isolateFunc()
+			unsafeCached = false
+		}
+	}
+
+	// wait until all the flushed commands are applied by the target
+	waitFunc = func() {
+		for w.ackedSeq.Get() < batchSeq {
+			select {
+			case <-tc.broken:
+				tc, inflight = ds.reconnectTarget(w, tc, inflight, flushedDb, &sendId)
+			case <-targetSwitched:
+				tc, inflight = ds.reconnectTarget(w, tc, inflight, flushedDb, &sendId)
+			case <-ticker.C:
+			}
+		}
 	}
 
 	for {
 		select {
-		case item := <-ds.sendBuf:
+		case item, ok := <-w.sendBuf:
+			if !ok {
+				// the sync stops, the unfinished transaction is sent as it is
+				txnCached = false
+				sendFunc(true)
+				waitFunc()
+				if saveTicker != nil {
+					ds.saveCheckpoint(w)
+				}
+				log.Infof("DbSyncer[%d] writer[%d] stops at offset[%v]", ds.id, w.id, lastOffset)
+				close(w.stopped)
+				return
+			}
+			if item.drained != nil {
+				// flush all the previous commands and drop the unfinished transaction
+				txnCached = false
+				sendFunc(false)
+				bs = barrierStatusNo
+				// the run-id may change after full resync
+				runIdMap = make(map[int]struct{})
+				waitFunc()
+				close(item.drained)
+				continue
+			}
+
 			length := len(item.Cmd)
 			for i := range item.Args {
 				length += len(item.Args[i].([]byte))
@@ -409,16 +677,32 @@ func (ds *DbSyncer) sendTargetCommand(c redigo.Conn) {
 			log.Debugf("DbSyncer[%d] command[%s] with barrier status[%v] and flush status[%v]",
 				ds.id, item.Cmd, bs, flushStatus)
 			if flushStatus == flushStatusYes {
-				// flush previous data
-				sendFunc()
+				// flush previous data, it's the whole transaction if "exec"
+				sendFunc(false)
 				flushStatus = flushStatusNo
 			}
+			if bs == barrierStatusHoldStart && conf.Options.SenderTransaction {
+				// the commands until "exec" are sent in one transaction
+				txnCached = true
+			} else if bs == barrierStatusHoldEnd {
+				// the transaction may be empty
+				txnCached = false
+			}
+
+			// the commands flushed before are not replayed with the non-idempotent command
+			if isolate && !unsafeCached && isNonIdempotent(item) {
+				isolateFunc()
+				unsafeCached = true
+			}
 
 			// remove command when bs == barrierStatusHoldStart or barrierStatusHoldEnd
 			if bs != barrierStatusHoldStart && bs != barrierStatusHoldEnd {
 				cachedTunnel = append(cachedTunnel, item)
 				cachedCount++
 				cachedSize += uint64(length)
+				if db, ok := selectedDb(item); ok {
+					curDb = db
+				}
 
 				// update metric
 				ds.stat.wCommands.Incr()
@@ -428,21 +712,307 @@ func (ds *DbSyncer) sendTargetCommand(c redigo.Conn) {
 			}
 
 		case <-ticker.C:
-			if len(ds.sendBuf) == 0 && len(cachedTunnel) > 0 {
+			if len(w.sendBuf) == 0 && len(cachedTunnel) > 0 {
 				flushStatus = flushStatusYes
 			} else {
 				flushStatus = flushStatusNo
 			}
+
+		case <-saveTicker:
+			ds.saveCheckpoint(w)
+			continue
+
+		case <-tc.broken:
+			// broken while receiving
+			tc, inflight = ds.reconnectTarget(w, tc, inflight, flushedDb, &sendId)
+			continue
+
+		case <-targetSwitched:
+			// the target is switched by the sentinel
+			tc, inflight = ds.reconnectTarget(w, tc, inflight, flushedDb, &sendId)
+			continue
 		}
 
+		if txnCached {
+			// never split the transaction
+			continue
+		}
 		if cachedCount < conf.Options.SenderCount && cachedSize < conf.Options.SenderSize && flushStatus == flushStatusNo {
 			// do not flush
 			continue
 		}
 
 		// flush cache
-		sendFunc()
+		sendFunc(false)
 	}
 
 	log.Warnf("DbSyncer[%d] sender exit", ds.id)
 }
+
+// send the batch to the target, the batch is pushed into the connection before sending so that the
+// receiver is able to count the replies.
+func (ds *DbSyncer) sendBatch(w *cmdWriter, tc *targetConn, batch *sendBatch, sendId *atomic2.Int64) error {
+	c := tc.c
+	batch.replies = int64(len(batch.cmds))
+	if batch.checkpoint {
+		// multi, hset offset, exec
+		batch.replies += 3
+		if batch.withRunId {
+			batch.replies += 2
+		}
+	} else if batch.atomic {
+		// multi, exec
+		batch.replies += 2
+	}
+
+	select {
+	case tc.batches <- batch:
+	case <-tc.broken:
+		return fmt.Errorf("target connection is broken")
+	}
+
+	// enable resume from break point, or the transaction of the source
+	if batch.wrapped() {
+		sendId.Incr()
+		if err := c.Send("multi"); err != nil {
+			return err
+		}
+	}
+
+	for _, cacheItem := range batch.cmds {
+		sendId.Incr()
+		if err := c.Send(cacheItem.Cmd, cacheItem.Args...); err != nil {
+			return err
+		}
+
+		// print debug log of send command
+		if conf.Options.LogLevel == utils.LogLevelDebug {
+			strArgv := make([]string, len(cacheItem.Args))
+			for i, ele := range cacheItem.Args {
+				eleB := ele.([]byte)
+				strArgv[i] = *(*string)(unsafe.Pointer(&eleB))
+				// strArgv[i] = string(ele.([]byte))
+			}
+			log.Debugf("DbSyncer[%d] send command[%v]: [%s %v]", ds.id, sendId.Get(), cacheItem.Cmd,
+				strArgv)
+		}
+	}
+
+	if batch.checkpoint {
+		if batch.withRunId {
+			sendId.Add(2)
+			// run id
+			if err := c.Send("hset", w.checkpointName, checkpointField(batch.source, utils.CheckpointRunId),
+				batch.runId); err != nil {
+				return err
+			}
+			// version
+			if err := c.Send("hset", w.checkpointName, checkpointField(batch.source, utils.CheckpointVersion),
+				utils.FcvCheckpoint.CurrentVersion); err != nil {
+				return err
+			}
+		}
+
+		// add checkpoint
+		sendId.Incr()
+		if err := c.Send("hset", w.checkpointName, checkpointField(batch.source, utils.CheckpointOffset),
+			batch.offset); err != nil {
+			return err
+		}
+	}
+	if batch.wrapped() {
+		sendId.Incr()
+		if err := c.Send("exec"); err != nil {
+			return err
+		}
+	}
+
+	return c.Flush()
+}
+
+// drop the batches whose replies are all received.
+func (w *cmdWriter) pruneBatches(batches []*sendBatch) []*sendBatch {
+	acked := w.ackedSeq.Get()
+	i := 0
+	for i < len(batches) && batches[i].seq <= acked {
+		i++
+	}
+	return batches[i:]
+}
+
+/*
+ * reconnect the target after the connection broken, and then send the unacknowledged batches again. If
+ * resume from break point is enabled, the batches already applied by the target are skipped according to
+ * the checkpoint offset, otherwise the batches are sent at least once. db is the db selected by the last
+ * flushed batch, the commands cached in the sender are based on it.
+ * The batch resent after error replies is the first one, the later batches are held until it's acknowledged,
+ * so the commands retried never overwrite the newer values written by the later batches.
+ */
+func (ds *DbSyncer) reconnectTarget(w *cmdWriter, tc *targetConn, inflight []*sendBatch, db int,
+	sendId *atomic2.Int64) (*targetConn, []*sendBatch) {
+	tc.close()
+	<-tc.exit
+	inflight = w.pruneBatches(inflight)
+
+	base.Status = "reopen"
+	log.Warnf("DbSyncer[%d] writer[%d] Event:TargetConnBroken\tId:%s\tunacknowledged batches: %d",
+		ds.id, w.id, conf.Options.Id, len(inflight))
+	if !ds.checkpointInTarget() && len(inflight) > 0 {
+		log.Warnf("DbSyncer[%d] the checkpoint isn't written into the target, the unacknowledged batches may "+
+			"be applied twice", ds.id)
+	}
+
+	isCluster := conf.Options.TargetType == conf.RedisTypeCluster
+	// back off more if the batch is resent after error replies again and again
+	for retry := int(w.errorRetry.Get()); ; retry++ {
+		time.Sleep(reconnectInterval(retry))
+
+		// the writer of the node connects the node only
+		target, clusterConn := ds.targetInfo(), isCluster
+		if w.node != "" {
+			target, clusterConn = []string{w.node}, false
+		}
+		c, err := utils.TryOpenRedisConnWithTimeout(target, conf.Options.TargetAuthType, ds.targetPassword,
+			incrSyncReadeTimeout, incrSyncReadeTimeout, clusterConn, conf.Options.TargetTLSEnable,
+			conf.Options.TargetTLSSkipVerify)
+		if err != nil {
+			log.Errorf("DbSyncer[%d] Event:TargetConnReopenFail\tId:%s\tretry:%d\tError:%v",
+				ds.id, conf.Options.Id, retry, err)
+			continue
+		}
+
+		// no receiver is running on the new connection, so it's safe to call "Do"
+		if inflight, err = ds.skipAppliedBatches(w, c, inflight, isCluster); err != nil {
+			log.Errorf("DbSyncer[%d] Event:TargetConnReopenFail\tId:%s\tretry:%d\tError:%v",
+				ds.id, conf.Options.Id, retry, err)
+			c.Close()
+			continue
+		}
+
+		selectDb := db
+		if len(inflight) > 0 {
+			selectDb = inflight[0].startDb
+		}
+		if !isCluster && selectDb != 0 {
+			if _, err := c.Do("select", selectDb); err != nil {
+				log.Errorf("DbSyncer[%d] Event:TargetConnReopenFail\tId:%s\tretry:%d\tError:%v",
+					ds.id, conf.Options.Id, retry, err)
+				c.Close()
+				continue
+			}
+		}
+
+		newTc := newTargetConn(c)
+		go ds.receiveTargetReply(w, newTc)
+		for i, batch := range inflight {
+			if err = ds.sendBatch(w, newTc, batch, sendId); err != nil {
+				break
+			}
+			if i == 0 && batch.resend {
+				if err = w.waitAcked(newTc, batch.seq); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			log.Errorf("DbSyncer[%d] Event:TargetConnReopenFail\tId:%s\tretry:%d\tError:%v",
+				ds.id, conf.Options.Id, retry, err)
+			newTc.close()
+			<-newTc.exit
+			inflight = w.pruneBatches(inflight)
+			continue
+		}
+
+		log.Infof("DbSyncer[%d] Event:TargetConnReopenSuccess\tId:%s\tretry:%d\tresend batches: %d",
+			ds.id, conf.Options.Id, retry, len(inflight))
+		base.Status = "incr"
+		return newTc, inflight
+	}
+}
+
+// skip the batches already applied by the target, the offset is read from the checkpoint.
+func (ds *DbSyncer) skipAppliedBatches(w *cmdWriter, c redigo.Conn, inflight []*sendBatch,
+	isCluster bool) ([]*sendBatch, error) {
+	if !ds.checkpointInTarget() || len(inflight) == 0 {
+		return inflight, nil
+	}
+
+	// the checkpoint of a batch is written into its end db
+	appliedOffset := int64(-1)
+	dbs := make(map[int]struct{})
+	for _, batch := range inflight {
+		if _, ok := dbs[batch.endDb]; ok || !batch.checkpoint {
+			continue
+		}
+		dbs[batch.endDb] = struct{}{}
+
+		if !isCluster {
+			if _, err := c.Do("select", batch.endDb); err != nil {
+				return nil, fmt.Errorf("select db[%v] failed[%v]", batch.endDb, err)
+			}
+		}
+		reply, err := c.Do("hget", w.checkpointName, checkpointField(batch.source, utils.CheckpointOffset))
+		if err != nil {
+			return nil, fmt.Errorf("get checkpoint offset in db[%v] failed[%v]", batch.endDb, err)
+		} else if reply == nil {
+			continue
+		}
+		offset, err := redigo.Int64(reply, err)
+		if err != nil {
+			return nil, fmt.Errorf("parse checkpoint offset in db[%v] failed[%v]", batch.endDb, err)
+		}
+		if offset > appliedOffset {
+			appliedOffset = offset
+		}
+	}
+	if !isCluster && len(dbs) > 0 {
+		// reset to the default db, the caller selects the right one later
+		if _, err := c.Do("select", 0); err != nil {
+			return nil, fmt.Errorf("select db[0] failed[%v]", err)
+		}
+	}
+
+	/*
+	 * only the leading batches are skipped. The batch resent after error replies is applied partially, the
+	 * left commands are always sent, and the later batches are sent again after it even if they are applied,
+	 * otherwise the commands retried may overwrite the newer values.
+	 */
+	i := 0
+	for ; i < len(inflight) && !inflight[i].resend && inflight[i].offset <= appliedOffset; i++ {
+		ds.ackBatch(w, inflight[i], time.Now())
+	}
+	log.Infof("DbSyncer[%d] writer[%d] target checkpoint offset[%v], skip %d applied batches", ds.id, w.id,
+		appliedOffset, i)
+	return inflight[i:], nil
+}
+
+// wait until the batch with the given sequence is acknowledged, error is returned if the connection is broken.
+func (w *cmdWriter) waitAcked(tc *targetConn, seq int64) error {
+	ticker := time.NewTicker(waitAckedInterval)
+	defer ticker.Stop()
+	for w.ackedSeq.Get() < seq {
+		select {
+		case <-tc.broken:
+			return fmt.Errorf("target connection is broken before the batch[%v] is acknowledged", seq)
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// the checkpoint field of the given source, e.g., "10.1.1.1:6379-offset"
+func checkpointField(source, name string) string {
+	return fmt.Sprintf("%s-%s", source, name)
+}
+
+// return the db if the command is "select".
+func selectedDb(item cmdDetail) (int, bool) {
+	if !strings.EqualFold(item.Cmd, "select") || len(item.Args) != 1 {
+		return 0, false
+	}
+	db, err := strconv.Atoi(string(item.Args[0].([]byte)))
+	if err != nil {
+		return 0, false
+	}
+	return db, true
+}
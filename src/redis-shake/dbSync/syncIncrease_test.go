@@ -1,8 +1,18 @@
 package dbSync
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"net"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/alibaba/RedisShake/pkg/libs/io/pipe"
+	"github.com/alibaba/RedisShake/pkg/rdb"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+	"github.com/alibaba/RedisShake/redis-shake/metric"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -47,3 +57,399 @@ func TestBarrierStatus(t *testing.T) {
 		assert.Equal(t, flushStatusNo, flushStatus, "should be equal")
 	}
 }
+
+func TestReconnectInterval(t *testing.T) {
+	// test reconnectInterval
+
+	var nr int
+
+	{
+		fmt.Printf("TestReconnectInterval case %d.\n", nr)
+		nr++
+
+		assert.Equal(t, reconnectMinInterval, reconnectInterval(0), "should be equal")
+		assert.Equal(t, 2*reconnectMinInterval, reconnectInterval(1), "should be equal")
+		assert.Equal(t, 4*reconnectMinInterval, reconnectInterval(2), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestReconnectInterval case %d.\n", nr)
+		nr++
+
+		assert.Equal(t, reconnectMaxInterval, reconnectInterval(10), "should be equal")
+		assert.Equal(t, reconnectMaxInterval, reconnectInterval(1000), "should be equal")
+	}
+}
+
+func TestPruneBatches(t *testing.T) {
+	// test pruneBatches
+
+	var nr int
+
+	{
+		fmt.Printf("TestPruneBatches case %d.\n", nr)
+		nr++
+
+		w := new(cmdWriter)
+		batches := []*sendBatch{{seq: 1}, {seq: 2}, {seq: 3}}
+		assert.Equal(t, 3, len(w.pruneBatches(batches)), "should be equal")
+
+		w.ackedSeq.Set(2)
+		left := w.pruneBatches(batches)
+		assert.Equal(t, 1, len(left), "should be equal")
+		assert.Equal(t, int64(3), left[0].seq, "should be equal")
+
+		w.ackedSeq.Set(3)
+		assert.Equal(t, 0, len(w.pruneBatches(batches)), "should be equal")
+	}
+}
+
+func TestWaitAcked(t *testing.T) {
+	// test waitAcked
+
+	var nr int
+
+	{
+		fmt.Printf("TestWaitAcked case %d.\n", nr)
+		nr++
+
+		w := new(cmdWriter)
+		tc := newTargetConn(nil)
+		go func() {
+			time.Sleep(3 * waitAckedInterval)
+			w.ackedSeq.Set(2)
+		}()
+		assert.Equal(t, nil, w.waitAcked(tc, 2), "should be equal")
+		assert.Equal(t, nil, w.waitAcked(tc, 1), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestWaitAcked case %d.\n", nr)
+		nr++
+
+		// the later batches are resent on the next connection
+		w := new(cmdWriter)
+		tc := &targetConn{broken: make(chan struct{})}
+		close(tc.broken)
+		assert.NotEqual(t, nil, w.waitAcked(tc, 1), "should be not equal")
+	}
+}
+
+func TestSelectedDb(t *testing.T) {
+	// test selectedDb
+
+	var nr int
+
+	{
+		fmt.Printf("TestSelectedDb case %d.\n", nr)
+		nr++
+
+		db, ok := selectedDb(cmdDetail{Cmd: "SELECT", Args: []interface{}{[]byte("5")}})
+		assert.Equal(t, true, ok, "should be equal")
+		assert.Equal(t, 5, db, "should be equal")
+
+		_, ok = selectedDb(cmdDetail{Cmd: "set", Args: []interface{}{[]byte("a"), []byte("b")}})
+		assert.Equal(t, false, ok, "should be equal")
+	}
+}
+
+func TestOffsetTracker(t *testing.T) {
+	// test offsetTracker
+
+	var nr int
+
+	{
+		fmt.Printf("TestOffsetTracker case %d.\n", nr)
+		nr++
+
+		var ot offsetTracker
+		now := time.Now()
+		assert.Equal(t, time.Duration(0), ot.lag(0, now), "should be equal")
+
+		ot.add(100, now.Add(-3*time.Second))
+		ot.add(200, now.Add(-2*time.Second))
+		ot.add(150, now.Add(-2*time.Second)) // ignore the smaller offset
+		ot.add(300, now.Add(-1*time.Second))
+		assert.Equal(t, 3, len(ot.samples), "should be equal")
+
+		// the source master went beyond 120 at the sample of 200
+		assert.Equal(t, 2*time.Second, ot.lag(120, now), "should be equal")
+		assert.Equal(t, 1*time.Second, ot.lag(200, now), "should be equal")
+		assert.Equal(t, time.Duration(0), ot.lag(300, now), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestOffsetTracker case %d.\n", nr)
+		nr++
+
+		var ot offsetTracker
+		now := time.Now()
+		for i := 0; i < maxOffsetSamples+10; i++ {
+			ot.add(int64(i+1), now)
+		}
+		assert.Equal(t, maxOffsetSamples, len(ot.samples), "should be equal")
+		assert.Equal(t, int64(11), ot.samples[0].offset, "should be equal")
+
+		ot.reset()
+		assert.Equal(t, 0, len(ot.samples), "should be equal")
+	}
+}
+
+func TestRewriteCommand(t *testing.T) {
+	// test rewriteCommand
+
+	var nr int
+
+	defer func() {
+		conf.Options.FunctionExists = ""
+		conf.Options.ShiftTime = 0
+	}()
+	args := func(argv ...string) [][]byte {
+		ret := make([][]byte, 0, len(argv))
+		for _, arg := range argv {
+			ret = append(ret, []byte(arg))
+		}
+		return ret
+	}
+
+	{
+		fmt.Printf("TestRewriteCommand case %d.\n", nr)
+		nr++
+
+		// not changed by default
+		assert.Equal(t, args("load", "code"), rewriteCommand("function", args("load", "code")), "should be equal")
+		assert.Equal(t, args("a", "1000"), rewriteCommand("pexpireat", args("a", "1000")), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestRewriteCommand case %d.\n", nr)
+		nr++
+
+		conf.Options.FunctionExists = "replace"
+		assert.Equal(t, args("load", "REPLACE", "code"), rewriteCommand("function", args("load", "code")),
+			"should be equal")
+		assert.Equal(t, args("LOAD", "replace", "code"), rewriteCommand("function", args("LOAD", "replace", "code")),
+			"should be equal")
+		assert.Equal(t, args("delete", "lib"), rewriteCommand("function", args("delete", "lib")), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestRewriteCommand case %d.\n", nr)
+		nr++
+
+		// the clock of the source is 10s later than the target
+		conf.Options.ShiftTime = 10 * time.Second
+		assert.Equal(t, args("a", "90000"), rewriteCommand("pexpireat", args("a", "100000")), "should be equal")
+		assert.Equal(t, args("a", "90"), rewriteCommand("expireat", args("a", "100")), "should be equal")
+		assert.Equal(t, args("h", "90000", "FIELDS", "1", "f"),
+			rewriteCommand("hpexpireat", args("h", "100000", "FIELDS", "1", "f")), "should be equal")
+		assert.Equal(t, args("a", "1", "PXAT", "90000", "NX"),
+			rewriteCommand("set", args("a", "1", "PXAT", "100000", "NX")), "should be equal")
+		assert.Equal(t, args("a", "exat", "90"), rewriteCommand("getex", args("a", "exat", "100")),
+			"should be equal")
+		assert.Equal(t, args("a", "90000", "v", "ABSTTL"), rewriteCommand("restore", args("a", "100000", "v", "ABSTTL")),
+			"should be equal")
+		assert.Equal(t, args("a", "100000", "v"), rewriteCommand("restore", args("a", "100000", "v")),
+			"should be equal")
+		assert.Equal(t, args("a", "0", "v", "ABSTTL"), rewriteCommand("restore", args("a", "0", "v", "ABSTTL")),
+			"should be equal")
+		assert.Equal(t, args("a", "x"), rewriteCommand("pexpireat", args("a", "x")), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestRewriteCommand case %d.\n", nr)
+		nr++
+
+		// the value of "set" looks like an option
+		conf.Options.ShiftTime = 10 * time.Second
+		assert.Equal(t, args("a", "PXAT", "100000"), rewriteCommand("set", args("a", "PXAT", "100000")),
+			"should be equal")
+		assert.Equal(t, args("a", "exat", "EX", "100"), rewriteCommand("set", args("a", "exat", "EX", "100")),
+			"should be equal")
+		assert.Equal(t, args("a", "KEEPTTL", "PXAT", "90000"),
+			rewriteCommand("set", args("a", "KEEPTTL", "PXAT", "100000")), "should be equal")
+		assert.Equal(t, args("a", "EXAT", "90"), rewriteCommand("getex", args("a", "EXAT", "100")), "should be equal")
+	}
+}
+
+func TestIsNonIdempotent(t *testing.T) {
+	// test isNonIdempotent
+
+	var nr int
+
+	{
+		fmt.Printf("TestIsNonIdempotent case %d.\n", nr)
+		nr++
+
+		assert.Equal(t, true, isNonIdempotent(newTestCmd("incr", "a")), "should be equal")
+		assert.Equal(t, false, isNonIdempotent(newTestCmd("set", "a", "1")), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestIsNonIdempotent case %d.\n", nr)
+		nr++
+
+		// zpopmin and zpopmax
+		assert.Equal(t, true, isNonIdempotent(newTestCmd("zpopmin", "z")), "should be equal")
+		assert.Equal(t, true, isNonIdempotent(newTestCmd("zpopmax", "z", "2")), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestIsNonIdempotent case %d.\n", nr)
+		nr++
+
+		// zadd is a counter with INCR
+		assert.Equal(t, true, isNonIdempotent(newTestCmd("zadd", "z", "INCR", "1", "m")), "should be equal")
+		assert.Equal(t, true, isNonIdempotent(newTestCmd("zadd", "z", "xx", "ch", "incr", "1", "m")), "should be equal")
+		assert.Equal(t, false, isNonIdempotent(newTestCmd("zadd", "z", "1", "m")), "should be equal")
+		assert.Equal(t, false, isNonIdempotent(newTestCmd("zadd", "z", "NX", "1", "incr")), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestIsNonIdempotent case %d.\n", nr)
+		nr++
+
+		// restore fails if the key exists without REPLACE
+		assert.Equal(t, true, isNonIdempotent(newTestCmd("restore", "a", "0", "v")), "should be equal")
+		assert.Equal(t, true, isNonIdempotent(newTestCmd("restore", "a", "0", "v", "ABSTTL")), "should be equal")
+		assert.Equal(t, false, isNonIdempotent(newTestCmd("restore", "a", "0", "v", "REPLACE")), "should be equal")
+		assert.Equal(t, false, isNonIdempotent(newTestCmd("restore", "a", "0", "v", "IDLETIME", "1", "replace")),
+			"should be equal")
+	}
+}
+
+func TestParseLocalCommand(t *testing.T) {
+	// test parseSourceCommand with the commands read from the local file
+
+	var nr int
+
+	defer func() {
+		conf.Options.TargetDB = 0
+	}()
+	conf.Options.TargetDB = -1
+	metric.AddMetric(0)
+
+	parse := func(input string) []cmdDetail {
+		ds := &DbSyncer{local: true, sendBuf: make(chan cmdDetail, 16)}
+		ds.parseSourceCommand(bufio.NewReader(strings.NewReader(input)))
+		var ret []cmdDetail
+		for item := range ds.sendBuf {
+			ret = append(ret, item)
+		}
+		return ret
+	}
+	commands := "*2\r\n$6\r\nSELECT\r\n$1\r\n1\r\n*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n"
+
+	{
+		fmt.Printf("TestParseLocalCommand case %d.\n", nr)
+		nr++
+
+		// the parser stops at the end of the file
+		items := parse(commands)
+		assert.Equal(t, 2, len(items), "should be equal")
+		assert.Equal(t, "select", items[0].Cmd, "should be equal")
+		assert.Equal(t, "set", items[1].Cmd, "should be equal")
+		assert.Equal(t, []interface{}{[]byte("a"), []byte("1")}, items[1].Args, "should be equal")
+		assert.Equal(t, int64(len(commands)), items[1].Offset, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestParseLocalCommand case %d.\n", nr)
+		nr++
+
+		// the truncated command is ignored
+		items := parse(commands + "*3\r\n$3\r\nset\r\n$1\r\nb")
+		assert.Equal(t, 2, len(items), "should be equal")
+		assert.Equal(t, "set", items[1].Cmd, "should be equal")
+		assert.Equal(t, int64(len(commands)), items[1].Offset, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestParseLocalCommand case %d.\n", nr)
+		nr++
+
+		// the incr aof with the annotations of aof-timestamp-enabled
+		annotation := "#TS:1628217470\r\n"
+		input := annotation + commands + annotation + "*2\r\n$3\r\ndel\r\n$1\r\na\r\n" + annotation
+		items := parse(input)
+		assert.Equal(t, 3, len(items), "should be equal")
+		assert.Equal(t, "select", items[0].Cmd, "should be equal")
+		assert.Equal(t, int64(len(annotation+commands)), items[1].Offset, "should be equal")
+		assert.Equal(t, "del", items[2].Cmd, "should be equal")
+		assert.Equal(t, []interface{}{[]byte("a")}, items[2].Args, "should be equal")
+		assert.Equal(t, int64(len(input)-len(annotation)), items[2].Offset, "should be equal")
+	}
+}
+
+func TestParseFullResync(t *testing.T) {
+	// test parseSourceCommand continues parsing the commands after the full resync
+
+	var nr int
+
+	defer func() {
+		conf.Options.TargetDB = 0
+		conf.Options.Parallel = 0
+	}()
+	conf.Options.TargetDB = -1
+	conf.Options.Parallel = 1
+	metric.AddMetric(0)
+
+	// the target of the rdb which is empty
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err, "should be equal")
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	var b bytes.Buffer
+	assert.Equal(t, nil, rdb.NewWriter(&b, nil).Close(), "should be equal")
+
+	{
+		fmt.Printf("TestParseFullResync case %d.\n", nr)
+		nr++
+
+		ds := &DbSyncer{
+			target:     []string{ln.Addr().String()},
+			sendBuf:    make(chan cmdDetail, 16),
+			resyncChan: make(chan *resyncNode, 1),
+		}
+		r, w := pipe.NewSize(1024)
+		go ds.parseSourceCommand(bufio.NewReader(r))
+
+		// the source full resyncs after reconnecting
+		w.Write([]byte("*3\r\n$3\r\nset\r\n$1\r\na\r\n$1\r\n1\r\n"))
+		w.CloseWithError(errFullResync)
+		// the new source is never closed, or the parsing panics with EOF
+		r2, w2 := pipe.NewSize(1024)
+		ds.resyncChan <- &resyncNode{reader: bufio.NewReader(r2), rdbSize: int64(b.Len()), runId: "abc", offset: 100}
+		w2.Write(b.Bytes())
+		w2.Write([]byte("*3\r\n$3\r\nset\r\n$1\r\nb\r\n$1\r\n2\r\n*2\r\n$3\r\ndel\r\n$1\r\na\r\n"))
+
+		var items []cmdDetail
+		timeout := time.After(10 * time.Second)
+		for len(items) < 3 {
+			select {
+			case item := <-ds.sendBuf:
+				if item.drained != nil {
+					// all the commands before the full resync are applied
+					close(item.drained)
+					continue
+				}
+				items = append(items, item)
+			case <-timeout:
+				t.Fatalf("only %d commands are parsed", len(items))
+			}
+		}
+		assert.Equal(t, "set", items[0].Cmd, "should be equal")
+		assert.Equal(t, "set", items[1].Cmd, "should be equal")
+		assert.Equal(t, []interface{}{[]byte("b"), []byte("2")}, items[1].Args, "should be equal")
+		assert.Equal(t, "del", items[2].Cmd, "should be equal")
+		assert.Equal(t, "abc", ds.runId, "should be equal")
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/alibaba/RedisShake/pkg/libs/log"
+	"github.com/alibaba/RedisShake/pkg/rdb"
 	"github.com/alibaba/RedisShake/redis-shake/base"
 	"github.com/alibaba/RedisShake/redis-shake/common"
 	"github.com/alibaba/RedisShake/redis-shake/configure"
@@ -15,15 +16,22 @@ import (
 	"github.com/alibaba/RedisShake/redis-shake/metric"
 )
 
-func (ds *DbSyncer) syncRDBFile(reader *bufio.Reader, target []string, authType, passwd string, nsize int64, tlsEnable bool, tlsSkipVerify bool) {
-	pipe := utils.NewRDBLoader(reader, &ds.stat.rBytes, base.RDBPipeSize)
+/*
+ * restore the rdb read from reader into the target. errFullResync is returned if the source drops before the
+ * whole rdb is transferred, and then the rdb is transferred again in the next resync node.
+ */
+func (ds *DbSyncer) syncRDBFile(reader *bufio.Reader, target []string, authType, passwd string, nsize int64, tlsEnable bool, tlsSkipVerify bool) error {
+	// the entries are partitioned by slot, each writer restores one pipe so the entries of a key keep the order
+	pipes, errc := utils.NewParallelRDBLoader(reader, &ds.stat.rBytes, pipeSize(conf.Options.Parallel),
+		conf.Options.Parallel, errFullResync)
+	source, _ := ds.sourceInfo()
 	wait := make(chan struct{})
 	go func() {
 		defer close(wait)
 		var wg sync.WaitGroup
 		wg.Add(conf.Options.Parallel)
 		for i := 0; i < conf.Options.Parallel; i++ {
-			go func() {
+			go func(pipe chan *rdb.BinEntry) {
 				defer wg.Done()
 				c := utils.OpenRedisConn(target, authType, passwd, conf.Options.TargetType == conf.RedisTypeCluster,
 					tlsEnable, tlsSkipVerify)
@@ -61,7 +69,7 @@ func (ds *DbSyncer) syncRDBFile(reader *bufio.Reader, target []string, authType,
 							select {
 							case <-ticker.C:
 								if _, err := c.Do("PING"); err != nil {
-									log.Infof("PING ", err)
+									log.Infof("PING failed[%v]", err)
 								}
 							default:
 							}
@@ -74,7 +82,7 @@ func (ds *DbSyncer) syncRDBFile(reader *bufio.Reader, target []string, authType,
 								select {
 								case <-ticker.C:
 									if _, err := c.Do("PING"); err != nil {
-										log.Infof("PING ", err)
+										log.Infof("PING failed[%v]", err)
 									}
 								default:
 								}
@@ -84,11 +92,11 @@ func (ds *DbSyncer) syncRDBFile(reader *bufio.Reader, target []string, authType,
 
 						log.Debugf("DbSyncer[%d] start restoring key[%s] with value length[%v]", ds.id, e.Key, len(e.Value))
 
-						utils.RestoreRdbEntry(c, e)
+						utils.RestoreRdbEntry(c, e, source, lastdb)
 						log.Debugf("DbSyncer[%d] restore key[%s] ok", ds.id, e.Key)
 					}
 				}
-			}()
+			}(pipes[i])
 		}
 
 		wg.Wait()
@@ -114,5 +122,18 @@ func (ds *DbSyncer) syncRDBFile(reader *bufio.Reader, target []string, authType,
 		log.Info(b.String())
 		metric.GetMetric(ds.id).SetFullSyncProgress(ds.id, uint64(100*stat.rBytes/nsize))
 	}
+	if err := <-errc; err != nil {
+		log.Warnf("DbSyncer[%d] sync rdb aborted[%v], %d keys are restored", ds.id, err, stat.keys)
+		return err
+	}
 	log.Infof("DbSyncer[%d] sync rdb done", ds.id)
+	return nil
+}
+
+// the buffer of each pipe, the total is about base.RDBPipeSize
+func pipeSize(parallel int) int {
+	if size := base.RDBPipeSize / parallel; size > 16 {
+		return size
+	}
+	return 16
 }
@@ -0,0 +1,229 @@
+package dbSync
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alibaba/RedisShake/pkg/libs/io/backlog"
+	"github.com/alibaba/RedisShake/pkg/libs/log"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+)
+
+/*
+ * cmdSpool buffers the parsed commands in a file once sendBuf is full, e.g., the target stalls, so that the
+ * source is still read and doesn't close the connection. Once a command is spooled, all the following
+ * commands are appended to the spool until it's replayed into sendBuf, so the order and offset are kept.
+ * The parser is blocked again only after the spool is full.
+ */
+type cmdSpool struct {
+	out  chan cmdDetail
+	bl   *backlog.Backlog
+	size uint64 // size of the file, the unread data never exceeds it so it's not overwritten by the ring
+
+	lock  sync.Mutex
+	cond  *sync.Cond
+	wpos  uint64    // end of the spooled commands
+	rpos  uint64    // end of the replayed commands
+	count int64     // number of commands not replayed
+	head  time.Time // read time of the oldest command not replayed
+}
+
+// create the spool in spool.dir, nil if sender.overflow_size isn't set.
+func newCmdSpool(id int, out chan cmdDetail) *cmdSpool {
+	if conf.Options.SenderOverflowSize == 0 {
+		return nil
+	}
+
+	f, err := ioutil.TempFile(conf.Options.SpoolDir, fmt.Sprintf("%s-%d-*.overflow", conf.Options.Id, id))
+	if err != nil {
+		log.PanicErrorf(err, "DbSyncer[%d] create overflow file in [%v] failed", id, conf.Options.SpoolDir)
+	}
+	// the file is only accessed by the opened handle, so it's removed even if the process is killed
+	if err := os.Remove(f.Name()); err != nil {
+		log.Warnf("DbSyncer[%d] remove overflow file[%v] failed[%v]", id, f.Name(), err)
+	}
+
+	s := &cmdSpool{
+		out:  out,
+		bl:   backlog.NewFileBacklog(int(conf.Options.SenderOverflowSize), f),
+		size: conf.Options.SenderOverflowSize,
+	}
+	// the same alignment as the backlog
+	if s.size%backlog.FileSizeAlign != 0 {
+		s.size = (s.size/backlog.FileSizeAlign + 1) * backlog.FileSizeAlign
+	}
+	s.cond = sync.NewCond(&s.lock)
+
+	r, err := s.bl.NewReader()
+	if err != nil {
+		log.PanicErrorf(err, "DbSyncer[%d] open overflow file[%v] failed", id, f.Name())
+	}
+	go s.replay(id, bufio.NewReader(r))
+	return s
+}
+
+// push the command into out directly if the spool is empty and out isn't full, otherwise into the spool.
+func (s *cmdSpool) push(item cmdDetail) {
+	if item.drained != nil {
+		// it's not a command, wait all the spooled commands are replayed
+		s.wait()
+		s.out <- item
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.count == 0 {
+		select {
+		case s.out <- item:
+			return
+		default:
+		}
+	}
+
+	data := encodeCmd(&item)
+	if uint64(len(data)) > s.size {
+		// never fit into the spool
+		for s.count != 0 {
+			s.cond.Wait()
+		}
+		s.out <- item
+		return
+	}
+	for s.wpos+uint64(len(data))-s.rpos > s.size {
+		s.cond.Wait()
+	}
+	if _, err := s.bl.Write(data); err != nil {
+		log.PanicErrorf(err, "write command with offset[%v] into the overflow file failed", item.Offset)
+	}
+	s.wpos += uint64(len(data))
+	if s.count++; s.count == 1 {
+		s.head = item.Time
+	}
+	s.cond.Broadcast()
+}
+
+// wait until all the spooled commands are replayed into out.
+func (s *cmdSpool) wait() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for s.count != 0 {
+		s.cond.Wait()
+	}
+}
+
+// read the spooled commands in order and send them into out.
+func (s *cmdSpool) replay(id int, r *bufio.Reader) {
+	for {
+		item, n, err := decodeCmd(r)
+		if err != nil {
+			log.PanicErrorf(err, "DbSyncer[%d] read command from the overflow file failed", id)
+		}
+
+		s.lock.Lock()
+		s.head = item.Time
+		s.lock.Unlock()
+
+		s.out <- item
+
+		s.lock.Lock()
+		s.rpos += uint64(n)
+		s.count--
+		s.cond.Broadcast()
+		s.lock.Unlock()
+	}
+}
+
+/*
+ * stat of the spool.
+ * @return:
+ *     int64: bytes not replayed
+ *     int64: number of commands not replayed
+ *     time.Duration: how long the oldest command not replayed is spooled
+ */
+func (s *cmdSpool) stat() (int64, int64, time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.count == 0 {
+		return 0, 0, 0
+	}
+	return int64(s.wpos - s.rpos), s.count, time.Since(s.head)
+}
+
+/*
+ * encode the command into "length, offset, db, time, argc, [length, arg]...", the first argument is the
+ * command name.
+ */
+func encodeCmd(item *cmdDetail) []byte {
+	args := make([][]byte, 0, len(item.Args)+1)
+	args = append(args, []byte(item.Cmd))
+	size := 4 + 8*3 + 4 + 4 + len(item.Cmd)
+	for _, arg := range item.Args {
+		var b []byte
+		switch v := arg.(type) {
+		case []byte:
+			b = v
+		case string:
+			b = []byte(v)
+		default:
+			b = []byte(fmt.Sprint(v))
+		}
+		args = append(args, b)
+		size += 4 + len(b)
+	}
+
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint32(data, uint32(size-4))
+	binary.LittleEndian.PutUint64(data[4:], uint64(item.Offset))
+	binary.LittleEndian.PutUint64(data[12:], uint64(item.Db))
+	binary.LittleEndian.PutUint64(data[20:], uint64(item.Time.UnixNano()))
+	binary.LittleEndian.PutUint32(data[28:], uint32(len(args)))
+	p := data[32:]
+	for _, arg := range args {
+		binary.LittleEndian.PutUint32(p, uint32(len(arg)))
+		p = p[4+copy(p[4:], arg):]
+	}
+	return data
+}
+
+// decode the command encoded by encodeCmd, the length read is also returned.
+func decodeCmd(r io.Reader) (cmdDetail, int, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return cmdDetail{}, 0, err
+	}
+	data := make([]byte, binary.LittleEndian.Uint32(head[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return cmdDetail{}, 0, err
+	}
+	if len(data) < 28 {
+		return cmdDetail{}, 0, fmt.Errorf("invalid command length[%v]", len(data))
+	}
+
+	item := cmdDetail{
+		Offset: int64(binary.LittleEndian.Uint64(data)),
+		Db:     int(int64(binary.LittleEndian.Uint64(data[8:]))),
+		Time:   time.Unix(0, int64(binary.LittleEndian.Uint64(data[16:]))),
+	}
+	argc := int(binary.LittleEndian.Uint32(data[24:]))
+	p := data[28:]
+	for i := 0; i < argc; i++ {
+		if len(p) < 4 || len(p) < 4+int(binary.LittleEndian.Uint32(p)) {
+			return cmdDetail{}, 0, fmt.Errorf("invalid argument[%v] of command with offset[%v]", i, item.Offset)
+		}
+		n := int(binary.LittleEndian.Uint32(p))
+		if i == 0 {
+			item.Cmd = string(p[4 : 4+n])
+		} else {
+			item.Args = append(item.Args, p[4:4+n])
+		}
+		p = p[4+n:]
+	}
+	return item, 4 + len(data), nil
+}
@@ -0,0 +1,130 @@
+package dbSync
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alibaba/RedisShake/pkg/libs/io/backlog"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeCmd(t *testing.T) {
+	// test encodeCmd and decodeCmd
+
+	var nr int
+
+	now := time.Now()
+	{
+		fmt.Printf("TestEncodeCmd case %d.\n", nr)
+		nr++
+
+		var b bytes.Buffer
+		items := []cmdDetail{
+			{Cmd: "set", Args: []interface{}{[]byte("a"), []byte("1")}, Offset: 100, Db: 2, Time: now},
+			{Cmd: "select", Args: []interface{}{"15"}, Offset: 200, Db: 15, Time: now},
+			{Cmd: "exec", Offset: 300, Db: -1, Time: now},
+		}
+		for i := range items {
+			b.Write(encodeCmd(&items[i]))
+		}
+		total := b.Len()
+
+		var n int
+		for i := range items {
+			item, sz, err := decodeCmd(&b)
+			assert.Equal(t, nil, err, "should be equal")
+			assert.Equal(t, items[i].Cmd, item.Cmd, "should be equal")
+			assert.Equal(t, items[i].Offset, item.Offset, "should be equal")
+			assert.Equal(t, items[i].Db, item.Db, "should be equal")
+			assert.Equal(t, true, items[i].Time.Equal(item.Time), "should be equal")
+			assert.Equal(t, len(items[i].Args), len(item.Args), "should be equal")
+			n += sz
+		}
+		assert.Equal(t, []byte("1"), encodeArgs(t, items[0])[1], "should be equal")
+		assert.Equal(t, total, n, "should be equal")
+	}
+}
+
+// the arguments after encoding and decoding
+func encodeArgs(t *testing.T, item cmdDetail) [][]byte {
+	ret, _, err := decodeCmd(bytes.NewReader(encodeCmd(&item)))
+	assert.Equal(t, nil, err, "should be equal")
+	args := make([][]byte, 0, len(ret.Args))
+	for _, arg := range ret.Args {
+		args = append(args, arg.([]byte))
+	}
+	return args
+}
+
+func TestCmdSpool(t *testing.T) {
+	// test the commands are spooled in order when out is full
+
+	var nr int
+
+	dir, err := ioutil.TempDir("", "overflow")
+	assert.Equal(t, nil, err, "should be equal")
+	defer os.RemoveAll(dir)
+	defer func() {
+		conf.Options.SenderOverflowSize = 0
+		conf.Options.SpoolDir = ""
+	}()
+
+	{
+		fmt.Printf("TestCmdSpool case %d.\n", nr)
+		nr++
+
+		assert.Equal(t, (*cmdSpool)(nil), newCmdSpool(1, make(chan cmdDetail)), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestCmdSpool case %d.\n", nr)
+		nr++
+
+		conf.Options.SenderOverflowSize = 1
+		conf.Options.SpoolDir = dir
+		out := make(chan cmdDetail, 2)
+		s := newCmdSpool(1, out)
+		assert.Equal(t, uint64(backlog.FileSizeAlign), s.size, "should be equal")
+
+		// the first 2 commands are sent into out directly, the others are spooled
+		for i := 0; i < 10; i++ {
+			s.push(cmdDetail{Cmd: "incr", Args: []interface{}{[]byte("a")}, Offset: int64(i),
+				Time: time.Now().Add(-time.Minute)})
+		}
+		size, count, age := s.stat()
+		assert.Equal(t, true, size > 0, "should be equal")
+		assert.Equal(t, true, count >= 7, "should be equal")
+		assert.Equal(t, true, age >= time.Minute, "should be equal")
+
+		// drained is sent after all the spooled commands
+		drained := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			s.push(cmdDetail{drained: drained})
+			close(done)
+		}()
+		for i := 0; i < 10; i++ {
+			item := <-out
+			assert.Equal(t, int64(i), item.Offset, "should be equal")
+			assert.Equal(t, "incr", item.Cmd, "should be equal")
+		}
+		item := <-out
+		assert.Equal(t, drained, item.drained, "should be equal")
+		<-done
+
+		size, count, age = s.stat()
+		assert.Equal(t, int64(0), size, "should be equal")
+		assert.Equal(t, int64(0), count, "should be equal")
+		assert.Equal(t, time.Duration(0), age, "should be equal")
+
+		// the spool is empty, send into out directly
+		s.push(cmdDetail{Cmd: "ping", Offset: 10})
+		assert.Equal(t, int64(10), (<-out).Offset, "should be equal")
+	}
+}
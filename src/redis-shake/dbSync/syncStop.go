@@ -0,0 +1,60 @@
+package dbSync
+
+import (
+	"time"
+
+	"github.com/alibaba/RedisShake/pkg/libs/log"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+)
+
+/*
+ * The sync can stop at a given point for the planned cutover: the source offset, the wall-clock time, or
+ * the lag keeps zero for some seconds. The writers flush all the commands before the point with the
+ * checkpoint, wait until all of them are applied, and then WaitStop is closed.
+ */
+
+// StopEnabled returns whether the sync stops at some point, otherwise it runs forever.
+func StopEnabled() bool {
+	return conf.Options.StopOffset > 0 || !conf.Options.StopTime.IsZero() || conf.Options.StopZeroLagSeconds > 0
+}
+
+// whether the command is beyond the stop point, it shouldn't be sent to the target.
+func beyondStopPoint(item *cmdDetail) bool {
+	if conf.Options.StopOffset > 0 && item.Offset > conf.Options.StopOffset {
+		return true
+	}
+	if !conf.Options.StopTime.IsZero() && !item.Time.Before(conf.Options.StopTime) {
+		return true
+	}
+	return false
+}
+
+// whether the command is the last one before the stop point.
+func reachStopOffset(item *cmdDetail) bool {
+	return conf.Options.StopOffset > 0 && item.Offset >= conf.Options.StopOffset
+}
+
+// request the sender to stop once the commands received are all flushed.
+func (ds *DbSyncer) requestStop(reason string) {
+	select {
+	case ds.stopRequest <- struct{}{}:
+		log.Infof("DbSyncer[%d] Event:StopRequest\tId:%s\treason: %s", ds.id, conf.Options.Id, reason)
+	default:
+	}
+}
+
+// zeroLagChecker checks whether the lag keeps zero for the given duration.
+type zeroLagChecker struct {
+	since time.Time // the time when the lag becomes zero, zero value means the lag isn't zero
+}
+
+func (z *zeroLagChecker) check(zero bool, now time.Time, duration time.Duration) bool {
+	if !zero {
+		z.since = time.Time{}
+		return false
+	}
+	if z.since.IsZero() {
+		z.since = now
+	}
+	return now.Sub(z.since) >= duration
+}
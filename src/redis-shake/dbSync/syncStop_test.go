@@ -0,0 +1,79 @@
+package dbSync
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStopPoint(t *testing.T) {
+	// test beyondStopPoint and reachStopOffset
+
+	var nr int
+
+	now := time.Now()
+	defer func() {
+		conf.Options.StopOffset = 0
+		conf.Options.StopTime = time.Time{}
+	}()
+
+	{
+		fmt.Printf("TestStopPoint case %d.\n", nr)
+		nr++
+
+		assert.Equal(t, false, StopEnabled(), "should be equal")
+		assert.Equal(t, false, beyondStopPoint(&cmdDetail{Offset: 100, Time: now}), "should be equal")
+		assert.Equal(t, false, reachStopOffset(&cmdDetail{Offset: 100, Time: now}), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestStopPoint case %d.\n", nr)
+		nr++
+
+		conf.Options.StopOffset = 100
+		assert.Equal(t, true, StopEnabled(), "should be equal")
+		assert.Equal(t, false, beyondStopPoint(&cmdDetail{Offset: 99, Time: now}), "should be equal")
+		assert.Equal(t, false, reachStopOffset(&cmdDetail{Offset: 99, Time: now}), "should be equal")
+		assert.Equal(t, false, beyondStopPoint(&cmdDetail{Offset: 100, Time: now}), "should be equal")
+		assert.Equal(t, true, reachStopOffset(&cmdDetail{Offset: 100, Time: now}), "should be equal")
+		assert.Equal(t, true, beyondStopPoint(&cmdDetail{Offset: 101, Time: now}), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestStopPoint case %d.\n", nr)
+		nr++
+
+		conf.Options.StopOffset = 0
+		conf.Options.StopTime = now
+		assert.Equal(t, true, StopEnabled(), "should be equal")
+		assert.Equal(t, false, beyondStopPoint(&cmdDetail{Offset: 100, Time: now.Add(-time.Second)}), "should be equal")
+		assert.Equal(t, true, beyondStopPoint(&cmdDetail{Offset: 100, Time: now}), "should be equal")
+	}
+}
+
+func TestZeroLagChecker(t *testing.T) {
+	// test zeroLagChecker
+
+	var nr int
+
+	now := time.Now()
+
+	{
+		fmt.Printf("TestZeroLagChecker case %d.\n", nr)
+		nr++
+
+		var z zeroLagChecker
+		assert.Equal(t, false, z.check(true, now, 3*time.Second), "should be equal")
+		assert.Equal(t, false, z.check(true, now.Add(2*time.Second), 3*time.Second), "should be equal")
+		assert.Equal(t, true, z.check(true, now.Add(3*time.Second), 3*time.Second), "should be equal")
+
+		// the lag isn't zero again
+		assert.Equal(t, false, z.check(false, now.Add(4*time.Second), 3*time.Second), "should be equal")
+		assert.Equal(t, false, z.check(true, now.Add(5*time.Second), 3*time.Second), "should be equal")
+		assert.Equal(t, true, z.check(true, now.Add(8*time.Second), 3*time.Second), "should be equal")
+	}
+}
@@ -1,11 +1,14 @@
 package dbSync
 
 import (
-	"github.com/alibaba/RedisShake/redis-shake/configure"
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/alibaba/RedisShake/pkg/libs/atomic2"
 	"github.com/alibaba/RedisShake/pkg/libs/log"
-	"time"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
 )
 
 const (
@@ -29,38 +32,189 @@ var (
 		"multi":  barrierStatusHoldStart,
 		"exec":   barrierStatusHoldEnd,
 	}
+
+	/*
+	 * commands whose result changes once applied again after the commands following them, e.g., the counters,
+	 * the list pushes and pops, the commands reading other keys and the scripts. They're kept out of the
+	 * replay window when checkpoint.consistency = replay.
+	 */
+	nonIdempotentMap = map[string]struct{}{
+		"incr": {}, "incrby": {}, "incrbyfloat": {}, "decr": {}, "decrby": {}, "append": {},
+		"hincrby": {}, "hincrbyfloat": {}, "zincrby": {}, "bitfield": {},
+		"lpush": {}, "rpush": {}, "lpushx": {}, "rpushx": {}, "linsert": {}, "lpop": {}, "rpop": {},
+		"lrem": {}, "ltrim": {}, "rpoplpush": {}, "lmove": {}, "smove": {}, "xadd": {},
+		"rename": {}, "renamenx": {}, "copy": {}, "bitop": {}, "pfmerge": {}, "sort": {},
+		"sunionstore": {}, "sinterstore": {}, "sdiffstore": {}, "zunionstore": {}, "zinterstore": {},
+		"zdiffstore": {}, "zrangestore": {}, "georadius": {}, "georadiusbymember": {}, "geosearchstore": {},
+		"zpopmin": {}, "zpopmax": {}, "eval": {}, "evalsha": {}, "fcall": {},
+	}
 )
 
-func (ds *DbSyncer) addSendId(sendId *atomic2.Int64, val int) {
-	(*sendId).Add(int64(val))
-	// redis client may flush the data in "send()" so we need to put the data into delay channel here
-	if conf.Options.Metric {
-		// delay channel
-		ds.addDelayChan((*sendId).Get())
+/*
+ * whether the command changes the result once applied again. Besides the commands in nonIdempotentMap,
+ * "zadd ... INCR" is a counter and "restore" without REPLACE fails if the key exists.
+ */
+func isNonIdempotent(item cmdDetail) bool {
+	if _, ok := nonIdempotentMap[item.Cmd]; ok {
+		return true
+	}
+
+	args := item.Args
+	switch item.Cmd {
+	case "zadd":
+		// zadd key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+		for i := 1; i < len(args); i++ {
+			switch strings.ToLower(string(args[i].([]byte))) {
+			case "incr":
+				return true
+			case "nx", "xx", "gt", "lt", "ch":
+			default:
+				return false
+			}
+		}
+	case "restore":
+		// restore key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency]
+		for i := 3; i < len(args); i++ {
+			if strings.EqualFold(string(args[i].([]byte)), "replace") {
+				return false
+			}
+		}
+		return true
 	}
+	return false
 }
 
-func (ds *DbSyncer) addDelayChan(id int64) {
-	// send
-	/*
-	 * available >=4096: 1:1 sampling
-	 * available >=1024: 1:10 sampling
-	 * available >=128: 1:100 sampling
-	 * else: 1:1000 sampling
-	 */
-	available := cap(ds.delayChannel) - len(ds.delayChannel)
-	if available >= 4096 ||
-		available >= 1024 && id%10 == 0 ||
-		available >= 128 && id%100 == 0 ||
-		id%1000 == 0 {
-		// non-blocking add
-		select {
-		case ds.delayChannel <- &delayNode{t: time.Now(), id: id}:
-		default:
-			// do nothing but print when channel is full
-			log.Warnf("DbSyncer[%d] delayChannel is full", ds.id)
+/*
+ * rewrite the command before sending to the target:
+ *   1. the absolute expire time, e.g., "pexpireat" and "set ... pxat" which the relative expire time is
+ *      rewritten into since redis 7.0, is converted into the clock of the target by fake_time.
+ *   2. "function load" replaces the existing library if function_exists = replace.
+ */
+func rewriteCommand(cmd string, args [][]byte) [][]byte {
+	switch cmd {
+	case "function":
+		if conf.Options.FunctionExists == "replace" && len(args) >= 2 && strings.EqualFold(string(args[0]), "load") &&
+			!strings.EqualFold(string(args[1]), "replace") {
+			ret := make([][]byte, 0, len(args)+1)
+			ret = append(ret, args[0], []byte("REPLACE"))
+			return append(ret, args[1:]...)
+		}
+		return args
+	}
+
+	if conf.Options.ShiftTime == 0 {
+		return args
+	}
+	switch cmd {
+	case "pexpireat", "hpexpireat":
+		if len(args) >= 2 {
+			args[1] = shiftExpireAt(args[1], time.Millisecond)
+		}
+	case "expireat", "hexpireat":
+		if len(args) >= 2 {
+			args[1] = shiftExpireAt(args[1], time.Second)
+		}
+	case "set", "getex":
+		// set key value [options], getex key [options], the value may look like an option
+		start := 2
+		if cmd == "getex" {
+			start = 1
+		}
+		for i := start; i+1 < len(args); i++ {
+			if strings.EqualFold(string(args[i]), "pxat") {
+				args[i+1] = shiftExpireAt(args[i+1], time.Millisecond)
+				i++
+			} else if strings.EqualFold(string(args[i]), "exat") {
+				args[i+1] = shiftExpireAt(args[i+1], time.Second)
+				i++
+			}
+		}
+	case "restore":
+		// restore key ttl value ... absttl
+		if len(args) >= 3 && !bytes.Equal(args[1], []byte("0")) {
+			for _, arg := range args[3:] {
+				if strings.EqualFold(string(arg), "absttl") {
+					args[1] = shiftExpireAt(args[1], time.Millisecond)
+					break
+				}
+			}
 		}
 	}
+	return args
+}
+
+// convert the absolute time of the source into the clock of the target, it's not changed if illegal.
+func shiftExpireAt(at []byte, unit time.Duration) []byte {
+	n, err := strconv.ParseInt(string(at), 10, 64)
+	if err != nil {
+		return at
+	}
+	return []byte(strconv.FormatInt(n-int64(conf.Options.ShiftTime/unit), 10))
+}
+
+// max number of the offset samples, older samples are dropped.
+const maxOffsetSamples = 3600
+
+type offsetSample struct {
+	offset int64
+	t      time.Time
+}
+
+// offsetTracker records the source master offset sampled at different time, so the lag between the source
+// and target can be measured in seconds by the applied offset.
+type offsetTracker struct {
+	lock    sync.Mutex
+	samples []offsetSample // increasing offset
+}
+
+func (ot *offsetTracker) add(offset int64, t time.Time) {
+	ot.lock.Lock()
+	defer ot.lock.Unlock()
+	if n := len(ot.samples); n > 0 && ot.samples[n-1].offset >= offset {
+		return
+	}
+	if len(ot.samples) >= maxOffsetSamples {
+		ot.samples = ot.samples[1:]
+	}
+	ot.samples = append(ot.samples, offsetSample{offset: offset, t: t})
+}
+
+// the offset space changes after full sync
+func (ot *offsetTracker) reset() {
+	ot.lock.Lock()
+	defer ot.lock.Unlock()
+	ot.samples = nil
+}
+
+/*
+ * lag returns the duration since the source master went beyond the applied offset, 0 means the target has
+ * caught up with the last sample.
+ */
+func (ot *offsetTracker) lag(applied int64, now time.Time) time.Duration {
+	ot.lock.Lock()
+	defer ot.lock.Unlock()
+	i := 0
+	for i < len(ot.samples) && ot.samples[i].offset <= applied {
+		i++
+	}
+	ot.samples = ot.samples[i:]
+	if len(ot.samples) == 0 {
+		return 0
+	}
+	return now.Sub(ot.samples[0].t)
+}
+
+// reconnectInterval returns the interval to wait before the given retry, it doubles from
+// reconnectMinInterval until reconnectMaxInterval.
+func reconnectInterval(retry int) time.Duration {
+	interval := reconnectMinInterval
+	for i := 0; i < retry && interval < reconnectMaxInterval; i++ {
+		interval *= 2
+	}
+	if interval > reconnectMaxInterval {
+		interval = reconnectMaxInterval
+	}
+	return interval
 }
 
 /*
@@ -0,0 +1,486 @@
+package dbSync
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alibaba/RedisShake/pkg/libs/atomic2"
+	"github.com/alibaba/RedisShake/pkg/libs/log"
+	"github.com/alibaba/RedisShake/redis-shake/base"
+	"github.com/alibaba/RedisShake/redis-shake/checkpoint"
+	utils "github.com/alibaba/RedisShake/redis-shake/common"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+	"github.com/alibaba/RedisShake/redis-shake/filter"
+	"github.com/alibaba/RedisShake/redis-shake/metric"
+)
+
+/*
+ * The parsed commands are dispatched to the writers, and each writer sends its commands to the target in
+ * batches with its own connection and checkpoint. There is only one writer by default. If sender.slot_parallel
+ * is enabled, the commands are partitioned by slot into one writer per master node of the target cluster:
+ *   1. the commands of the same slot are always sent by the same writer in order.
+ *   2. the commands across the writers, e.g., "flushall", the keys in different nodes, are barriers. They are
+ *      sent after all the previous commands are applied, and the following commands wait until they're applied.
+ *   3. each writer has its own checkpoint which is written into its own node by default, the sync resumes from
+ *      the minimal offset of all the writers and each writer skips the commands it has already applied.
+ */
+
+const (
+	clusterSlots = 16384
+
+	routeAll = -1 // the command is sent by all the writers
+)
+
+var (
+	// commands without key which should run on all the nodes of the target
+	broadcastCommands = map[string]struct{}{
+		"flushall": {},
+		"flushdb":  {},
+		"script":   {},
+		"function": {},
+	}
+)
+
+// cmdWriter sends the commands dispatched to it.
+type cmdWriter struct {
+	id             int            // writer id
+	node           string         // address of the target node, empty if the writer sends to the whole target
+	sendBuf        chan cmdDetail // commands dispatched to the writer, closed once the sync stops
+	checkpointName string         // checkpoint name, it's hashed into the node of the writer
+	skipOffset     int64          // the commands until this offset were applied by the writer before restarting
+	dispatched     atomic2.Int64  // source offset of the last command dispatched to the writer
+	ackedOffset    atomic2.Int64  // source offset of the last batch whose replies are all received
+	ackedSeq       atomic2.Int64  // sequence of the last batch whose replies are all received
+	errorRetry     atomic2.Int64  // times of resending the batch after error replies continuously
+	stopped        chan struct{}  // closed once all the commands are applied after sendBuf is closed
+
+	// the checkpoint of the acknowledged commands waiting to be saved into the store outside the target
+	ackedLock       sync.Mutex
+	ackedSource     string
+	ackedCheckpoint *checkpoint.Checkpoint
+}
+
+func newCmdWriter(id int, node, checkpointName string) *cmdWriter {
+	return &cmdWriter{
+		id:             id,
+		node:           node,
+		sendBuf:        make(chan cmdDetail, conf.Options.SenderCount),
+		checkpointName: checkpointName,
+		skipOffset:     -1,
+		stopped:        make(chan struct{}),
+	}
+}
+
+func (w *cmdWriter) setAckedCheckpoint(batch *sendBatch) {
+	w.ackedLock.Lock()
+	defer w.ackedLock.Unlock()
+	w.ackedSource = batch.source
+	w.ackedCheckpoint = &checkpoint.Checkpoint{
+		RunId:   batch.runId,
+		Offset:  batch.offset,
+		Db:      batch.endDb,
+		Version: utils.FcvCheckpoint.CurrentVersion,
+	}
+}
+
+// save the checkpoint of the acknowledged commands, it's saved again next time if failed.
+func (ds *DbSyncer) saveCheckpoint(w *cmdWriter) error {
+	w.ackedLock.Lock()
+	source, cp := w.ackedSource, w.ackedCheckpoint
+	w.ackedCheckpoint = nil
+	w.ackedLock.Unlock()
+	if cp == nil {
+		return nil
+	}
+
+	err := ds.checkpointStore.Save(source, w.checkpointName, cp)
+	if err != nil {
+		log.Warnf("DbSyncer[%d] writer[%d] Event:SaveCheckpointFail\tId:%s\toffset:%v\tError:%v",
+			ds.id, w.id, conf.Options.Id, cp.Offset, err)
+		w.ackedLock.Lock()
+		if w.ackedCheckpoint == nil {
+			w.ackedSource, w.ackedCheckpoint = source, cp
+		}
+		w.ackedLock.Unlock()
+	}
+	return err
+}
+
+/*
+ * create one writer for each master node of the target cluster.
+ * @return:
+ *     []*cmdWriter: writers
+ *     []int: slot -> writer id
+ *     error
+ */
+func newSlotWriters(target []string, authType, passwd string, tlsEnable, tlsSkipVerify bool) ([]*cmdWriter, []int,
+	error) {
+	var shards []utils.SlotOwner
+	var err error
+	for _, address := range target {
+		if shards, err = utils.GetSlotDistribution(address, authType, passwd, tlsEnable, tlsSkipVerify); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("get target slot distribution failed[%v]", err)
+	}
+
+	slotWriter := make([]int, clusterSlots)
+	for i := range slotWriter {
+		slotWriter[i] = -1
+	}
+	writers := make([]*cmdWriter, 0, len(shards))
+	nodes := make(map[string]int)
+	for _, shard := range shards {
+		id, ok := nodes[shard.Master]
+		if !ok {
+			id = len(writers)
+			nodes[shard.Master] = id
+			// the checkpoint is hashed into the first slot range of the node
+			name := utils.ChoseSlotInRange(utils.CheckpointKey, shard.SlotLeftBoundary, shard.SlotRightBoundary)
+			writers = append(writers, newCmdWriter(id, shard.Master, name))
+		}
+		for slot := shard.SlotLeftBoundary; slot <= shard.SlotRightBoundary && slot < clusterSlots; slot++ {
+			slotWriter[slot] = id
+		}
+	}
+	for slot, id := range slotWriter {
+		if id == -1 {
+			return nil, nil, fmt.Errorf("slot[%v] isn't served by any node of the target", slot)
+		}
+	}
+	return writers, slotWriter, nil
+}
+
+// the keys of the command, the first argument is regarded as the key if the command is unknown.
+func commandKeys(item *cmdDetail) []string {
+	args := make([][]byte, len(item.Args))
+	for i := range item.Args {
+		args[i] = item.Args[i].([]byte)
+	}
+
+	var keys [][]byte
+	switch item.Cmd {
+	case "eval", "evalsha", "fcall":
+		// script numkeys key [key ...] arg [arg ...]
+		if len(args) < 2 {
+			return nil
+		}
+		nr, err := strconv.Atoi(string(args[1]))
+		if err != nil || nr <= 0 || 2+nr > len(args) {
+			return nil
+		}
+		keys = args[2 : 2+nr]
+	default:
+		var ok bool
+		if keys, ok = filter.GetKeys(item.Cmd, args); !ok && len(args) > 0 {
+			keys = args[:1]
+		}
+	}
+
+	ret := make([]string, len(keys))
+	for i, key := range keys {
+		ret[i] = string(key)
+	}
+	return ret
+}
+
+/*
+ * route the command to the writer by the slot of its keys.
+ * @return:
+ *     int: writer id, routeAll means all the writers
+ *     bool: whether the command is a barrier
+ */
+func routeCommand(item *cmdDetail, slotWriter []int) (int, bool) {
+	if item.Cmd == "ping" {
+		// the idle writer advances its checkpoint by the ping
+		return routeAll, false
+	}
+	if _, ok := broadcastCommands[item.Cmd]; ok {
+		return routeAll, true
+	}
+
+	keys := commandKeys(item)
+	if len(keys) == 0 {
+		// e.g., the script without key, it runs on any one node
+		return 0, true
+	}
+	id := slotWriter[utils.KeyToSlot(keys[0])]
+	for _, key := range keys[1:] {
+		if slotWriter[utils.KeyToSlot(key)] != id {
+			// the node replies "CROSSSLOT" which is handled by the error policy
+			return id, true
+		}
+	}
+	return id, false
+}
+
+/*
+ * dispatch the commands parsed to the writers, it also decides when the sync stops. All the writers flush
+ * their cached commands once sendBuf is closed, and then WaitStop is closed after all of them are applied.
+ */
+func (ds *DbSyncer) dispatchCommand() {
+	var lastOffset int64 // source offset of the last dispatched command
+	var stopPending bool // stop once all the received commands are dispatched
+	var txn []cmdDetail  // the transaction is dispatched together once "exec" is received
+	shutdown := base.ShutdownContext().Done()
+	ticker := time.NewTicker(time.Duration(conf.Options.SenderTickerMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	stopFunc := func() {
+		ds.dispatchTxn(txn)
+		for _, w := range ds.writers {
+			close(w.sendBuf)
+		}
+		for _, w := range ds.writers {
+			<-w.stopped
+		}
+		log.Infof("DbSyncer[%d] Event:SyncStop\tId:%s\tall the commands until offset[%v] are applied",
+			ds.id, conf.Options.Id, lastOffset)
+		close(ds.WaitStop)
+	}
+
+	for {
+		select {
+		case item, ok := <-ds.sendBuf:
+			if !ok {
+				// the parser stops on shutdown, or at the end of the local file
+				if ds.local {
+					log.Infof("DbSyncer[%d] all the commands are dispatched", ds.id)
+				} else {
+					log.Infof("DbSyncer[%d] stop syncing on shutdown", ds.id)
+				}
+				stopFunc()
+				return
+			}
+			if item.drained != nil {
+				// the unfinished transaction is sent as it is before the full resync
+				ds.dispatchTxn(txn)
+				txn = nil
+				drainWriters(ds.writers)
+				// the offset restarts after the full resync
+				for _, w := range ds.writers {
+					w.skipOffset = -1
+				}
+				close(item.drained)
+				continue
+			}
+
+			if beyondStopPoint(&item) {
+				log.Infof("DbSyncer[%d] command with offset[%v] read at %v is beyond the stop point, stop syncing",
+					ds.id, item.Offset, item.Time)
+				stopFunc()
+				return
+			}
+
+			if len(ds.writers) == 1 {
+				// the transaction is handled by the writer
+				ds.sendToWriter(ds.writers[0], item)
+			} else if _, ok := selectedDb(item); ok {
+				// only db0 is available in cluster
+			} else if item.Cmd == "multi" {
+				ds.dispatchTxn(txn)
+				txn = []cmdDetail{item}
+			} else if txn != nil {
+				if txn = append(txn, item); item.Cmd == "exec" {
+					ds.dispatchTxn(txn)
+					txn = nil
+				}
+			} else {
+				ds.dispatchOne(item)
+			}
+			lastOffset = item.Offset
+			ds.dispatchedOffset.Set(item.Offset)
+
+			if reachStopOffset(&item) {
+				log.Infof("DbSyncer[%d] reach the stop offset[%v], stop syncing", ds.id, item.Offset)
+				stopFunc()
+				return
+			}
+
+		case <-ds.stopRequest:
+			stopPending = true
+
+		case <-shutdown:
+			// the source connection isn't closed by the sync command, so stop once the buffer is empty
+			shutdown = nil
+			if !conf.Options.Psync {
+				stopPending = true
+			}
+
+		case <-ticker.C:
+			if !conf.Options.StopTime.IsZero() && !time.Now().Before(conf.Options.StopTime) {
+				stopPending = true
+			}
+			// the commands read before the stop point are all received
+			if stopPending && len(ds.sendBuf) == 0 && !ds.spooled() {
+				log.Infof("DbSyncer[%d] stop syncing at offset[%v]", ds.id, lastOffset)
+				stopFunc()
+				return
+			}
+		}
+	}
+}
+
+// whether there are commands in the spool not replayed.
+func (ds *DbSyncer) spooled() bool {
+	if ds.spool == nil {
+		return false
+	}
+	_, count, _ := ds.spool.stat()
+	return count != 0
+}
+
+// dispatch one command out of the transaction.
+func (ds *DbSyncer) dispatchOne(item cmdDetail) {
+	id, barrier := routeCommand(&item, ds.slotWriter)
+	if barrier {
+		drainWriters(ds.writers)
+	}
+	if id == routeAll {
+		for _, w := range ds.writers {
+			ds.sendToWriter(w, item)
+		}
+	} else {
+		ds.sendToWriter(ds.writers[id], item)
+	}
+	if barrier {
+		drainWriters(ds.writers)
+	}
+}
+
+/*
+ * dispatch the transaction. The transaction is sent by one writer if all the commands are in the same node,
+ * otherwise it can't be atomic in the cluster, the commands are dispatched one by one after the barrier.
+ * If sender.transaction is enabled, the transaction is forwarded as "multi" and "exec" which requires all
+ * the keys in the same slot, otherwise it's handled by sender.transaction_cross_slot.
+ */
+func (ds *DbSyncer) dispatchTxn(txn []cmdDetail) {
+	if len(txn) == 0 {
+		return
+	}
+
+	id, barrier := -2, false
+	slot, crossSlot := -1, false
+	for i := range txn {
+		if txn[i].Cmd == "multi" || txn[i].Cmd == "exec" {
+			continue
+		}
+		cid, cb := routeCommand(&txn[i], ds.slotWriter)
+		if cb || cid == routeAll || id != -2 && cid != id {
+			barrier = true
+			break
+		}
+		id = cid
+		for _, key := range commandKeys(&txn[i]) {
+			if s := int(utils.KeyToSlot(key)); slot == -1 {
+				slot = s
+			} else if s != slot {
+				crossSlot = true
+			}
+		}
+	}
+	if id == -2 {
+		// empty transaction
+		return
+	}
+
+	if !barrier && (!crossSlot || !conf.Options.SenderTransaction) {
+		for _, item := range txn {
+			ds.sendToWriter(ds.writers[id], item)
+		}
+		return
+	}
+
+	offset := txn[len(txn)-1].Offset
+	if barrier {
+		// e.g., "function load" wrapped by multi/exec, it's broadcast to all the writers in order
+		log.Debugf("DbSyncer[%d] the transaction with offset[%v] contains the commands sent by all the writers",
+			ds.id, offset)
+	} else if conf.Options.SenderTransaction {
+		log.Warnf("DbSyncer[%d] Event:CrossSlotTransaction\tId:%s\tthe transaction with offset[%v] is across the "+
+			"slots of the target\tPolicy: %s", ds.id, conf.Options.Id, offset, conf.Options.SenderTxnCrossSlot)
+		switch conf.Options.SenderTxnCrossSlot {
+		case conf.ErrorPolicyPanic:
+			log.Panicf("DbSyncer[%d] the transaction with offset[%v] is across the slots of the target", ds.id,
+				offset)
+		case conf.ErrorPolicySkip:
+			ds.skipTxn(txn, nil)
+			return
+		case conf.ErrorPolicyDeadLetter:
+			source, _ := ds.sourceInfo()
+			ds.skipTxn(txn, func(item *cmdDetail) {
+				if err := utils.WriteDeadLetter(source, 0, item.Offset, item.Cmd, item.Args,
+					fmt.Errorf("CROSSSLOT the transaction is across the slots of the target")); err != nil {
+					log.Panicf("DbSyncer[%d] write dead-letter failed[%v]", ds.id, err)
+				}
+			})
+			return
+		}
+	} else {
+		log.Warnf("DbSyncer[%d] the transaction with offset[%v] is across the nodes of the target, it isn't atomic",
+			ds.id, offset)
+	}
+	drainWriters(ds.writers)
+	for _, item := range txn {
+		if item.Cmd != "multi" && item.Cmd != "exec" {
+			ds.dispatchOne(item)
+		}
+	}
+	drainWriters(ds.writers)
+}
+
+/*
+ * drop the transaction, fn is called with each command. The writers still advance the checkpoint by the
+ * following ping, so the transaction won't be read again after restarting.
+ */
+func (ds *DbSyncer) skipTxn(txn []cmdDetail, fn func(item *cmdDetail)) {
+	for i := range txn {
+		if txn[i].Cmd == "multi" || txn[i].Cmd == "exec" {
+			continue
+		}
+		ds.stat.incrSyncFilter.Incr()
+		metric.GetMetric(ds.id).AddBypassCmdCount(ds.id, 1)
+		if fn != nil {
+			fn(&txn[i])
+		}
+	}
+}
+
+func (ds *DbSyncer) sendToWriter(w *cmdWriter, item cmdDetail) {
+	if item.Offset <= w.skipOffset {
+		// applied before restarting
+		return
+	}
+	w.dispatched.Set(item.Offset)
+	w.sendBuf <- item
+}
+
+// wait until all the commands dispatched to the writers are applied.
+func drainWriters(writers []*cmdWriter) {
+	done := make([]chan struct{}, len(writers))
+	for i, w := range writers {
+		done[i] = make(chan struct{})
+		w.sendBuf <- cmdDetail{drained: done[i]}
+	}
+	for i := range done {
+		<-done[i]
+	}
+}
+
+/*
+ * the source offset until which all the commands are applied by the target. The writer without
+ * unacknowledged commands doesn't hold the offset back.
+ */
+func (ds *DbSyncer) appliedOffset() int64 {
+	applied := ds.dispatchedOffset.Get()
+	for _, w := range ds.writers {
+		if acked := w.ackedOffset.Get(); acked < w.dispatched.Get() && acked < applied {
+			applied = acked
+		}
+	}
+	return applied
+}
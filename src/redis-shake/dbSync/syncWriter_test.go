@@ -0,0 +1,180 @@
+package dbSync
+
+import (
+	"fmt"
+	"testing"
+
+	utils "github.com/alibaba/RedisShake/redis-shake/common"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+	"github.com/alibaba/RedisShake/redis-shake/metric"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouteCommand(t *testing.T) {
+	// test routeCommand
+
+	var nr int
+
+	// 2 writers: slot [0, 8191] -> 0, [8192, 16383] -> 1
+	slotWriter := make([]int, clusterSlots)
+	for i := clusterSlots / 2; i < clusterSlots; i++ {
+		slotWriter[i] = 1
+	}
+	writerOf := func(key string) int {
+		return slotWriter[utils.KeyToSlot(key)]
+	}
+	route := func(cmd string, args ...string) (int, bool) {
+		item := newTestCmd(cmd, args...)
+		return routeCommand(&item, slotWriter)
+	}
+	// "a" and "b" are in different writers
+	assert.NotEqual(t, writerOf("a"), writerOf("b"), "should be not equal")
+
+	{
+		fmt.Printf("TestRouteCommand case %d.\n", nr)
+		nr++
+
+		id, barrier := route("set", "a", "1")
+		assert.Equal(t, writerOf("a"), id, "should be equal")
+		assert.Equal(t, false, barrier, "should be equal")
+
+		// unknown command, the first argument is the key
+		id, barrier = route("xadd", "b", "*", "f", "v")
+		assert.Equal(t, writerOf("b"), id, "should be equal")
+		assert.Equal(t, false, barrier, "should be equal")
+
+		// same slot by the hash tag
+		id, barrier = route("rename", "{b}1", "{b}2")
+		assert.Equal(t, writerOf("b"), id, "should be equal")
+		assert.Equal(t, false, barrier, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestRouteCommand case %d.\n", nr)
+		nr++
+
+		// across the writers
+		id, barrier := route("mset", "a", "1", "b", "2")
+		assert.Equal(t, writerOf("a"), id, "should be equal")
+		assert.Equal(t, true, barrier, "should be equal")
+
+		id, barrier = route("eval", "return 1", "2", "b", "a")
+		assert.Equal(t, writerOf("b"), id, "should be equal")
+		assert.Equal(t, true, barrier, "should be equal")
+
+		// script without key
+		id, barrier = route("eval", "return 1", "0")
+		assert.Equal(t, 0, id, "should be equal")
+		assert.Equal(t, true, barrier, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestRouteCommand case %d.\n", nr)
+		nr++
+
+		id, barrier := route("ping")
+		assert.Equal(t, routeAll, id, "should be equal")
+		assert.Equal(t, false, barrier, "should be equal")
+
+		id, barrier = route("flushall")
+		assert.Equal(t, routeAll, id, "should be equal")
+		assert.Equal(t, true, barrier, "should be equal")
+	}
+}
+
+func TestAppliedOffset(t *testing.T) {
+	// test appliedOffset
+
+	var nr int
+
+	ds := &DbSyncer{writers: []*cmdWriter{newCmdWriter(0, "", ""), newCmdWriter(1, "", "")}}
+
+	{
+		fmt.Printf("TestAppliedOffset case %d.\n", nr)
+		nr++
+
+		// all the writers are idle
+		ds.dispatchedOffset.Set(100)
+		assert.Equal(t, int64(100), ds.appliedOffset(), "should be equal")
+	}
+
+	{
+		fmt.Printf("TestAppliedOffset case %d.\n", nr)
+		nr++
+
+		// writer 0 is waiting for the replies of the commands after offset 80
+		ds.writers[0].dispatched.Set(90)
+		ds.writers[0].ackedOffset.Set(80)
+		ds.writers[1].dispatched.Set(100)
+		ds.writers[1].ackedOffset.Set(100)
+		assert.Equal(t, int64(80), ds.appliedOffset(), "should be equal")
+
+		ds.writers[0].ackedOffset.Set(90)
+		assert.Equal(t, int64(100), ds.appliedOffset(), "should be equal")
+	}
+}
+
+func TestDispatchTxn(t *testing.T) {
+	// test dispatchTxn with sender.transaction
+
+	var nr int
+
+	defer func() {
+		conf.Options.SenderTransaction = false
+		conf.Options.SenderTxnCrossSlot = ""
+		conf.Options.SenderCount = 0
+	}()
+
+	conf.Options.SenderCount = 16
+	metric.AddMetric(0)
+
+	// 2 writers: slot [0, 8191] -> 0, [8192, 16383] -> 1
+	ds := &DbSyncer{writers: []*cmdWriter{newCmdWriter(0, "", ""), newCmdWriter(1, "", "")},
+		slotWriter: make([]int, clusterSlots)}
+	for i := clusterSlots / 2; i < clusterSlots; i++ {
+		ds.slotWriter[i] = 1
+	}
+	// "a" and another key of the same writer in different slots
+	w := ds.writers[ds.slotWriter[utils.KeyToSlot("a")]]
+	other := "b"
+	for i := 0; ds.writers[ds.slotWriter[utils.KeyToSlot(other)]] != w; i++ {
+		other = fmt.Sprintf("b%d", i)
+	}
+	txn := func(keys ...string) []cmdDetail {
+		ret := []cmdDetail{newTestCmd("multi")}
+		for _, key := range keys {
+			ret = append(ret, newTestCmd("set", key, "1"))
+		}
+		return append(ret, newTestCmd("exec"))
+	}
+
+	{
+		fmt.Printf("TestDispatchTxn case %d.\n", nr)
+		nr++
+
+		// the same slot
+		conf.Options.SenderTransaction = true
+		conf.Options.SenderTxnCrossSlot = conf.ErrorPolicySkip
+		ds.dispatchTxn(txn("{a}1", "{a}2"))
+		assert.Equal(t, 4, len(w.sendBuf), "should be equal")
+		for _, cmd := range []string{"multi", "set", "set", "exec"} {
+			assert.Equal(t, cmd, (<-w.sendBuf).Cmd, "should be equal")
+		}
+	}
+
+	{
+		fmt.Printf("TestDispatchTxn case %d.\n", nr)
+		nr++
+
+		// the same node but different slots
+		ds.dispatchTxn(txn("a", other))
+		assert.Equal(t, 0, len(w.sendBuf), "should be equal")
+		assert.Equal(t, int64(2), ds.stat.incrSyncFilter.Get(), "should be equal")
+
+		// sent by the writer of the node without sender.transaction
+		conf.Options.SenderTransaction = false
+		ds.dispatchTxn(txn("a", other))
+		assert.Equal(t, 4, len(w.sendBuf), "should be equal")
+	}
+}
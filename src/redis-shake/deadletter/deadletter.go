@@ -0,0 +1,202 @@
+package deadletter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alibaba/RedisShake/pkg/libs/errors"
+	"github.com/alibaba/RedisShake/pkg/redis"
+)
+
+/*
+ * The dead-letter file keeps the commands which can't be applied by the target redis, so they can be
+ * checked or replayed later instead of stopping the whole process. Each entry is stored as two RESP
+ * arrays of bulk strings:
+ * 1. header: "deadletter", time, source, db, offset, error reply
+ * 2. command: command name and arguments
+ * The file is shared by `sync`, `restore` and `rump`, and replayed by `replay`.
+ */
+
+const (
+	headerName   = "deadletter"
+	headerFields = 6
+	timeFormat   = time.RFC3339
+)
+
+// Entry is one command in the dead-letter file.
+type Entry struct {
+	Time    time.Time
+	Source  string // source address in `sync` and `rump`, input file in `restore` and `replay`
+	Db      int    // target db
+	Offset  int64  // source offset in `sync`, -1 if unknown
+	Error   string // error reply from the target
+	Command string
+	Args    [][]byte
+}
+
+type Writer struct {
+	name   string
+	file   *os.File
+	writer *bufio.Writer
+	lock   sync.Mutex
+}
+
+var (
+	// shared by all the syncers, rumpers and restorers
+	defaultWriter *Writer
+)
+
+// Init opens the dead-letter file in append mode, it must be called before Write.
+func Init(name string) error {
+	w, err := Open(name)
+	if err != nil {
+		return err
+	}
+	defaultWriter = w
+	return nil
+}
+
+// Enabled returns whether the dead-letter file is given.
+func Enabled() bool {
+	return defaultWriter != nil
+}
+
+// Write writes the entry into the dead-letter file opened by Init.
+func Write(entry *Entry) error {
+	if defaultWriter == nil {
+		return fmt.Errorf("dead-letter file isn't opened")
+	}
+	return defaultWriter.Write(entry)
+}
+
+func Open(name string) (*Writer, error) {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open dead-letter file[%v] failed[%v]", name, err)
+	}
+	return &Writer{
+		name:   name,
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}, nil
+}
+
+// Write appends the entry and flushes the file, so the entry isn't lost once the process exits.
+func (w *Writer) Write(entry *Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	header := redis.NewArray()
+	header.AppendBulkBytes([]byte(headerName))
+	header.AppendBulkBytes([]byte(entry.Time.Format(timeFormat)))
+	header.AppendBulkBytes([]byte(entry.Source))
+	header.AppendBulkBytes([]byte(strconv.Itoa(entry.Db)))
+	header.AppendBulkBytes([]byte(strconv.FormatInt(entry.Offset, 10)))
+	header.AppendBulkBytes([]byte(entry.Error))
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := redis.Encode(w.writer, header, false); err != nil {
+		return fmt.Errorf("write dead-letter file[%v] failed[%v]", w.name, err)
+	}
+	if err := redis.Encode(w.writer, redis.ChangeArgsToResp([]byte(entry.Command), entry.Args), true); err != nil {
+		return fmt.Errorf("write dead-letter file[%v] failed[%v]", w.name, err)
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := w.writer.Flush(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+type Reader struct {
+	reader *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		reader: bufio.NewReader(r),
+	}
+}
+
+// Next returns the next entry in the file, io.EOF is returned at the end of the file.
+func (r *Reader) Next() (*Entry, error) {
+	resp, err := redis.Decode(r.reader)
+	if err != nil {
+		if errors.Equal(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("read dead-letter header failed[%v]", err)
+	}
+	name, header, err := redis.ParseArgs(resp)
+	if err != nil {
+		return nil, fmt.Errorf("parse dead-letter header failed[%v]", err)
+	}
+	if name != headerName || len(header) != headerFields-1 {
+		return nil, fmt.Errorf("illegal dead-letter header[%v] with %v fields", name, len(header)+1)
+	}
+
+	entry := &Entry{
+		Source: string(header[1]),
+		Error:  string(header[4]),
+	}
+	if entry.Time, err = time.Parse(timeFormat, string(header[0])); err != nil {
+		return nil, fmt.Errorf("parse dead-letter time[%s] failed[%v]", header[0], err)
+	}
+	if entry.Db, err = strconv.Atoi(string(header[2])); err != nil {
+		return nil, fmt.Errorf("parse dead-letter db[%s] failed[%v]", header[2], err)
+	}
+	if entry.Offset, err = strconv.ParseInt(string(header[3]), 10, 64); err != nil {
+		return nil, fmt.Errorf("parse dead-letter offset[%s] failed[%v]", header[3], err)
+	}
+
+	resp, err = redis.Decode(r.reader)
+	if err != nil {
+		if errors.Equal(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("read dead-letter command failed[%v]", err)
+	}
+	if entry.Command, entry.Args, err = redis.ParseArgs(resp); err != nil {
+		return nil, fmt.Errorf("parse dead-letter command failed[%v]", err)
+	}
+	return entry, nil
+}
+
+// Args converts the arguments of redigo into bytes.
+func Args(args []interface{}) [][]byte {
+	ret := make([][]byte, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case []byte:
+			ret[i] = v
+		case string:
+			ret[i] = []byte(v)
+		default:
+			ret[i] = []byte(fmt.Sprint(v))
+		}
+	}
+	return ret
+}
+
+// String returns the command for logging.
+func (e *Entry) String() string {
+	args := make([]string, 0, len(e.Args)+1)
+	args = append(args, e.Command)
+	for _, arg := range e.Args {
+		args = append(args, string(arg))
+	}
+	return strings.Join(args, " ")
+}
@@ -0,0 +1,81 @@
+package deadletter
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadWrite(t *testing.T) {
+	// test Writer and Reader
+
+	var nr int
+
+	dir, err := ioutil.TempDir("", "deadletter")
+	assert.Equal(t, nil, err, "should be equal")
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "dead_letter")
+
+	now := time.Unix(1600000000, 0)
+	entries := []*Entry{
+		{
+			Time:    now,
+			Source:  "127.0.0.1:6379",
+			Db:      3,
+			Offset:  12345,
+			Error:   "WRONGTYPE Operation against a key holding the wrong kind of value",
+			Command: "lpush",
+			Args:    [][]byte{[]byte("a"), []byte("1")},
+		},
+		{
+			Time:    now,
+			Source:  "dump.rdb",
+			Db:      0,
+			Offset:  -1,
+			Error:   "ERR bad data",
+			Command: "restore",
+			Args:    [][]byte{[]byte("b"), []byte("0"), {0, '\r', '\n', 0xff}},
+		},
+	}
+
+	{
+		fmt.Printf("TestReadWrite case %d.\n", nr)
+		nr++
+
+		w, err := Open(name)
+		assert.Equal(t, nil, err, "should be equal")
+		for _, entry := range entries {
+			assert.Equal(t, nil, w.Write(entry), "should be equal")
+		}
+		assert.Equal(t, nil, w.Close(), "should be equal")
+
+		file, err := os.Open(name)
+		assert.Equal(t, nil, err, "should be equal")
+		defer file.Close()
+
+		r := NewReader(file)
+		for _, entry := range entries {
+			e, err := r.Next()
+			assert.Equal(t, nil, err, "should be equal")
+			assert.Equal(t, true, entry.Time.Equal(e.Time), "should be equal")
+			e.Time = entry.Time
+			assert.Equal(t, entry, e, "should be equal")
+		}
+		_, err = r.Next()
+		assert.Equal(t, io.EOF, err, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestReadWrite case %d.\n", nr)
+		nr++
+
+		assert.Equal(t, [][]byte{[]byte("a"), []byte("100"), []byte("v"), []byte("REPLACE")},
+			Args([]interface{}{"a", uint64(100), []byte("v"), "REPLACE"}), "should be equal")
+	}
+}
@@ -190,17 +190,18 @@ func (cmd *CmdDecode) decoderMain(ipipe <-chan *rdb.BinEntry, opipe chan<- strin
 		case rdb.Hash:
 			for _, ele := range obj {
 				o := &struct {
-					DB       uint32 `json:"db"`
-					Type     string `json:"type"`
-					ExpireAt uint64 `json:"expireat"`
-					Key      string `json:"key"`
-					Key64    string `json:"key64"`
-					Field    string `json:"field"`
-					Field64  string `json:"field64"`
-					Value64  string `json:"value64"`
+					DB            uint32 `json:"db"`
+					Type          string `json:"type"`
+					ExpireAt      uint64 `json:"expireat"`
+					Key           string `json:"key"`
+					Key64         string `json:"key64"`
+					Field         string `json:"field"`
+					Field64       string `json:"field64"`
+					Value64       string `json:"value64"`
+					FieldExpireAt uint64 `json:"field_expireat,omitempty"`
 				}{
 					e.DB, "hash", e.ExpireAt, toText(e.Key), toBase64(e.Key),
-					toText(ele.Field), toBase64(ele.Field), toBase64(ele.Value),
+					toText(ele.Field), toBase64(ele.Field), toBase64(ele.Value), ele.ExpireAt,
 				}
 				fmt.Fprintf(&b, "%s\n", toJson(o))
 			}
@@ -237,6 +238,38 @@ func (cmd *CmdDecode) decoderMain(ipipe <-chan *rdb.BinEntry, opipe chan<- strin
 				}
 				fmt.Fprintf(&b, "%s\n", toJson(o))
 			}
+		case *rdb.Module:
+			o := &struct {
+				DB       uint32 `json:"db"`
+				Type     string `json:"type"`
+				ExpireAt uint64 `json:"expireat"`
+				Key      string `json:"key"`
+				Key64    string `json:"key64"`
+				Module   string `json:"module"`
+			}{
+				e.DB, "module", e.ExpireAt, toText(e.Key), toBase64(e.Key), obj.Name,
+			}
+			fmt.Fprintf(&b, "%s\n", toJson(o))
+		case *rdb.Stream:
+			for _, ele := range obj.Entries {
+				fields := make([]string, 0, len(ele.Fields))
+				for _, field := range ele.Fields {
+					fields = append(fields, toBase64(field))
+				}
+				o := &struct {
+					DB       uint32   `json:"db"`
+					Type     string   `json:"type"`
+					ExpireAt uint64   `json:"expireat"`
+					Key      string   `json:"key"`
+					Key64    string   `json:"key64"`
+					Id       string   `json:"id"`
+					Fields64 []string `json:"fields64"`
+				}{
+					e.DB, "stream", e.ExpireAt, toText(e.Key), toBase64(e.Key),
+					ele.Id.String(), fields,
+				}
+				fmt.Fprintf(&b, "%s\n", toJson(o))
+			}
 		}
 		cmd.nentry.Incr()
 		opipe <- b.String()
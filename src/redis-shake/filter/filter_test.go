@@ -334,6 +334,31 @@ func TestHasAtLeastOnePrefix(t *testing.T) {
 	}
 }
 
+func TestGetKeys(t *testing.T) {
+	// test GetKeys
+
+	var nr int
+	{
+		fmt.Printf("TestGetKeys case %d.\n", nr)
+		nr++
+
+		keys, ok := GetKeys("set", convertToByte("xyz", "1"))
+		assert.Equal(t, true, ok, "should be equal")
+		assert.Equal(t, convertToByte("xyz"), keys, "should be equal")
+
+		keys, ok = GetKeys("mset", convertToByte("xyz", "1", "abc", "2"))
+		assert.Equal(t, true, ok, "should be equal")
+		assert.Equal(t, convertToByte("xyz", "abc"), keys, "should be equal")
+
+		keys, ok = GetKeys("rename", convertToByte("a", "b"))
+		assert.Equal(t, true, ok, "should be equal")
+		assert.Equal(t, convertToByte("a", "b"), keys, "should be equal")
+
+		_, ok = GetKeys("xadd", convertToByte("a", "*", "f", "v"))
+		assert.Equal(t, false, ok, "should be equal")
+	}
+}
+
 func convertToByte(args ...string) [][]byte {
 	ret := make([][]byte, 0)
 	for _, arg := range args {
@@ -121,3 +121,22 @@ func getMatchKeys(redis_cmd redisCommand, args [][]byte) (new_args [][]byte, pas
 
 	return
 }
+
+// GetKeys returns the keys of the command, false is returned if the command isn't in the table.
+func GetKeys(scmd string, args [][]byte) ([][]byte, bool) {
+	redisCmd, ok := RedisCommands[scmd]
+	if !ok || len(args) == 0 {
+		return nil, ok
+	}
+
+	lastkey := redisCmd.lastkey - 1
+	if lastkey < 0 {
+		lastkey = lastkey + len(args)
+	}
+
+	keys := make([][]byte, 0, 1)
+	for i := redisCmd.firstkey - 1; i <= lastkey && i < len(args); i += redisCmd.keystep {
+		keys = append(keys, args[i])
+	}
+	return keys, true
+}
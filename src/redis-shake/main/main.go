@@ -21,6 +21,7 @@ import (
 	"github.com/alibaba/RedisShake/redis-shake/base"
 	"github.com/alibaba/RedisShake/redis-shake/common"
 	"github.com/alibaba/RedisShake/redis-shake/configure"
+	"github.com/alibaba/RedisShake/redis-shake/deadletter"
 	"github.com/alibaba/RedisShake/redis-shake/metric"
 	"github.com/alibaba/RedisShake/redis-shake/restful"
 
@@ -34,6 +35,8 @@ const (
 	defaultSystemPort  = 9310
 	defaultSenderSize  = 65535
 	defaultSenderCount = 1024
+
+	defaultShutdownTimeout = 60 // seconds
 )
 
 func main() {
@@ -43,8 +46,12 @@ func main() {
 
 	// argument options
 	configuration := flag.String("conf", "", "configuration path")
-	tp := flag.String("type", "", "run type: decode, restore, dump, sync, rump")
+	tp := flag.String("type", "", "run type: decode, restore, dump, sync, rump, replay, checkpoint")
 	version := flag.Bool("version", false, "show version")
+	action := flag.String("action", run.CheckpointActionList, "checkpoint action when type is checkpoint: list, clear")
+	name := flag.String("name", "", "checkpoint name to list or clear when type is checkpoint, empty means all")
+	source := flag.String("source", "", "source address or rdb file of the checkpoint to list or clear when type "+
+		"is checkpoint, empty means all")
 	flag.Parse()
 
 	if *version {
@@ -85,13 +92,26 @@ func main() {
 		crash(fmt.Sprintf("Conf.Options check failed: %s", err.Error()), -4)
 	}
 
-	initSignal()
+	if conf.Options.TargetDeadLetterFile != "" {
+		if err = deadletter.Init(conf.Options.TargetDeadLetterFile); err != nil {
+			crash(err.Error(), -5)
+		}
+	}
+
+	// only sync and rump are able to flush the received data before exiting
+	initSignal(*tp == conf.TypeSync || *tp == conf.TypeRump)
 	initFreeOS()
 	nimo.Profiling(int(conf.Options.SystemProfile))
 	utils.Welcome()
 	utils.StartTime = fmt.Sprintf("%v", time.Now().Format(utils.GolangSecurityTime))
 
-	if err = utils.WritePidById(conf.Options.Id, conf.Options.PidPath); err != nil {
+	// the checkpoint is inspected while the sync with the same id may be running
+	inspect := *tp == conf.TypeCheckpoint
+	if inspect {
+		if *action != run.CheckpointActionList && *action != run.CheckpointActionClear {
+			crash(fmt.Sprintf("unknown checkpoint action[%v]", *action), -4)
+		}
+	} else if err = utils.WritePidById(conf.Options.Id, conf.Options.PidPath); err != nil {
 		crash(fmt.Sprintf("write pid failed. %v", err), -5)
 	}
 
@@ -108,11 +128,17 @@ func main() {
 		runner = new(run.CmdSync)
 	case conf.TypeRump:
 		runner = new(run.CmdRump)
+	case conf.TypeReplay:
+		runner = new(run.CmdReplay)
+	case conf.TypeCheckpoint:
+		runner = &run.CmdCheckpoint{Action: *action, Name: *name, Source: *source}
 	}
 
 	// create metric
 	metric.CreateMetric(runner)
-	go startHttpServer()
+	if !inspect {
+		go startHttpServer()
+	}
 
 	// print configuration
 	if opts, err := json.Marshal(conf.GetSafeOptions()); err != nil {
@@ -127,18 +153,38 @@ func main() {
 	log.Infof("execute runner[%v] finished!", reflect.TypeOf(runner))
 }
 
-func initSignal() {
+/*
+ * exit on SIGINT or SIGTERM. If graceful, the runner stops reading from the source and flushes the received
+ * data, and then the process exits once the runner finishes. It still exits directly on timeout or receiving
+ * the signal again.
+ */
+func initSignal(graceful bool) {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigs
 		log.Info("receive signal: ", sig)
 
+		code := 0
+		if graceful {
+			base.Shutdown()
+			log.Infof("shutdown gracefully in %v seconds, send the signal again to exit directly",
+				conf.Options.ShutdownTimeout)
+			select {
+			case sig = <-sigs:
+				log.Warn("receive signal again: ", sig)
+			case <-time.After(time.Duration(conf.Options.ShutdownTimeout) * time.Second):
+				log.Warnf("shutdown timeout after %v seconds, the received data may not be flushed",
+					conf.Options.ShutdownTimeout)
+			}
+			code = 1
+		}
+
 		if utils.LogRotater != nil {
 			utils.LogRotater.Rotate()
 		}
 
-		os.Exit(0)
+		os.Exit(code)
 	}()
 }
 
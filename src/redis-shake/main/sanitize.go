@@ -19,7 +19,8 @@ import (
 // sanitize options. TODO, need split
 func SanitizeOptions(tp string) error {
 	var err error
-	if tp != conf.TypeDecode && tp != conf.TypeRestore && tp != conf.TypeDump && tp != conf.TypeSync && tp != conf.TypeRump {
+	if tp != conf.TypeDecode && tp != conf.TypeRestore && tp != conf.TypeDump && tp != conf.TypeSync &&
+		tp != conf.TypeRump && tp != conf.TypeReplay && tp != conf.TypeCheckpoint {
 		return fmt.Errorf("unknown type[%v]", tp)
 	}
 
@@ -65,23 +66,34 @@ func SanitizeOptions(tp string) error {
 		conf.Options.TargetPasswordRaw = string(targetPassword)
 	}
 
+	if conf.Options.SourceType == conf.SourceTypeAof && tp != conf.TypeRestore {
+		return fmt.Errorf("source.type[%v] is only supported in restore", conf.Options.SourceType)
+	}
+	if conf.Options.TargetType == conf.TargetTypeRdb && tp != conf.TypeRump {
+		return fmt.Errorf("target.type[%v] is only supported in rump", conf.Options.TargetType)
+	}
+
 	// parse source and target address and type
 	if err := utils.ParseAddress(tp); err != nil {
 		return fmt.Errorf("mode[%v] parse address failed[%v]", tp, err)
 	}
 
-	if tp == conf.TypeRestore || tp == conf.TypeDecode {
+	if tp == conf.TypeRestore || tp == conf.TypeDecode || tp == conf.TypeReplay {
 		if len(conf.Options.SourceRdbInput) == 0 {
-			return fmt.Errorf("input rdb shouldn't be empty when type in {restore, decode}")
+			return fmt.Errorf("input rdb shouldn't be empty when type in {restore, decode, replay}")
 		}
 		// check file exist
 		for _, rdb := range conf.Options.SourceRdbInput {
 			if _, err := os.Stat(rdb); os.IsNotExist(err) {
 				return fmt.Errorf("input rdb file[%v] not exists", rdb)
 			}
+			// the commands failed again are appended into the dead-letter file
+			if tp == conf.TypeReplay && rdb == conf.Options.TargetDeadLetterFile {
+				return fmt.Errorf("input dead-letter file[%v] shouldn't be target.dead_letter_file", rdb)
+			}
 		}
 	}
-	if tp == conf.TypeDump && conf.Options.TargetRdbOutput == "" {
+	if (tp == conf.TypeDump || conf.Options.TargetType == conf.TargetTypeRdb) && conf.Options.TargetRdbOutput == "" {
 		conf.Options.TargetRdbOutput = "output-rdb-dump"
 	}
 
@@ -89,7 +101,7 @@ func SanitizeOptions(tp string) error {
 		if conf.Options.SourceRdbParallel <= 0 || conf.Options.SourceRdbParallel > len(conf.Options.SourceAddressList) {
 			conf.Options.SourceRdbParallel = len(conf.Options.SourceAddressList)
 		}
-	} else if tp == conf.TypeRestore || tp == conf.TypeDecode {
+	} else if tp == conf.TypeRestore || tp == conf.TypeDecode || tp == conf.TypeReplay {
 		if conf.Options.SourceRdbParallel <= 0 || conf.Options.SourceRdbParallel > len(conf.Options.SourceRdbInput) {
 			conf.Options.SourceRdbParallel = len(conf.Options.SourceRdbInput)
 		}
@@ -192,6 +204,19 @@ func SanitizeOptions(tp string) error {
 		return fmt.Errorf("key_exists should in {none, rewrite, ignore}")
 	}
 
+	if conf.Options.HashFieldTTL == "" {
+		conf.Options.HashFieldTTL = "panic"
+	} else if conf.Options.HashFieldTTL != "panic" && conf.Options.HashFieldTTL != "drop" {
+		return fmt.Errorf("hash_field_ttl should in {panic, drop}")
+	}
+
+	if conf.Options.UnknownModule == "" {
+		conf.Options.UnknownModule = "skip"
+	} else if conf.Options.UnknownModule != "skip" && conf.Options.UnknownModule != "restore" &&
+		conf.Options.UnknownModule != "panic" {
+		return fmt.Errorf("unknown_module should in {skip, restore, panic}")
+	}
+
 	if conf.Options.FilterDB != "" {
 		conf.Options.FilterDBWhitelist = []string{conf.Options.FilterDB}
 	}
@@ -246,6 +271,32 @@ func SanitizeOptions(tp string) error {
 		}
 	}
 
+	conf.Options.TargetErrPolicy = map[string]string{conf.ErrorClassDefault: conf.ErrorPolicyPanic}
+	if policies := strings.TrimSpace(conf.Options.TargetErrPolicyString); policies != "" {
+		for _, val := range strings.Split(policies, ";") {
+			pair := strings.Split(strings.TrimSpace(val), "-")
+			if len(pair) != 2 {
+				return fmt.Errorf("parse target.error_policy[%v] failed", conf.Options.TargetErrPolicyString)
+			}
+
+			class, policy := strings.ToUpper(pair[0]), strings.ToLower(pair[1])
+			if class == strings.ToUpper(conf.ErrorClassDefault) {
+				class = conf.ErrorClassDefault
+			}
+			switch policy {
+			case conf.ErrorPolicyPanic, conf.ErrorPolicyRetry, conf.ErrorPolicySkip:
+			case conf.ErrorPolicyDeadLetter:
+				if conf.Options.TargetDeadLetterFile == "" {
+					return fmt.Errorf("target.dead_letter_file should be given when the policy of error[%v] is %v",
+						class, policy)
+				}
+			default:
+				return fmt.Errorf("policy[%v] of error[%v] in target.error_policy is illegal", policy, class)
+			}
+			conf.Options.TargetErrPolicy[class] = policy
+		}
+	}
+
 	// if the target is "cluster", only allow pass db 0
 	if conf.Options.TargetType == conf.RedisTypeCluster {
 		if conf.Options.TargetDB == -1 {
@@ -300,15 +351,58 @@ func SanitizeOptions(tp string) error {
 		utils.RecvChanSize = int(conf.Options.SenderCount)
 	}
 
+	if conf.Options.ShutdownTimeout == 0 {
+		conf.Options.ShutdownTimeout = defaultShutdownTimeout
+	}
+
 	if conf.Options.SenderDelayChannelSize == 0 {
 		conf.Options.SenderDelayChannelSize = 32
 	}
 
+	if conf.Options.SpoolSize > 0 || conf.Options.SenderOverflowSize > 0 {
+		if conf.Options.SpoolDir == "" {
+			conf.Options.SpoolDir = "."
+		}
+		if err := os.MkdirAll(conf.Options.SpoolDir, os.ModePerm); err != nil {
+			return fmt.Errorf("create spool.dir[%v] failed[%v]", conf.Options.SpoolDir, err)
+		}
+	}
+
 	//ticker (0,100s], default 20ms
 	if conf.Options.SenderTickerMs <= 0 || conf.Options.SenderTickerMs > 100000 {
 		conf.Options.SenderTickerMs = 20
 	}
 
+	// the commands of the aof are sent by the writers of the incremental sync
+	incrSync := tp == conf.TypeSync || tp == conf.TypeRestore && conf.Options.SourceType == conf.SourceTypeAof
+
+	// only the incremental sync to the cluster is partitioned by slot
+	if conf.Options.SenderSlotParallel {
+		if !incrSync {
+			conf.Options.SenderSlotParallel = false
+		} else if conf.Options.TargetType != conf.RedisTypeCluster {
+			return fmt.Errorf("target.type should == cluster if enable sender.slot_parallel")
+		}
+	}
+
+	// the cluster connection can't run "multi", the transaction is sent by the writer of the node
+	if conf.Options.SenderTransaction && incrSync && conf.Options.TargetType == conf.RedisTypeCluster &&
+		!conf.Options.SenderSlotParallel {
+		return fmt.Errorf("sender.slot_parallel should be enabled if enable sender.transaction when target.type is cluster")
+	}
+	switch conf.Options.SenderTxnCrossSlot {
+	case "":
+		conf.Options.SenderTxnCrossSlot = conf.TxnCrossSlotSplit
+	case conf.TxnCrossSlotSplit, conf.ErrorPolicyPanic, conf.ErrorPolicySkip:
+	case conf.ErrorPolicyDeadLetter:
+		if conf.Options.TargetDeadLetterFile == "" {
+			return fmt.Errorf("target.dead_letter_file should be given when sender.transaction_cross_slot is %v",
+				conf.ErrorPolicyDeadLetter)
+		}
+	default:
+		return fmt.Errorf("unknown sender.transaction_cross_slot[%v]", conf.Options.SenderTxnCrossSlot)
+	}
+
 	// [0, 100 million]
 	if conf.Options.Qps < 0 || conf.Options.Qps >= 100000000 {
 		return fmt.Errorf("qps[%v] should in (0, 100000000]", conf.Options.Qps)
@@ -316,7 +410,8 @@ func SanitizeOptions(tp string) error {
 		conf.Options.Qps = 500000
 	}
 
-	if tp == conf.TypeRestore || tp == conf.TypeSync || tp == conf.TypeRump {
+	if (tp == conf.TypeRestore || tp == conf.TypeSync || tp == conf.TypeRump || tp == conf.TypeReplay) &&
+		conf.Options.TargetType != conf.TargetTypeRdb {
 		// version check is useless, we only want to verify the correctness of configuration.
 		if conf.Options.TargetVersion == "" {
 			// get target redis version and set TargetReplace.
@@ -433,8 +528,33 @@ func SanitizeOptions(tp string) error {
 		//}
 	}
 
+	// the checkpoint is listed or cleared in the storage
+	if tp == conf.TypeCheckpoint {
+		if conf.Options.CheckpointStorage == "" {
+			conf.Options.CheckpointStorage = conf.CheckpointStorageTarget
+		}
+		if err := sanitizeCheckpointStorage(); err != nil {
+			return err
+		}
+	}
+
 	// enable resume from break point
-	if conf.Options.ResumeFromBreakPoint {
+	if conf.Options.ResumeFromBreakPoint && tp == conf.TypeRestore && conf.Options.SourceType == conf.SourceTypeAof {
+		return fmt.Errorf("resume_from_break_point isn't supported when source.type is %v", conf.SourceTypeAof)
+	} else if conf.Options.ResumeFromBreakPoint && conf.Options.TargetType == conf.TargetTypeRdb {
+		return fmt.Errorf("resume_from_break_point isn't supported when target.type is %v", conf.TargetTypeRdb)
+	} else if conf.Options.ResumeFromBreakPoint && (tp == conf.TypeRump || tp == conf.TypeRestore) {
+		// rump resumes from the scan cursor and restore resumes from the rdb offset, which shouldn't be written
+		// into the target keyspace
+		if conf.Options.CheckpointStorage != conf.CheckpointStorageFile &&
+			conf.Options.CheckpointStorage != conf.CheckpointStorageRedis {
+			return fmt.Errorf("checkpoint.storage should be file or redis if enable resume_from_break_point in %v",
+				tp)
+		}
+		if err := sanitizeCheckpointStorage(); err != nil {
+			return err
+		}
+	} else if conf.Options.ResumeFromBreakPoint {
 		if tp != conf.TypeSync {
 			// set false if tp is not 'sync'
 			conf.Options.ResumeFromBreakPoint = false
@@ -452,14 +572,42 @@ func SanitizeOptions(tp string) error {
 			return fmt.Errorf("target.dbmap should only empty if enable resume_from_break_point")
 		}
 
-		// check db type
-		if conf.Options.SourceType != conf.Options.TargetType {
+		// where to store the checkpoint
+		if conf.Options.CheckpointStorage == "" {
+			conf.Options.CheckpointStorage = conf.CheckpointStorageTarget
+		}
+		if err := sanitizeCheckpointStorage(); err != nil {
+			return err
+		}
+		// only the checkpoint in the target is able to be written with the commands in the same transaction
+		switch conf.Options.CheckpointConsistency {
+		case "":
+			if conf.Options.CheckpointStorage == conf.CheckpointStorageTarget {
+				conf.Options.CheckpointConsistency = conf.CheckpointConsistencyTransaction
+			} else {
+				conf.Options.CheckpointConsistency = conf.CheckpointConsistencyAck
+			}
+		case conf.CheckpointConsistencyTransaction:
+			if conf.Options.CheckpointStorage != conf.CheckpointStorageTarget {
+				return fmt.Errorf("checkpoint.consistency[%v] is only supported when checkpoint.storage is target",
+					conf.Options.CheckpointConsistency)
+			}
+		case conf.CheckpointConsistencyAck, conf.CheckpointConsistencyReplay:
+		default:
+			return fmt.Errorf("unknown checkpoint.consistency[%v]", conf.Options.CheckpointConsistency)
+		}
+		// the checkpoint needn't hash into the node of the target
+		outsideTarget := conf.Options.CheckpointStorage != conf.CheckpointStorageTarget
+
+		// check db type, the checkpoint of each writer is written into its own node if the commands are
+		// partitioned by slot, so the source and target can be different.
+		if conf.Options.SourceType != conf.Options.TargetType && !conf.Options.SenderSlotParallel && !outsideTarget {
 			return fmt.Errorf("source type must equal to the target type when 'resume_from_break_point == true'"+
 				": source.type[%v] != target.type[%v]", conf.Options.SourceType, conf.Options.TargetType)
 		}
 
 		// check cluster nodes number
-		if conf.Options.SourceType == conf.RedisTypeCluster {
+		if conf.Options.SourceType == conf.RedisTypeCluster && !conf.Options.SenderSlotParallel && !outsideTarget {
 			if len(conf.Options.SourceAddressList) != len(conf.Options.TargetAddressList) {
 				return fmt.Errorf("source db node number must equal to the target db node when "+
 					"'resume_from_break_point == true': source[%v] != target[%v]",
@@ -487,5 +635,54 @@ func SanitizeOptions(tp string) error {
 		}
 	}
 
+	// stop the sync at the given point
+	if conf.Options.StopOffset < 0 {
+		return fmt.Errorf("stop.offset[%v] should >= 0", conf.Options.StopOffset)
+	}
+	if conf.Options.StopTimeString != "" {
+		if conf.Options.StopTimeString[0] == '@' {
+			if n, err := strconv.ParseInt(conf.Options.StopTimeString[1:], 10, 64); err != nil {
+				return fmt.Errorf("parse stop.time failed[%v]", err)
+			} else {
+				conf.Options.StopTime = time.Unix(0, n*int64(time.Millisecond))
+			}
+		} else if t, err := time.ParseInLocation("2006-01-02 15:04:05", conf.Options.StopTimeString,
+			time.Local); err != nil {
+			return fmt.Errorf("parse stop.time failed[%v]", err)
+		} else {
+			conf.Options.StopTime = t
+		}
+	}
+	if tp == conf.TypeSync && (conf.Options.StopOffset > 0 || conf.Options.StopZeroLagSeconds > 0) {
+		if conf.Options.Psync == false {
+			return fmt.Errorf("'psync' should == true if stop.offset or stop.zero_lag_seconds is given")
+		}
+		// the offset is different between the sources
+		if conf.Options.StopOffset > 0 && len(conf.Options.SourceAddressList) != 1 {
+			return fmt.Errorf("source address length[%v] should == 1 if stop.offset is given",
+				len(conf.Options.SourceAddressList))
+		}
+	}
+
+	return nil
+}
+
+func sanitizeCheckpointStorage() error {
+	switch conf.Options.CheckpointStorage {
+	case conf.CheckpointStorageTarget:
+	case conf.CheckpointStorageFile:
+		if conf.Options.CheckpointFile == "" {
+			return fmt.Errorf("checkpoint.file should be given if checkpoint.storage is file")
+		}
+	case conf.CheckpointStorageRedis:
+		if conf.Options.CheckpointAddress == "" {
+			return fmt.Errorf("checkpoint.address should be given if checkpoint.storage is redis")
+		}
+		if conf.Options.CheckpointAuthType == "" {
+			conf.Options.CheckpointAuthType = "auth"
+		}
+	default:
+		return fmt.Errorf("unknown checkpoint.storage[%v]", conf.Options.CheckpointStorage)
+	}
 	return nil
 }
@@ -92,6 +92,10 @@ type Metric struct {
 
 	FullSyncProgress     uint64
 	FakeSlaveDelayOffset uint64
+	Lag                  int64 // time.Duration
+	SpoolBytes           int64 // bytes of the commands in the overflow spool
+	SpoolCount           int64 // number of the commands in the overflow spool
+	SpoolAge             int64 // time.Duration, how long the oldest command is in the overflow spool
 }
 
 func CreateMetric(r base.Runner) {
@@ -213,9 +217,12 @@ func (m *Metric) GetFailCmdCountTotal() interface{} {
 	return atomic.LoadUint64(&m.FailCmdCount.Total)
 }
 
-func (m *Metric) AddDelay(val uint64) {
+// delay of one command from reading from the source to receiving the reply from the target
+func (m *Metric) AddDelay(dbSyncerID int, delay time.Duration) {
+	val := uint64(delay / time.Millisecond)
 	m.Delay.Set(val, 1)
 	m.AvgDelay.Set(val, 1)
+	delaySeconds.WithLabelValues(strconv.Itoa(dbSyncerID)).Observe(delay.Seconds())
 }
 
 func (m *Metric) GetDelay() interface{} {
@@ -257,6 +264,38 @@ func (m *Metric) SetFakeSlaveDelayOffset(dbSyncerID int, val uint64) {
 	fakeSlaveDelayOffset.WithLabelValues(strconv.Itoa(dbSyncerID)).Set(float64(val))
 }
 
+// lag between the source and target calculated by the replication offset
+func (m *Metric) SetLag(dbSyncerID int, lag time.Duration) {
+	atomic.StoreInt64(&m.Lag, int64(lag))
+	lagSeconds.WithLabelValues(strconv.Itoa(dbSyncerID)).Set(lag.Seconds())
+}
+
+func (m *Metric) GetLag() interface{} {
+	return time.Duration(atomic.LoadInt64(&m.Lag)).Seconds()
+}
+
+// size and age of the commands in the overflow spool
+func (m *Metric) SetSpool(dbSyncerID int, bytes, count int64, age time.Duration) {
+	atomic.StoreInt64(&m.SpoolBytes, bytes)
+	atomic.StoreInt64(&m.SpoolCount, count)
+	atomic.StoreInt64(&m.SpoolAge, int64(age))
+	spoolBytes.WithLabelValues(strconv.Itoa(dbSyncerID)).Set(float64(bytes))
+	spoolCmdCount.WithLabelValues(strconv.Itoa(dbSyncerID)).Set(float64(count))
+	spoolAgeSeconds.WithLabelValues(strconv.Itoa(dbSyncerID)).Set(age.Seconds())
+}
+
+func (m *Metric) GetSpoolBytes() interface{} {
+	return atomic.LoadInt64(&m.SpoolBytes)
+}
+
+func (m *Metric) GetSpoolCount() interface{} {
+	return atomic.LoadInt64(&m.SpoolCount)
+}
+
+func (m *Metric) GetSpoolAge() interface{} {
+	return time.Duration(atomic.LoadInt64(&m.SpoolAge)).Seconds()
+}
+
 func (m *Metric) GetFakeSlaveDelayOffset() interface{} {
 	return m.FakeSlaveDelayOffset
 }
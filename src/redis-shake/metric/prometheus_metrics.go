@@ -79,6 +79,47 @@ var (
 		},
 		[]string{dbSyncerLabelName},
 	)
+	delaySeconds = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "delay_seconds",
+			Help:      "RedisShake delay from reading the command from source to receiving the reply from target (s)",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16), // 1ms ~ 32s
+		},
+		[]string{dbSyncerLabelName},
+	)
+	lagSeconds = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "lag_seconds",
+			Help:      "RedisShake lag between source and target calculated by the replication offset (s)",
+		},
+		[]string{dbSyncerLabelName},
+	)
+	spoolBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "spool_bytes",
+			Help:      "RedisShake bytes of the commands in the overflow spool",
+		},
+		[]string{dbSyncerLabelName},
+	)
+	spoolCmdCount = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "spool_cmd_count",
+			Help:      "RedisShake number of the commands in the overflow spool",
+		},
+		[]string{dbSyncerLabelName},
+	)
+	spoolAgeSeconds = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "spool_age_seconds",
+			Help:      "RedisShake how long the oldest command is in the overflow spool (s)",
+		},
+		[]string{dbSyncerLabelName},
+	)
 	averageDelayInMs = promauto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: metricNamespace,
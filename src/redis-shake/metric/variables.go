@@ -21,12 +21,16 @@ type MetricRest struct {
 	FailCmdCountTotal    interface{}
 	Delay                interface{}
 	AvgDelay             interface{}
+	LagSeconds           interface{} // lag calculated by the replication offset
 	NetworkSpeed         interface{} // network speed
 	NetworkFlowTotal     interface{} // total network speed
 	FullSyncProgress     interface{}
 	Status               interface{}
 	SenderBufCount       interface{} // length of sender buffer
-	ProcessingCmdCount   interface{} // length of delay channel
+	SpoolBytes           interface{} // bytes of the commands in the overflow spool
+	SpoolCmdCount        interface{} // number of the commands in the overflow spool
+	SpoolAgeSeconds      interface{} // how long the oldest command is in the overflow spool
+	ProcessingCmdCount   interface{} // commands sent without receiving the replies
 	TargetDBOffset       interface{} // target redis offset
 	SourceDBOffset       interface{} // source redis offset
 	SourceMasterDBOffset interface{} // source redis master reply offset
@@ -72,11 +76,15 @@ func NewMetricRest() []MetricRest {
 			FailCmdCountTotal:    singleMetric.GetFailCmdCountTotal(),
 			Delay:                fmt.Sprintf("%s ms", singleMetric.GetDelay()),
 			AvgDelay:             fmt.Sprintf("%s ms", singleMetric.GetAvgDelay()),
+			LagSeconds:           singleMetric.GetLag(),
 			NetworkSpeed:         singleMetric.GetNetworkFlow(),
 			NetworkFlowTotal:     singleMetric.GetNetworkFlowTotal(),
 			FullSyncProgress:     singleMetric.GetFullSyncProgress(),
 			Status:               base.Status,
 			SenderBufCount:       detailMap["SenderBufCount"],
+			SpoolBytes:           singleMetric.GetSpoolBytes(),
+			SpoolCmdCount:        singleMetric.GetSpoolCount(),
+			SpoolAgeSeconds:      singleMetric.GetSpoolAge(),
 			ProcessingCmdCount:   detailMap["ProcessingCmdCount"],
 			TargetDBOffset:       detailMap["TargetDBOffset"],
 			SourceDBOffset:       detailMap["SourceDBOffset"],
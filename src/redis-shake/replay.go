@@ -0,0 +1,134 @@
+package run
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alibaba/RedisShake/pkg/libs/atomic2"
+	"github.com/alibaba/RedisShake/pkg/libs/log"
+
+	"github.com/alibaba/RedisShake/redis-shake/base"
+	utils "github.com/alibaba/RedisShake/redis-shake/common"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+	"github.com/alibaba/RedisShake/redis-shake/deadletter"
+)
+
+// replay the dead-letter files given in source.rdb.input after the root cause is fixed.
+type CmdReplay struct {
+}
+
+func (cmd *CmdReplay) GetDetailedInfo() interface{} {
+	return nil
+}
+
+func (cmd *CmdReplay) Main() {
+	log.Infof("replay from '%s' to '%s'", conf.Options.SourceRdbInput, conf.Options.TargetAddressList)
+
+	base.Status = "replay"
+	replayChan := make(chan int, len(conf.Options.SourceRdbInput))
+	for i := range conf.Options.SourceRdbInput {
+		replayChan <- i
+	}
+	close(replayChan)
+
+	var wg sync.WaitGroup
+	wg.Add(conf.Options.SourceRdbParallel)
+	for i := 0; i < conf.Options.SourceRdbParallel; i++ {
+		go func() {
+			defer wg.Done()
+			for id := range replayChan {
+				var target []string
+				if conf.Options.TargetType == conf.RedisTypeCluster {
+					target = conf.Options.TargetAddressList
+				} else {
+					// round-robin pick
+					pick := utils.PickTargetRoundRobin(len(conf.Options.TargetAddressList))
+					target = []string{conf.Options.TargetAddressList[pick]}
+				}
+
+				dr := &dbReplayer{
+					id:     id,
+					input:  conf.Options.SourceRdbInput[id],
+					target: target,
+				}
+				log.Infof("routine[%v] starts replaying data from %v to %v", dr.id, dr.input, dr.target)
+				dr.replay()
+			}
+		}()
+	}
+	wg.Wait()
+
+	log.Infof("replay from '%s' to '%s' done", conf.Options.SourceRdbInput, conf.Options.TargetAddressList)
+}
+
+/*------------------------------------------------------*/
+// one replay link corresponding to one dead-letter file
+type dbReplayer struct {
+	id     int      // id
+	input  string   // input dead-letter file
+	target []string // len >= 1 when target type is cluster, otherwise len == 1
+
+	// metric
+	nentry, ignore atomic2.Int64
+}
+
+func (dr *dbReplayer) replay() {
+	file, err := os.Open(dr.input)
+	if err != nil {
+		log.Panicf("routine[%v] open dead-letter file[%v] failed[%v]", dr.id, dr.input, err)
+	}
+	defer file.Close()
+
+	isCluster := conf.Options.TargetType == conf.RedisTypeCluster
+	c := utils.OpenRedisConn(dr.target, conf.Options.TargetAuthType, conf.Options.TargetPasswordRaw, isCluster,
+		conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify)
+	defer c.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				log.Infof("routine[%v] replay: entry=%-12d ignore=%-12d", dr.id, dr.nentry.Get(), dr.ignore.Get())
+			}
+		}
+	}()
+
+	reader := deadletter.NewReader(file)
+	lastdb := 0
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Panicf("routine[%v] read dead-letter file[%v] failed[%v]", dr.id, dr.input, err)
+		}
+
+		// only db0 is available in cluster
+		if !isCluster && entry.Db != lastdb {
+			utils.SelectDB(c, uint32(entry.Db))
+			lastdb = entry.Db
+		}
+
+		args := make([]interface{}, len(entry.Args))
+		for i, arg := range entry.Args {
+			args[i] = arg
+		}
+		dr.nentry.Incr()
+		if _, err := c.Do(entry.Command, args...); err != nil {
+			if !utils.IgnoreErrorReply(dr.input, entry.Db, entry.Command, args, err) {
+				log.Panicf("routine[%v] replay command[%s] of db[%v] failed[%v], the previous error[%v] at %v",
+					dr.id, entry.Command, entry.Db, err, entry.Error, entry.Time)
+			}
+			dr.ignore.Incr()
+		}
+	}
+	log.Infof("routine[%v] replay: entry=%-12d ignore=%-12d, done", dr.id, dr.nentry.Get(), dr.ignore.Get())
+}
@@ -1,9 +1,13 @@
 package restful
 
 import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/alibaba/RedisShake/redis-shake/checkpoint"
 	"github.com/alibaba/RedisShake/redis-shake/common"
+	"github.com/alibaba/RedisShake/redis-shake/configure"
 	"github.com/alibaba/RedisShake/redis-shake/metric"
-	"net/http"
 
 	"github.com/gugemichael/nimo4go"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -13,6 +17,7 @@ import (
 func RestAPI() {
 	registerMetric()           // register metric
 	registerPrometheusMetric() // register prometheus metrics
+	registerCheckpoint()       // register checkpoint
 	// add below if has more
 }
 
@@ -28,3 +33,40 @@ func registerPrometheusMetric() {
 		promhttp.Handler().ServeHTTP(w, req)
 	})
 }
+
+/*
+ * GET lists the checkpoints in the checkpoint.storage, DELETE clears them and returns the ones deleted.
+ * the query "name" and "source" filter the checkpoints, e.g., /checkpoint?source=10.1.1.1:6379.
+ * the GET and DELETE share the same uri, so it's registered into http directly.
+ */
+func registerCheckpoint() {
+	http.HandleFunc("/checkpoint", func(w http.ResponseWriter, req *http.Request) {
+		store := checkpoint.NewStore(conf.Options.TargetAddressList, conf.Options.TargetAuthType,
+			conf.Options.TargetPasswordRaw, conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify)
+		name, source := req.URL.Query().Get("name"), req.URL.Query().Get("source")
+
+		var records []*checkpoint.Record
+		var err error
+		switch req.Method {
+		case http.MethodGet:
+			records, err = store.List(name, source)
+		case http.MethodDelete:
+			records, err = store.Clear(name, source)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var v []byte
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			v, _ = json.Marshal(map[string]string{"error": err.Error()})
+		} else {
+			if records == nil {
+				records = []*checkpoint.Record{}
+			}
+			v, _ = json.Marshal(records)
+		}
+		w.Write(v)
+	})
+}
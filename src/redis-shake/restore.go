@@ -7,6 +7,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"strconv"
 	"sync"
@@ -14,11 +15,16 @@ import (
 
 	"github.com/alibaba/RedisShake/pkg/libs/atomic2"
 	"github.com/alibaba/RedisShake/pkg/libs/log"
+	"github.com/alibaba/RedisShake/pkg/libs/stats"
+	"github.com/alibaba/RedisShake/pkg/rdb"
 	"github.com/alibaba/RedisShake/pkg/redis"
 
+	"github.com/alibaba/RedisShake/redis-shake/aof"
 	"github.com/alibaba/RedisShake/redis-shake/base"
+	"github.com/alibaba/RedisShake/redis-shake/checkpoint"
 	utils "github.com/alibaba/RedisShake/redis-shake/common"
 	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+	"github.com/alibaba/RedisShake/redis-shake/dbSync"
 	"github.com/alibaba/RedisShake/redis-shake/filter"
 )
 
@@ -107,8 +113,39 @@ type dbRestorer struct {
 	// metric
 	rbytes, ebytes, nentry, ignore atomic2.Int64
 	forward, nbypass               atomic2.Int64
+
+	// resume from break point
+	checkpointStore checkpoint.CheckpointStore // nil if resume_from_break_point is disabled
+	runId           string                     // the rdb size, the checkpoint is invalid if it's changed
+	appliedLock     sync.Mutex
+	appliedOrdinal  int64                   // the entries <= appliedOrdinal are all applied
+	appliedEntries  map[int64]*rdb.Position // entries applied out of order
+	confirmed       *checkpoint.Checkpoint  // checkpoint after the applied entries, nil if saved
+
+	// the replay window when key_exists = none, see restoreWindowSize
+	saveLock  sync.Mutex
+	saved     *checkpoint.Checkpoint // the last saved checkpoint, nil if the window isn't used
+	window    int64                  // the entries <= window can be dispatched, it's saved before moving forward
+	replayEnd int64                  // the entries <= replayEnd may be restored before the break point
+}
+
+// one entry of the rdb with its ordinal and the position after it
+type restoreEntry struct {
+	*rdb.BinEntry
+	ordinal  int64         // starts from 1
+	position *rdb.Position // nil if it can't be resumed after the entry
+	replay   bool          // the entry may be restored before the break point
 }
 
+/*
+ * the entries restored after the last checkpoint are restored again after resuming, which fails when
+ * key_exists = none. So the loader only dispatches the entries up to the window saved in the checkpoint,
+ * and the window moves forward by restoreWindowSize entries after it's saved. After resuming, the entries
+ * after the checkpoint and up to the window are restored with REPLACE semantics, and the others still fail
+ * if the key exists.
+ */
+var restoreWindowSize int64 = 65536
+
 func (dr *dbRestorer) Stat() *cmdRestoreStat {
 	return &cmdRestoreStat{
 		rbytes: dr.rbytes.Get(),
@@ -122,14 +159,35 @@ func (dr *dbRestorer) Stat() *cmdRestoreStat {
 }
 
 func (dr *dbRestorer) restore() {
+	if conf.Options.SourceType == conf.SourceTypeAof {
+		dr.restoreAof()
+		return
+	}
+
 	readin, nsize := utils.OpenReadFile(dr.input)
 	defer readin.Close()
 	base.Status = "restore"
 
+	var resume *checkpoint.Checkpoint
+	if conf.Options.ResumeFromBreakPoint {
+		resume = dr.loadCheckpoint(nsize)
+		if resume != nil && resume.Finished {
+			log.Infof("routine[%v] rdb[%v] is finished before the checkpoint", dr.id, dr.input)
+			return
+		}
+		if resume != nil {
+			if _, err := readin.Seek(resume.Offset, io.SeekStart); err != nil {
+				log.PanicErrorf(err, "routine[%v] seek rdb[%v] to offset[%v] failed", dr.id, dr.input,
+					resume.Offset)
+			}
+			dr.rbytes.Set(resume.Offset)
+		}
+	}
+
 	reader := bufio.NewReaderSize(readin, utils.ReaderBufferSize)
 
 	dr.restoreRDBFile(reader, dr.target, conf.Options.TargetAuthType, conf.Options.TargetPasswordRaw,
-		nsize, conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify)
+		nsize, conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify, resume)
 
 	base.Status = "extra"
 	if conf.Options.ExtraInfo && (nsize == 0 || nsize != dr.rbytes.Get()) {
@@ -139,9 +197,218 @@ func (dr *dbRestorer) restore() {
 	}
 }
 
+/*
+ * restore the aof file or the multi part aof of redis 7.0. The base rdb or the rdb preamble is restored like
+ * the rdb file, and then the commands of the base aof and the incr aof are sent through the filter and the
+ * writers of the incremental sync.
+ */
+func (dr *dbRestorer) restoreAof() {
+	files, err := aof.Files(dr.input)
+	if err != nil {
+		log.Panicf("routine[%v] list files of aof[%v] failed[%v]", dr.id, dr.input, err)
+	}
+	log.Infof("routine[%v] restore aof[%v] from files %v", dr.id, dr.input, files)
+	base.Status = "restore"
+
+	readers := make([]io.Reader, 0, len(files))
+	var nsize int64 // size of the first file
+	for i, name := range files {
+		readin, size := utils.OpenReadFile(name)
+		defer readin.Close()
+		if i == 0 {
+			nsize = size
+		}
+		readers = append(readers, readin)
+	}
+
+	// the rdb is only at the beginning of the first file
+	first := bufio.NewReaderSize(readers[0], utils.ReaderBufferSize)
+	if ok, err := aof.IsRdbPreamble(first); err != nil {
+		log.PanicErrorf(err, "routine[%v] read aof file[%v] failed", dr.id, files[0])
+	} else if ok {
+		dr.restoreRDBFile(first, dr.target, conf.Options.TargetAuthType, conf.Options.TargetPasswordRaw,
+			nsize, conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify, nil)
+	}
+	readers[0] = first
+
+	ds := dbSync.NewDbSyncer(dr.id, dr.input, "", dr.target, dr.targetPassword, -1, -1, conf.Options.HttpProfile)
+	ds.ReplayCommands(bufio.NewReaderSize(io.MultiReader(readers...), utils.ReaderBufferSize))
+	log.Infof("routine[%v] restore: aof done", dr.id)
+}
+
+// load the checkpoint of the rdb, nil if not found.
+func (dr *dbRestorer) loadCheckpoint(nsize int64) *checkpoint.Checkpoint {
+	dr.checkpointStore = checkpoint.NewStore(nil, "", "", false, false)
+	dr.runId = strconv.FormatInt(nsize, 10)
+	dr.appliedEntries = make(map[int64]*rdb.Position)
+
+	cp, err := dr.checkpointStore.Load(dr.id, dr.input, utils.RestoreCheckpointKey)
+	if err != nil {
+		log.Panicf("routine[%v] load checkpoint of rdb[%v] failed[%v]", dr.id, dr.input, err)
+	}
+	if cp.Offset == -1 {
+		log.Infof("routine[%v] checkpoint of rdb[%v] not found, start from the beginning", dr.id, dr.input)
+		return nil
+	}
+	if cp.RunId != dr.runId {
+		log.Panicf("routine[%v] rdb[%v] size[%v] is different from the checkpoint[%v], please remove the checkpoint",
+			dr.id, dr.input, dr.runId, cp.RunId)
+	}
+
+	dr.appliedOrdinal = cp.KeysDone
+	dr.replayEnd = cp.Window
+	log.Infof("routine[%v] resume rdb[%v] from offset[%v] with %v entries restored, entries up to %v are restored "+
+		"again", dr.id, dr.input, cp.Offset, cp.KeysDone, cp.Window)
+	return cp
+}
+
+// mark the entry applied, the checkpoint moves forward once all the entries before are applied.
+func (dr *dbRestorer) applied(e *restoreEntry) {
+	if dr.checkpointStore == nil {
+		return
+	}
+
+	dr.appliedLock.Lock()
+	defer dr.appliedLock.Unlock()
+
+	dr.appliedEntries[e.ordinal] = e.position
+	for {
+		pos, ok := dr.appliedEntries[dr.appliedOrdinal+1]
+		if !ok {
+			break
+		}
+		delete(dr.appliedEntries, dr.appliedOrdinal+1)
+		dr.appliedOrdinal++
+		if pos != nil {
+			dr.confirmed = &checkpoint.Checkpoint{
+				RunId:    dr.runId,
+				Offset:   pos.Offset,
+				Db:       int(pos.Db),
+				Version:  utils.FcvCheckpoint.FeatureCompatibleVersion,
+				KeysDone: dr.appliedOrdinal,
+				Crc:      pos.Crc,
+			}
+		}
+	}
+}
+
+// save the latest confirmed checkpoint, it's saved again next time if failed.
+func (dr *dbRestorer) saveCheckpoint() {
+	dr.saveLock.Lock()
+	defer dr.saveLock.Unlock()
+
+	dr.appliedLock.Lock()
+	cp := dr.confirmed
+	dr.confirmed = nil
+	dr.appliedLock.Unlock()
+	if cp == nil {
+		return
+	}
+
+	if dr.saved != nil {
+		cp.Window = dr.window
+	}
+	if err := dr.checkpointStore.Save(dr.input, utils.RestoreCheckpointKey, cp); err != nil {
+		log.Warnf("routine[%v] save checkpoint of rdb[%v] offset[%v] failed[%v]", dr.id, dr.input, cp.Offset, err)
+		dr.appliedLock.Lock()
+		if dr.confirmed == nil {
+			dr.confirmed = cp
+		}
+		dr.appliedLock.Unlock()
+	} else if dr.saved != nil {
+		dr.saved = cp
+	}
+}
+
+// move the window forward before dispatching the entry after it, the entry waits until the window is saved.
+func (dr *dbRestorer) moveWindow(ordinal int64) {
+	dr.saveLock.Lock()
+	defer dr.saveLock.Unlock()
+
+	cp := *dr.saved
+	cp.Window = ordinal - 1 + restoreWindowSize
+	for {
+		err := dr.checkpointStore.Save(dr.input, utils.RestoreCheckpointKey, &cp)
+		if err == nil {
+			break
+		}
+		log.Warnf("routine[%v] save checkpoint of rdb[%v] window[%v] failed[%v]", dr.id, dr.input, cp.Window, err)
+		time.Sleep(time.Second)
+	}
+	dr.saved = &cp
+	dr.window = cp.Window
+}
+
+/*
+ * load the rdb like utils.NewRDBLoader, the position after each entry is recorded if resume_from_break_point
+ * is enabled. the loader continues from the checkpoint if resume is given and the checksum is still validated.
+ */
+func (dr *dbRestorer) loadRDB(reader *bufio.Reader, resume *checkpoint.Checkpoint) chan *restoreEntry {
+	pipe := make(chan *restoreEntry, base.RDBPipeSize)
+	go func() {
+		defer close(pipe)
+		var l *rdb.Loader
+		var ordinal int64
+		if resume != nil {
+			l = rdb.NewLoaderAt(stats.NewCountReader(reader, &dr.rbytes), rdb.Position{
+				Offset: resume.Offset,
+				Crc:    resume.Crc,
+				Db:     uint32(resume.Db),
+			})
+			ordinal = resume.KeysDone
+		} else {
+			l = rdb.NewLoader(stats.NewCountReader(reader, &dr.rbytes))
+			if err := l.Header(); err != nil {
+				log.PanicError(err, "parse rdb header error")
+			}
+		}
+		useWindow := dr.checkpointStore != nil && conf.Options.KeyExists == "none"
+		if useWindow {
+			// the window starts from the checkpoint, or the position after the header which isn't saved yet
+			if resume != nil {
+				dr.saved, dr.window = resume, resume.Window
+			} else {
+				pos, _ := l.Position()
+				dr.saved = &checkpoint.Checkpoint{
+					RunId:   dr.runId,
+					Offset:  pos.Offset,
+					Db:      int(pos.Db),
+					Version: utils.FcvCheckpoint.FeatureCompatibleVersion,
+					Crc:     pos.Crc,
+				}
+			}
+		}
+		for {
+			if entry, err := l.NextBinEntry(); err != nil {
+				log.PanicError(err, "parse rdb entry error")
+			} else if entry != nil {
+				ordinal++
+				e := &restoreEntry{BinEntry: entry, ordinal: ordinal, replay: ordinal <= dr.replayEnd}
+				if useWindow && ordinal > dr.window {
+					dr.moveWindow(ordinal)
+				}
+				if dr.checkpointStore != nil {
+					if pos, ok := l.Position(); ok {
+						e.position = &pos
+					}
+				}
+				pipe <- e
+			} else {
+				if rdb.FromVersion > 2 {
+					if err := l.Footer(); err != nil {
+						log.PanicError(err, "parse rdb checksum error")
+					}
+				}
+				return
+			}
+		}
+	}()
+	return pipe
+}
+
 func (dr *dbRestorer) restoreRDBFile(reader *bufio.Reader, target []string, auth_type, passwd string, nsize int64,
-	tlsEnable bool, tlsSkipVerify bool) {
-	pipe := utils.NewRDBLoader(reader, &dr.rbytes, base.RDBPipeSize)
+	tlsEnable bool, tlsSkipVerify bool, resume *checkpoint.Checkpoint) {
+	pipe := dr.loadRDB(reader, resume)
 	wait := make(chan struct{})
 	go func() {
 		var wg sync.WaitGroup
@@ -178,14 +445,20 @@ func (dr *dbRestorer) restoreRDBFile(reader *bufio.Reader, target []string, auth
 						}
 
 						if filter.FilterKey(string(e.Key)) {
+							dr.applied(e)
 							continue
 						}
 
 						log.Debugf("routine[%v] start restoring key[%s] with value length[%v]", dr.id, e.Key, len(e.Value))
 
-						utils.RestoreRdbEntry(c, e)
+						if e.replay {
+							utils.ReplayRdbEntry(c, e.BinEntry, dr.input, lastdb)
+						} else {
+							utils.RestoreRdbEntry(c, e.BinEntry, dr.input, lastdb)
+						}
 						log.Debugf("routine[%v] restore key[%s] ok", dr.id, e.Key)
 					}
+					dr.applied(e)
 				}
 			}()
 		}
@@ -212,6 +485,24 @@ func (dr *dbRestorer) restoreRDBFile(reader *bufio.Reader, target []string, auth
 			fmt.Fprintf(&b, "  ignore=%-12d", stat.ignore)
 		}
 		log.Info(b.String())
+
+		if dr.checkpointStore != nil {
+			dr.saveCheckpoint()
+		}
+	}
+
+	// all the entries are applied and the checksum is validated
+	if dr.checkpointStore != nil {
+		dr.appliedLock.Lock()
+		dr.confirmed = &checkpoint.Checkpoint{
+			RunId:    dr.runId,
+			Offset:   dr.rbytes.Get(),
+			Version:  utils.FcvCheckpoint.FeatureCompatibleVersion,
+			KeysDone: dr.appliedOrdinal,
+			Finished: true,
+		}
+		dr.appliedLock.Unlock()
+		dr.saveCheckpoint()
 	}
 	log.Infof("routine[%v] restore: rdb done", dr.id)
 }
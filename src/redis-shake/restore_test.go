@@ -0,0 +1,129 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/alibaba/RedisShake/pkg/rdb"
+	"github.com/alibaba/RedisShake/redis-shake/checkpoint"
+	utils "github.com/alibaba/RedisShake/redis-shake/common"
+	conf "github.com/alibaba/RedisShake/redis-shake/configure"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreResume(t *testing.T) {
+	// test resuming the restore with key_exists = none
+
+	var nr int
+
+	dir, err := ioutil.TempDir("", "restore")
+	assert.Equal(t, nil, err, "should be equal")
+	defer os.RemoveAll(dir)
+
+	conf.Options.ResumeFromBreakPoint = true
+	conf.Options.CheckpointStorage = conf.CheckpointStorageFile
+	conf.Options.CheckpointFile = filepath.Join(dir, "checkpoint.json")
+	conf.Options.KeyExists = "none"
+	restoreWindowSize = 4
+	defer func() {
+		conf.Options.ResumeFromBreakPoint = false
+		conf.Options.CheckpointStorage = ""
+		conf.Options.CheckpointFile = ""
+		conf.Options.KeyExists = ""
+		restoreWindowSize = 65536
+	}()
+
+	// 10 keys from "key1" to "key10"
+	var b bytes.Buffer
+	w := rdb.NewWriter(&b, nil)
+	for i := 1; i <= 10; i++ {
+		p, err := rdb.EncodeDump(rdb.String("v"))
+		assert.Equal(t, nil, err, "should be equal")
+		assert.Equal(t, nil, w.WriteEntry(0, []byte("key"+strconv.Itoa(i)), 0, p), "should be equal")
+	}
+	assert.Equal(t, nil, w.Close(), "should be equal")
+	nsize := int64(b.Len())
+
+	// run the loader from the checkpoint and return all the entries
+	load := func() []*restoreEntry {
+		dr := &dbRestorer{input: "dump.rdb"}
+		resume := dr.loadCheckpoint(nsize)
+		r := bytes.NewReader(b.Bytes())
+		if resume != nil {
+			r.Seek(resume.Offset, 0)
+		}
+		var entries []*restoreEntry
+		for e := range dr.loadRDB(bufio.NewReader(r), resume) {
+			entries = append(entries, e)
+		}
+		// only the first 3 entries are restored before the break point
+		for _, e := range entries {
+			if e.ordinal <= 3 {
+				dr.applied(e)
+			}
+		}
+		dr.saveCheckpoint()
+		return entries
+	}
+	loadCheckpoint := func() *checkpoint.Checkpoint {
+		cp, err := checkpoint.NewStore(nil, "", "", false, false).Load(0, "dump.rdb", utils.RestoreCheckpointKey)
+		assert.Equal(t, nil, err, "should be equal")
+		return cp
+	}
+
+	{
+		fmt.Printf("TestRestoreResume case %d.\n", nr)
+		nr++
+
+		// the window is saved before the entries after it are dispatched
+		entries := load()
+		assert.Equal(t, 10, len(entries), "should be equal")
+		for _, e := range entries {
+			assert.Equal(t, false, e.replay, "should be equal")
+		}
+		cp := loadCheckpoint()
+		assert.Equal(t, int64(3), cp.KeysDone, "should be equal")
+		assert.Equal(t, int64(12), cp.Window, "should be equal")
+		assert.Equal(t, false, cp.Finished, "should be equal")
+	}
+
+	{
+		fmt.Printf("TestRestoreResume case %d.\n", nr)
+		nr++
+
+		// all the entries after the checkpoint are in the window
+		entries := load()
+		assert.Equal(t, 7, len(entries), "should be equal")
+		for i, e := range entries {
+			assert.Equal(t, int64(i+4), e.ordinal, "should be equal")
+			assert.Equal(t, "key"+strconv.Itoa(i+4), string(e.Key), "should be equal")
+			assert.Equal(t, true, e.replay, "should be equal")
+		}
+	}
+
+	{
+		fmt.Printf("TestRestoreResume case %d.\n", nr)
+		nr++
+
+		// only the entries up to the window are restored again
+		cp := loadCheckpoint()
+		cp.Window = 6
+		assert.Equal(t, nil, checkpoint.NewStore(nil, "", "", false, false).Save("dump.rdb",
+			utils.RestoreCheckpointKey, cp), "should be equal")
+
+		entries := load()
+		assert.Equal(t, 7, len(entries), "should be equal")
+		for _, e := range entries {
+			assert.Equal(t, e.ordinal <= 6, e.replay, "should be equal")
+		}
+		// moved from the window of the checkpoint
+		assert.Equal(t, int64(10), loadCheckpoint().Window, "should be equal")
+	}
+}
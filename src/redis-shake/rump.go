@@ -1,14 +1,19 @@
 package run
 
 import (
+	"bufio"
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"sync"
 
 	"github.com/alibaba/RedisShake/pkg/libs/atomic2"
 	"github.com/alibaba/RedisShake/pkg/libs/log"
+	"github.com/alibaba/RedisShake/pkg/rdb"
+	"github.com/alibaba/RedisShake/redis-shake/base"
+	"github.com/alibaba/RedisShake/redis-shake/checkpoint"
 	utils "github.com/alibaba/RedisShake/redis-shake/common"
 	conf "github.com/alibaba/RedisShake/redis-shake/configure"
 	"github.com/alibaba/RedisShake/redis-shake/filter"
@@ -113,6 +118,31 @@ func (dr *dbRumper) run() {
 	var wg sync.WaitGroup
 	wg.Add(count)
 	for i := 0; i < count; i++ {
+		sourceClient := utils.OpenRedisConn([]string{dr.address}, conf.Options.SourceAuthType,
+			conf.Options.SourcePasswordRaw, false, conf.Options.SourceTLSEnable, conf.Options.SourceTLSSkipVerify)
+
+		var tencentNodeId string
+		if len(dr.tencentNodes) > 0 {
+			tencentNodeId = dr.tencentNodes[i]
+		}
+
+		// write the keys into the rdb file of each node
+		if conf.Options.TargetType == conf.TargetTypeRdb {
+			executor := NewDbRumperExecutor(dr.id, i, dr.address, sourceClient, nil, nil, tencentNodeId)
+			if count == 1 {
+				executor.output = fmt.Sprintf("%s.%d", conf.Options.TargetRdbOutput, dr.id)
+			} else {
+				executor.output = fmt.Sprintf("%s.%d.%d", conf.Options.TargetRdbOutput, dr.id, i)
+			}
+			dr.executors[i] = executor
+
+			go func() {
+				defer wg.Done()
+				executor.exec()
+			}()
+			continue
+		}
+
 		var target []string
 		if conf.Options.TargetType == conf.RedisTypeCluster {
 			target = conf.Options.TargetAddressList
@@ -122,20 +152,14 @@ func (dr *dbRumper) run() {
 			target = []string{conf.Options.TargetAddressList[pick]}
 		}
 
-		var tencentNodeId string
-		if len(dr.tencentNodes) > 0 {
-			tencentNodeId = dr.tencentNodes[i]
-		}
-
-		sourceClient := utils.OpenRedisConn([]string{dr.address}, conf.Options.SourceAuthType,
-			conf.Options.SourcePasswordRaw, false, conf.Options.SourceTLSEnable, conf.Options.SourceTLSSkipVerify)
 		targetClient := utils.OpenRedisConn(target, conf.Options.TargetAuthType,
 			conf.Options.TargetPasswordRaw, conf.Options.TargetType == conf.RedisTypeCluster,
 			conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify)
 		targetBigKeyClient := utils.OpenRedisConn(target, conf.Options.TargetAuthType,
 			conf.Options.TargetPasswordRaw, conf.Options.TargetType == conf.RedisTypeCluster,
 			conf.Options.TargetTLSEnable, conf.Options.TargetTLSSkipVerify)
-		executor := NewDbRumperExecutor(dr.id, i, sourceClient, targetClient, targetBigKeyClient, tencentNodeId)
+		executor := NewDbRumperExecutor(dr.id, i, dr.address, sourceClient, targetClient, targetBigKeyClient,
+			tencentNodeId)
 		dr.executors[i] = executor
 
 		go func() {
@@ -183,11 +207,13 @@ func (dr *dbRumper) getNode() (int, error) {
 type dbRumperExecutor struct {
 	rumperId           int        // father id
 	executorId         int        // current id, also == aliyun cluster node id
+	sourceAddress      string     // source address
 	sourceClient       redis.Conn // source client
 	targetClient       redis.Conn // target client
 	tencentNodeId      string     // tencent cluster node id
 	targetBigKeyClient redis.Conn // target client only used in big key, this is a bit ugly
 	previousDb         int        // store previous db
+	output             string     // the rdb file written instead of the target if target.type is rdb
 
 	keyChan    chan *KeyNode // keyChan is used to communicated between routine1 and routine2
 	resultChan chan *KeyNode // resultChan is used to communicated between routine2 and routine3
@@ -200,13 +226,21 @@ type dbRumperExecutor struct {
 	dbList    []int32 // db list
 	keyNumber int64   // key in this db number
 	close     bool    // is finish?
+
+	// resume from break point
+	checkpointStore  checkpoint.CheckpointStore // nil if resume_from_break_point is disabled
+	checkpointSource string                     // checkpoint field prefix of this executor
+	resume           *checkpoint.Checkpoint     // checkpoint loaded, nil if not found
+	confirmedLock    sync.Mutex
+	confirmed        *checkpoint.Checkpoint // checkpoint whose keys are all acknowledged, nil if saved
 }
 
-func NewDbRumperExecutor(rumperId, executorId int, sourceClient, targetClient, targetBigKeyClient redis.Conn,
-	tencentNodeId string) *dbRumperExecutor {
+func NewDbRumperExecutor(rumperId, executorId int, sourceAddress string, sourceClient, targetClient,
+	targetBigKeyClient redis.Conn, tencentNodeId string) *dbRumperExecutor {
 	executor := &dbRumperExecutor{
 		rumperId:           rumperId,
 		executorId:         executorId,
+		sourceAddress:      sourceAddress,
 		sourceClient:       sourceClient,
 		targetClient:       targetClient,
 		tencentNodeId:      tencentNodeId,
@@ -227,6 +261,9 @@ type KeyNode struct {
 	value string
 	pttl  int64
 	db    int
+
+	// not nil if it's the scanned position instead of a key, it's confirmed after all the previous keys
+	checkpoint *checkpoint.Checkpoint
 }
 
 type dbRumperExexutorStats struct {
@@ -287,6 +324,10 @@ func (dre *dbRumperExecutor) exec() {
 		log.Panic(err)
 	}
 
+	if conf.Options.ResumeFromBreakPoint {
+		dre.loadCheckpoint()
+	}
+
 	/*
 	 * we start 4 routines to run:
 	 * 1. fetch keys from the source redis
@@ -296,11 +337,16 @@ func (dre *dbRumperExecutor) exec() {
 	// routine1
 	go dre.fetcher()
 
-	// routine3
-	go dre.writer()
+	if dre.output != "" {
+		// the keys are written into the rdb file without the receiver
+		go dre.rdbWriter()
+	} else {
+		// routine3
+		go dre.writer()
 
-	// routine4
-	go dre.receiver()
+		// routine4
+		go dre.receiver()
+	}
 
 	// start metric
 	for range time.NewTicker(1 * time.Second).C {
@@ -308,6 +354,10 @@ func (dre *dbRumperExecutor) exec() {
 			break
 		}
 
+		if dre.checkpointStore != nil {
+			dre.saveCheckpoint()
+		}
+
 		var b bytes.Buffer
 		if dre.keyNumber != 0 {
 			fmt.Fprintf(&b, "dbRumper[%v] total = %v(keys) - %10v(keys) [%3d%%]  entry=%-12d",
@@ -318,17 +368,80 @@ func (dre *dbRumperExecutor) exec() {
 		}
 	}
 
+	if dre.checkpointStore != nil {
+		dre.saveCheckpoint()
+	}
+
 	log.Infof("dbRumper[%v] executor[%v] finish!", dre.rumperId, dre.executorId)
 }
 
+// load the checkpoint of this executor, the source node id is used in the special cloud.
+func (dre *dbRumperExecutor) loadCheckpoint() {
+	dre.checkpointStore = checkpoint.NewStore(nil, "", "", false, false)
+	if dre.tencentNodeId != "" {
+		dre.checkpointSource = fmt.Sprintf("%s-%s", dre.sourceAddress, dre.tencentNodeId)
+	} else {
+		dre.checkpointSource = fmt.Sprintf("%s-%d", dre.sourceAddress, dre.executorId)
+	}
+
+	cp, err := dre.checkpointStore.Load(dre.rumperId, dre.checkpointSource, utils.RumpCheckpointKey)
+	if err != nil {
+		log.Panicf("dbRumper[%v] executor[%v] load checkpoint failed[%v]", dre.rumperId, dre.executorId, err)
+	}
+	if cp.Offset == -1 {
+		log.Infof("dbRumper[%v] executor[%v] checkpoint not found, start from the beginning", dre.rumperId,
+			dre.executorId)
+		return
+	}
+
+	dre.resume = cp
+	log.Infof("dbRumper[%v] executor[%v] resume from db[%v] position[%v] finished[%v] with %v keys done",
+		dre.rumperId, dre.executorId, cp.Db, cp.Offset, cp.Finished, cp.KeysDone)
+}
+
+// called by the receiver after all the keys before the checkpoint are acknowledged.
+func (dre *dbRumperExecutor) confirmCheckpoint(cp *checkpoint.Checkpoint) {
+	dre.confirmedLock.Lock()
+	dre.confirmed = cp
+	dre.confirmedLock.Unlock()
+}
+
+// save the latest confirmed checkpoint, it's saved again next time if failed.
+func (dre *dbRumperExecutor) saveCheckpoint() {
+	dre.confirmedLock.Lock()
+	cp := dre.confirmed
+	dre.confirmed = nil
+	dre.confirmedLock.Unlock()
+	if cp == nil {
+		return
+	}
+
+	if err := dre.checkpointStore.Save(dre.checkpointSource, utils.RumpCheckpointKey, cp); err != nil {
+		log.Warnf("dbRumper[%v] executor[%v] save checkpoint db[%v] position[%v] failed[%v]", dre.rumperId,
+			dre.executorId, cp.Db, cp.Offset, err)
+		dre.confirmedLock.Lock()
+		if dre.confirmed == nil {
+			dre.confirmed = cp
+		}
+		dre.confirmedLock.Unlock()
+	}
+}
+
+// the db is scanned before the checkpoint.
+func (dre *dbRumperExecutor) skipDb(db int) bool {
+	return dre.resume != nil && (db < dre.resume.Db || db == dre.resume.Db && dre.resume.Finished)
+}
+
 func (dre *dbRumperExecutor) fetcher() {
 	log.Infof("dbRumper[%v] executor[%v] start fetcher with special-cloud[%v]", dre.rumperId, dre.executorId,
 		conf.Options.ScanSpecialCloud)
 
 	log.Infof("dbRumper[%v] executor[%v] fetch db list: %v", dre.rumperId, dre.executorId, dre.dbList)
 
-	//dump function
-	if functions, err := dre.sourceClient.Do("FUNCTION", "DUMP"); err != nil {
+	//dump function, it's restored before the checkpoint if resuming
+	if dre.resume != nil {
+		log.Infof("dbRumper[%v] executor[%v] skip function on resuming", dre.rumperId, dre.executorId)
+	} else if functions, err := dre.sourceClient.Do("FUNCTION", "DUMP"); err != nil {
 		if err.Error() != "ERR unknown command 'FUNCTION'" {
 			log.Panic(err)
 		}
@@ -344,10 +457,18 @@ func (dre *dbRumperExecutor) fetcher() {
 
 	// iterate all db nodes
 	for _, db := range dre.dbList {
+		if base.ShuttingDown() {
+			break
+		}
 		if filter.FilterDB(int(db)) {
 			log.Infof("dbRumper[%v] executor[%v] db[%v] filtered", dre.rumperId, dre.executorId, db)
 			continue
 		}
+		if dre.skipDb(int(db)) {
+			log.Infof("dbRumper[%v] executor[%v] db[%v] finished before the checkpoint", dre.rumperId,
+				dre.executorId, db)
+			continue
+		}
 
 		log.Infof("dbRumper[%v] executor[%v] fetch logical db: %v", dre.rumperId, dre.executorId, db)
 		if err := dre.doFetch(int(db)); err != nil {
@@ -372,6 +493,12 @@ func (dre *dbRumperExecutor) writer() {
 		/*if filter.FilterKey(ele.key) {
 			continue
 		}*/
+		// the checkpoint is passed to the receiver in order without sending
+		if ele.checkpoint != nil {
+			batch = append(batch, ele)
+			continue
+		}
+
 		// QoS, limit the qps
 		<-bucket
 
@@ -427,12 +554,7 @@ func (dre *dbRumperExecutor) writer() {
 			preDb = ele.db
 		}
 
-		if conf.Options.KeyExists == "rewrite" {
-			err = dre.targetClient.Send("RESTORE", ele.key, ele.pttl, ele.value, "REPLACE")
-		} else {
-			err = dre.targetClient.Send("RESTORE", ele.key, ele.pttl, ele.value)
-		}
-		if err != nil {
+		if err = dre.targetClient.Send("RESTORE", restoreArgs(ele)...); err != nil {
 			log.Panicf("dbRumper[%v] executor[%v] send key[%v] failed[%v]", dre.rumperId, dre.executorId,
 				ele.key, err)
 		}
@@ -455,6 +577,79 @@ func (dre *dbRumperExecutor) writer() {
 	close(dre.resultChan)
 }
 
+/*
+ * write the dumped keys into the rdb file instead of the target redis, the functions are written at first and
+ * then the keys with the absolute expire time. The file is complete once the fetcher finishes or stops on
+ * shutdown.
+ */
+func (dre *dbRumperExecutor) rdbWriter() {
+	log.Infof("dbRumper[%v] executor[%v] write rdb file[%v]", dre.rumperId, dre.executorId, dre.output)
+	file := utils.OpenWriteFile(dre.output)
+	defer file.Close()
+	buffer := bufio.NewWriterSize(file, utils.WriterBufferSize)
+
+	aux := map[string]string{
+		"redis-bits": "64",
+		"ctime":      strconv.FormatInt(time.Now().Unix(), 10),
+	}
+	if conf.Options.SourceVersion != "" {
+		aux["redis-ver"] = conf.Options.SourceVersion
+	}
+	w := rdb.NewWriter(buffer, aux)
+
+	for ele := range dre.keyChan {
+		if ele.checkpoint != nil {
+			continue
+		}
+
+		// function dump
+		if ele.key == "" {
+			if ele.value == "" {
+				continue
+			}
+			if err := w.WriteFunctions(utils.String2Bytes(ele.value)); err != nil {
+				log.Panicf("dbRumper[%v] executor[%v] write functions into rdb file[%v] failed[%v]",
+					dre.rumperId, dre.executorId, dre.output, err)
+			}
+			continue
+		}
+
+		// the key is expired or deleted after scanning
+		if ele.pttl == -2 || ele.value == "" {
+			log.Debugf("dbRumper[%v] executor[%v] skip key %s for expired", dre.rumperId, dre.executorId, ele.key)
+			continue
+		}
+		var expireAt uint64
+		if ele.pttl > 0 {
+			expireAt = uint64(time.Now().UnixNano()/int64(time.Millisecond) + ele.pttl)
+		}
+		if conf.Options.TargetDB != -1 {
+			ele.db = conf.Options.TargetDB
+		} else if tdb, ok := conf.Options.TargetDBMap[int(ele.db)]; ok {
+			ele.db = tdb
+		}
+
+		if err := w.WriteEntry(uint32(ele.db), utils.String2Bytes(ele.key), expireAt,
+			utils.String2Bytes(ele.value)); err != nil {
+			log.Panicf("dbRumper[%v] executor[%v] write key[%v] into rdb file[%v] failed[%v]", dre.rumperId,
+				dre.executorId, ele.key, dre.output, err)
+		}
+		dre.stat.wCommands.Incr()
+		dre.stat.wBytes.Add(int64(len(ele.value)))
+		dre.stat.cCommands.Incr()
+	}
+
+	if err := w.Close(); err != nil {
+		log.Panicf("dbRumper[%v] executor[%v] write the footer of rdb file[%v] failed[%v]", dre.rumperId,
+			dre.executorId, dre.output, err)
+	}
+	utils.FlushWriter(buffer)
+	log.Infof("dbRumper[%v] executor[%v] write rdb file[%v] with %v keys done", dre.rumperId, dre.executorId,
+		dre.output, dre.stat.cCommands.Get())
+
+	dre.close = true
+}
+
 func (dre *dbRumperExecutor) writeSend(batch []*KeyNode, count *uint32, wBytes *int64) []*KeyNode {
 	newBatch := make([]*KeyNode, 0, conf.Options.ScanKeyNumber)
 	if len(batch) == 0 {
@@ -481,7 +676,18 @@ func (dre *dbRumperExecutor) writeSend(batch []*KeyNode, count *uint32, wBytes *
 
 func (dre *dbRumperExecutor) receiver() {
 	for ele := range dre.resultChan {
+		if ele.checkpoint != nil {
+			dre.confirmCheckpoint(ele.checkpoint)
+			continue
+		}
+
 		if _, err := dre.targetClient.Receive(); err != nil && err != redis.ErrNil {
+			// the key may be skipped or written into the dead-letter file, but not the function
+			if ele.key != "" && utils.IgnoreErrorReply(dre.sourceAddress, ele.db, "restore", restoreArgs(ele), err) {
+				dre.stat.cCommands.Incr()
+				continue
+			}
+
 			rdbVersion, checksum, checkErr := utils.CheckVersionChecksum(utils.String2Bytes(ele.value))
 			log.Panicf("dbRumper[%v] executor[%v] restore key[%v] error[%v]: pttl[%v], value length[%v], "+
 				"rdb version[%v], checksum[%v], check error[%v]",
@@ -494,6 +700,14 @@ func (dre *dbRumperExecutor) receiver() {
 	dre.close = true
 }
 
+// arguments of the "restore" command of the key
+func restoreArgs(ele *KeyNode) []interface{} {
+	if conf.Options.KeyExists == "rewrite" {
+		return []interface{}{ele.key, ele.pttl, ele.value, "REPLACE"}
+	}
+	return []interface{}{ele.key, ele.pttl, ele.value}
+}
+
 func (dre *dbRumperExecutor) getSourceDbList() ([]int32, int64, error) {
 	// tencent cluster only has 1 logical db
 	if conf.Options.ScanSpecialCloud == utils.TencentCluster {
@@ -514,6 +728,10 @@ func (dre *dbRumperExecutor) getSourceDbList() ([]int32, int64, error) {
 				total += number
 			}
 		}
+		// the checkpoint requires the same order after restarting
+		sort.Slice(list, func(i, j int) bool {
+			return list[i] < list[j]
+		})
 		return list, total, nil
 	}
 }
@@ -531,9 +749,26 @@ func (dre *dbRumperExecutor) doFetch(db int) error {
 
 	// selecting target db is moving into writer
 
+	// continue from the checkpoint
+	var keysDone int64
+	if dre.resume != nil && db == dre.resume.Db {
+		if err := dre.scanner.SeekTo(dre.resume.Offset); err != nil {
+			return fmt.Errorf("seek to position[%v] failed[%v]", dre.resume.Offset, err)
+		}
+		keysDone = dre.resume.KeysDone
+		log.Infof("dbRumper[%v] executor[%v] continue fetching db[%v] from position[%v]", dre.rumperId,
+			dre.executorId, db, dre.resume.Offset)
+	}
+
 	log.Infof("dbRumper[%v] executor[%v] start fetching node db[%v]", dre.rumperId, dre.executorId, db)
 
 	for {
+		// stop scanning on shutdown, the keys fetched are still written into the target
+		if base.ShuttingDown() {
+			log.Infof("dbRumper[%v] executor[%v] stop fetching db[%v] on shutdown", dre.rumperId, dre.executorId, db)
+			return nil
+		}
+
 		rawKeys, err := dre.scanner.ScanKey()
 		if err != nil {
 			return err
@@ -586,10 +821,23 @@ func (dre *dbRumperExecutor) doFetch(db int) error {
 				dre.stat.minSize = int64(math.Min(float64(dre.stat.minSize), float64(length)))
 				dre.stat.maxSize = int64(math.Max(float64(dre.stat.maxSize), float64(length)))
 				dre.stat.sumSize += int64(length)
-				dre.keyChan <- &KeyNode{k, dumps[i], pttls[i], db}
+				dre.keyChan <- &KeyNode{k, dumps[i], pttls[i], db, nil}
 			}
 		}
 
+		// the position is confirmed after all the keys scanned before are acknowledged
+		keysDone += int64(len(rawKeys))
+		if dre.checkpointStore != nil {
+			dre.keyChan <- &KeyNode{db: db, checkpoint: &checkpoint.Checkpoint{
+				RunId:    "?",
+				Offset:   dre.scanner.Position(),
+				Db:       db,
+				Version:  utils.FcvCheckpoint.FeatureCompatibleVersion,
+				KeysDone: keysDone,
+				Finished: dre.scanner.EndNode(),
+			}}
+		}
+
 		// Last iteration of scan.
 		if dre.scanner.EndNode() {
 			break
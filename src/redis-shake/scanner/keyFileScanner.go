@@ -10,7 +10,8 @@ import (
 type KeyFileScanner struct {
 	f       *os.File
 	bufScan *bufio.Scanner
-	cnt     int // mark the number of this scan. init: -1
+	cnt     int   // mark the number of this scan. init: -1
+	line    int64 // number of lines read
 }
 
 func (kfs *KeyFileScanner) ScanKey() ([]string, error) {
@@ -20,6 +21,7 @@ func (kfs *KeyFileScanner) ScanKey() ([]string, error) {
 	}
 
 	kfs.cnt = len(keys)
+	kfs.line += int64(len(keys))
 
 	return keys, kfs.bufScan.Err()
 }
@@ -28,6 +30,18 @@ func (kfs *KeyFileScanner) EndNode() bool {
 	return kfs.cnt != int(conf.Options.ScanKeyNumber)
 }
 
+func (kfs *KeyFileScanner) Position() int64 {
+	return kfs.line
+}
+
+// skip the lines read before
+func (kfs *KeyFileScanner) SeekTo(position int64) error {
+	for kfs.line < position && kfs.bufScan.Scan() {
+		kfs.line++
+	}
+	return kfs.bufScan.Err()
+}
+
 func (kfs *KeyFileScanner) Close() {
 	kfs.f.Close()
 }
@@ -33,6 +33,15 @@ func (ns *NormalScanner) EndNode() bool {
 	return ns.cursor == 0
 }
 
+func (ns *NormalScanner) Position() int64 {
+	return ns.cursor
+}
+
+func (ns *NormalScanner) SeekTo(position int64) error {
+	ns.cursor = position
+	return nil
+}
+
 func (ns *NormalScanner) Close() {
 	ns.client.Close()
 }
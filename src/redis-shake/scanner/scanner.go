@@ -17,6 +17,12 @@ type Scanner interface {
 	// end current node
 	EndNode() bool
 
+	// return the position to continue scanning: the cursor, or the number of lines read from the key file
+	Position() int64
+
+	// continue scanning from the position returned by Position
+	SeekTo(position int64) error
+
 	Close()
 }
 
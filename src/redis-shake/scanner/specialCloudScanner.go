@@ -47,6 +47,15 @@ func (scs *SpecialCloudScanner) EndNode() bool {
 	return scs.cursor == 0
 }
 
+func (scs *SpecialCloudScanner) Position() int64 {
+	return scs.cursor
+}
+
+func (scs *SpecialCloudScanner) SeekTo(position int64) error {
+	scs.cursor = position
+	return nil
+}
+
 func (scs *SpecialCloudScanner) Close() {
 	scs.client.Close()
 }
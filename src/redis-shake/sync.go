@@ -8,6 +8,7 @@ import (
 
 	"github.com/alibaba/RedisShake/pkg/libs/log"
 
+	"github.com/alibaba/RedisShake/redis-shake/base"
 	"github.com/alibaba/RedisShake/redis-shake/common"
 	"github.com/alibaba/RedisShake/redis-shake/configure"
 	"github.com/alibaba/RedisShake/redis-shake/dbSync"
@@ -42,7 +43,8 @@ func (cmd *CmdSync) Main() {
 
 	var slotDistribution []utils.SlotOwner
 	var err error
-	if conf.Options.SourceType == conf.RedisTypeCluster && conf.Options.ResumeFromBreakPoint {
+	// the slot boundary is used to name the checkpoint and find the new master after the source failover
+	if conf.Options.SourceType == conf.RedisTypeCluster {
 		if slotDistribution, err = utils.GetSlotDistribution(conf.Options.SourceAddressList[0], conf.Options.SourceAuthType,
 			conf.Options.SourcePasswordRaw, false, false); err != nil {
 			log.Errorf("get source slot distribution failed: %v", err)
@@ -86,7 +88,7 @@ func (cmd *CmdSync) Main() {
 		go func() {
 			for {
 				nd, ok := <-syncChan
-				if !ok {
+				if !ok || base.ShuttingDown() {
 					break
 				}
 
@@ -105,9 +107,41 @@ func (cmd *CmdSync) Main() {
 		}()
 	}
 
-	wg.Wait()
-	close(syncChan)
+	fullDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(syncChan)
+		close(fullDone)
+	}()
+	select {
+	case <-fullDone:
+	case <-base.ShutdownContext().Done():
+	}
+
+	waitSyncersStop(cmd.dbSyncers, base.ShutdownContext().Done())
+	log.Infof("all the syncers stop")
+}
 
-	// never quit because increment syncing is always running
-	select {}
+/*
+ * increment syncing is always running unless stopping at the stop point or shutdown. On shutdown, the
+ * syncers in the full sync quit directly because there's no checkpoint of the rdb, while the others are
+ * waited until the received commands are applied and the checkpoint is saved.
+ */
+func waitSyncersStop(dbSyncers []*dbSync.DbSyncer, shutdown <-chan struct{}) {
+	for _, ds := range dbSyncers {
+		if ds == nil {
+			continue
+		}
+		select {
+		case <-ds.WaitFull:
+		case <-shutdown:
+			// both are ready if the syncer is in the increment syncing, which one is picked is random
+			select {
+			case <-ds.WaitFull:
+			default:
+				continue
+			}
+		}
+		<-ds.WaitStop
+	}
 }
@@ -0,0 +1,91 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alibaba/RedisShake/redis-shake/dbSync"
+)
+
+func TestWaitSyncersStop(t *testing.T) {
+	// test waiting for the syncers on shutdown
+
+	var nr int
+
+	newSyncer := func() *dbSync.DbSyncer {
+		return &dbSync.DbSyncer{
+			WaitFull: make(chan struct{}),
+			WaitStop: make(chan struct{}),
+		}
+	}
+	// run waitSyncersStop and return the channel closed once it returns
+	wait := func(dbSyncers []*dbSync.DbSyncer, shutdown <-chan struct{}) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			waitSyncersStop(dbSyncers, shutdown)
+			close(done)
+		}()
+		return done
+	}
+
+	{
+		fmt.Printf("TestWaitSyncersStop case %d.\n", nr)
+		nr++
+
+		// the syncers in the increment syncing are always waited, whichever ready case is picked
+		for i := 0; i < 100; i++ {
+			full, incr := newSyncer(), newSyncer()
+			close(incr.WaitFull)
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			done := wait([]*dbSync.DbSyncer{full, nil, incr}, ctx.Done())
+			select {
+			case <-done:
+				t.Fatalf("return before the syncer in the increment syncing stops in round %d", i)
+			case <-time.After(time.Millisecond):
+			}
+			close(incr.WaitStop)
+			<-done
+		}
+	}
+
+	{
+		fmt.Printf("TestWaitSyncersStop case %d.\n", nr)
+		nr++
+
+		// the syncer in the full sync quits directly once shutdown
+		ds := newSyncer()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := wait([]*dbSync.DbSyncer{ds}, ctx.Done())
+		select {
+		case <-done:
+			t.Fatal("return before shutdown")
+		case <-time.After(10 * time.Millisecond):
+		}
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("wait for the syncer in the full sync")
+		}
+	}
+
+	{
+		fmt.Printf("TestWaitSyncersStop case %d.\n", nr)
+		nr++
+
+		// the syncer stops at the stop point without shutdown
+		ds := newSyncer()
+		done := wait([]*dbSync.DbSyncer{ds}, make(chan struct{}))
+		close(ds.WaitFull)
+		close(ds.WaitStop)
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("wait after the syncer stops")
+		}
+	}
+}